@@ -0,0 +1,241 @@
+package adapter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	em "dap/emulator"
+	"dap/scanner"
+)
+
+/* simple expressions of the debug console, watches, and hovers, over
+   the variables and constants of the stopped program, as the language
+   has them, relations bind tighter than $MULT, $DIV, $MOD, $AND, which
+   bind tighter than $PLUS, $MINUS, $OR
+*/
+
+type value struct {
+	typ string // $INT, $BOOL, or $CHAR
+	w   int
+}
+
+type evaluator struct {
+	m    *em.Machine
+	d    *em.Debugger
+	toks []scanner.Piece
+	pos  int
+}
+
+func evaluate(m *em.Machine, d *em.Debugger, expr string) (value, error) {
+	pieces, err := scanner.Pieces("", []byte(expr))
+	if err != nil {
+		return value{}, err
+	}
+	e := &evaluator{m: m, d: d}
+	for _, p := range pieces {
+		switch {
+		case p.Kind == "space" || p.Kind == "comment":
+			continue
+		case p.Kind == "number" && strings.HasPrefix(p.Text, "-") && e.operand():
+			// x -1 is x - 1
+			e.toks = append(e.toks, scanner.Piece{Kind: "operator", Text: "-", Type: "$MINUS"})
+			p.Text = p.Text[1:]
+		}
+		e.toks = append(e.toks, p)
+	}
+	if len(e.toks) == 0 {
+		return value{}, fmt.Errorf("no expression")
+	}
+	v, err := e.sum()
+	if err == nil && e.pos < len(e.toks) {
+		err = fmt.Errorf("unexpected %v", e.toks[e.pos].Text)
+	}
+	return v, err
+}
+
+// the last token ends an operand
+func (e *evaluator) operand() bool {
+	if len(e.toks) == 0 {
+		return false
+	}
+	last := e.toks[len(e.toks)-1]
+	return last.Kind == "name" || last.Kind == "number" || last.Kind == "string" ||
+		last.Type == "$RIGHTPAR" || last.Type == "$TRUE" || last.Type == "$FALSE"
+}
+
+func (e *evaluator) peek() string {
+	if e.pos < len(e.toks) {
+		return e.toks[e.pos].Type
+	}
+	return ""
+}
+
+func (e *evaluator) sum() (value, error) {
+	return e.binary(e.product, "$PLUS", "$MINUS", "$OR")
+}
+
+func (e *evaluator) product() (value, error) {
+	return e.binary(e.relation, "$MULT", "$DIV", "$MOD", "$AND")
+}
+
+func (e *evaluator) binary(operand func() (value, error), ops ...string) (value, error) {
+	x, err := operand()
+	for err == nil && contains(ops, e.peek()) {
+		op := e.toks[e.pos].Type
+		e.pos++
+		var y value
+		if y, err = operand(); err == nil {
+			x, err = e.apply(op, x, y)
+		}
+	}
+	return x, err
+}
+
+var relations = []string{"$LT", "$LEQ", "$GT", "$GEQ", "$EQ", "$NEQ"}
+
+func (e *evaluator) relation() (value, error) {
+	x, err := e.unary()
+	if err != nil || !contains(relations, e.peek()) {
+		return x, err
+	}
+	op := e.toks[e.pos].Type
+	e.pos++
+	y, err := e.unary()
+	if err != nil {
+		return x, err
+	}
+	return e.apply(op, x, y)
+}
+
+func (e *evaluator) unary() (value, error) {
+	switch e.peek() {
+	case "$MINUS":
+		e.pos++
+		x, err := e.unary()
+		if err == nil && x.typ != "$INT" {
+			err = fmt.Errorf("- of %v", kind(x.typ))
+		}
+		x.w, _ = e.m.Neg(x.w)
+		return x, err
+	case "$NOT":
+		e.pos++
+		x, err := e.unary()
+		if err == nil && x.typ != "$BOOL" {
+			err = fmt.Errorf("not of %v", kind(x.typ))
+		}
+		x.w = 1 - x.w
+		return x, err
+	}
+	return e.primary()
+}
+
+func (e *evaluator) primary() (value, error) {
+	if e.pos >= len(e.toks) {
+		return value{}, fmt.Errorf("the expression ends too soon")
+	}
+	t := e.toks[e.pos]
+	e.pos++
+	switch {
+	case t.Type == "$LEFTPAR":
+		x, err := e.sum()
+		if err == nil && e.peek() != "$RIGHTPAR" {
+			err = fmt.Errorf("missing )")
+		}
+		e.pos++
+		return x, err
+	case t.Type == "$TRUE", t.Type == "$FALSE":
+		return truth(t.Type == "$TRUE"), nil
+	case t.Kind == "number":
+		w, err := strconv.Atoi(t.Text)
+		if err != nil || !e.m.InWord(w) {
+			return value{}, fmt.Errorf("%v is not an integer of the machine", t.Text)
+		}
+		return value{"$INT", w}, nil
+	case t.Kind == "string":
+		r, size := utf8.DecodeRuneInString(t.Body)
+		if size == 0 || size != len(t.Body) {
+			return value{}, fmt.Errorf("%v is not a character", t.Text)
+		}
+		return value{"$CHAR", int(r)}, nil
+	case t.Kind == "name":
+		v, ok := e.d.Lookup(t.Text)
+		if !ok {
+			return value{}, fmt.Errorf("%v is not declared", t.Text)
+		}
+		if !v.Set {
+			return value{}, fmt.Errorf("%v is not initialized", t.Text)
+		}
+		typ := v.Typ
+		if typ == "$CHARRAY" {
+			typ = "$CHAR"
+		}
+		return value{typ, v.Word}, nil
+	}
+	return value{}, fmt.Errorf("unexpected %v", t.Text)
+}
+
+func (e *evaluator) apply(op string, x, y value) (value, error) {
+	if x.typ != y.typ {
+		return x, fmt.Errorf("%v of %v and %v", kind(op), kind(x.typ), kind(y.typ))
+	}
+	arith := map[string]bool{"$PLUS": true, "$MINUS": true, "$MULT": true, "$DIV": true, "$MOD": true}
+	if (arith[op] && x.typ != "$INT") || ((op == "$AND" || op == "$OR") && x.typ != "$BOOL") {
+		return x, fmt.Errorf("%v of %v", kind(op), kind(x.typ))
+	}
+	switch op {
+	case "$PLUS":
+		x.w, _ = e.m.Add(x.w, y.w)
+	case "$MINUS":
+		x.w, _ = e.m.Sub(x.w, y.w)
+	case "$MULT":
+		x.w, _ = e.m.Mul(x.w, y.w)
+	case "$DIV", "$MOD":
+		if y.w == 0 {
+			return x, fmt.Errorf("division by zero")
+		}
+		if op == "$DIV" {
+			x.w /= y.w
+		} else {
+			x.w %= y.w
+		}
+	case "$AND":
+		return truth(x.w != 0 && y.w != 0), nil
+	case "$OR":
+		return truth(x.w != 0 || y.w != 0), nil
+	case "$LT":
+		return truth(x.w < y.w), nil
+	case "$LEQ":
+		return truth(x.w <= y.w), nil
+	case "$GT":
+		return truth(x.w > y.w), nil
+	case "$GEQ":
+		return truth(x.w >= y.w), nil
+	case "$EQ":
+		return truth(x.w == y.w), nil
+	case "$NEQ":
+		return truth(x.w != y.w), nil
+	}
+	return x, nil
+}
+
+func truth(c bool) value {
+	if c {
+		return value{"$BOOL", 1}
+	}
+	return value{"$BOOL", 0}
+}
+
+// a token type as a word, $INT is int
+func kind(typ string) string {
+	return strings.ToLower(strings.TrimPrefix(typ, "$"))
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+/* the messages of the Debug Adapter Protocol, each after a header
+   with its Content-Length, and the parts of it the adapter uses
+*/
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"` // response
+	RequestSeq int         `json:"request_seq"`
+	Command    string      `json:"command"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"` // event
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+func readMessage(r *bufio.Reader) (*request, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// as JSON, <- is an assignment, not HTML
+func writeMessage(w io.Writer, msg interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(msg); err != nil {
+		return err
+	}
+	body := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	_, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+type Source struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type Breakpoint struct {
+	Verified bool    `json:"verified"`
+	Line     int     `json:"line,omitempty"`
+	Message  string  `json:"message,omitempty"`
+	Source   *Source `json:"source,omitempty"`
+}
+
+type StackFrame struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Source Source `json:"source"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type Scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type Variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type initializeArguments struct {
+	LinesStartAt1   *bool `json:"linesStartAt1"`
+	ColumnsStartAt1 *bool `json:"columnsStartAt1"`
+}
+
+// the launch configuration of an editor, as dap takes its flags
+type launchArguments struct {
+	Program     string `json:"program"`
+	Stdin       string `json:"stdin"`       // the input, more is given in the console
+	StopOnEntry bool   `json:"stopOnEntry"` // at the first statement
+	NoDebug     bool   `json:"noDebug"`     // run, breakpoints are left out
+	Units       string `json:"units"`       // folders, as -I
+	Keywords    string `json:"keywords"`
+	Strict      bool   `json:"strict"`
+	Word        int    `json:"word"`
+	Overflow    string `json:"overflow"` // wrap or trap
+}
+
+type setBreakpointsArguments struct {
+	Source      Source `json:"source"`
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+}
+
+type scopesArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+	Context    string `json:"context"` // watch, repl, or hover
+}
@@ -0,0 +1,390 @@
+package adapter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	em "dap/emulator"
+	"dap/parser"
+)
+
+/* a Debug Adapter Protocol server, an editor debugs a DAP program
+   with it, over a pair of streams
+
+   The program is compiled at launch, and runs in the emulator a
+   statement at a time. A breakpoint is on a line with a LINE code,
+   the next one when its line has none. Stepping in stops at the
+   next statement, stepping over at the next one of the same frame
+   or of an outer one, stepping out at the next one of an outer
+   frame, the program has one frame, stepping out runs it to its end
+   or a breakpoint. Its output is the output of the debug session,
+   its input is given at launch, or typed in the debug console when
+   it waits for more.
+*/
+
+type Server struct {
+	in     io.Reader
+	out    io.Writer
+	log    *log.Logger
+	seq    int
+	lines1 bool // lines count from 1, as the adapter counts them
+	cols1  bool // columns count from 1, the adapter counts them from 0
+
+	args     launchArguments
+	prog     *parser.Program
+	dbg      *em.Debugger
+	breaks   map[int]map[int]bool // lines of each source file
+	reported int                  // diagnostics sent as output
+
+	mode    string // how the program runs, "" when it is stopped
+	depth   int    // where the step started
+	waiting bool   // for input, mode is the one to go on with
+	pending string
+}
+
+const chunk = 10000 // steps run before the editor is heard again
+
+var ErrEnded = errors.New("the input ended before disconnect")
+
+// an adapter over in and out, its messages are logged to logger
+func NewServer(in io.Reader, out io.Writer, logger *log.Logger) *Server {
+	return &Server{in: in, out: out, log: logger, lines1: true, cols1: true, breaks: map[int]map[int]bool{}}
+}
+
+/* serve until the editor disconnects, a running program is heard
+   between its chunks of steps
+*/
+func (s *Server) Serve() error {
+	var readErr error
+	msgs := make(chan *request)
+	go func() {
+		r := bufio.NewReader(s.in)
+		for {
+			req, err := readMessage(r)
+			if err != nil {
+				readErr = err
+				close(msgs)
+				return
+			}
+			msgs <- req
+		}
+	}()
+	ended := func() error {
+		if readErr == io.EOF {
+			return ErrEnded
+		}
+		return readErr
+	}
+	for {
+		if s.mode != "" {
+			s.run(chunk)
+			select {
+			case req, ok := <-msgs:
+				if !ok {
+					msgs = nil // the program runs on until it stops
+				} else if s.handle(req) {
+					return nil
+				}
+			default:
+			}
+			continue
+		}
+		if msgs == nil {
+			return ended()
+		}
+		req, ok := <-msgs
+		if !ok {
+			return ended()
+		}
+		if s.handle(req) {
+			return nil
+		}
+	}
+}
+
+func (s *Server) send(msg interface{}) {
+	if err := writeMessage(s.out, msg); err != nil {
+		s.log.Print(err)
+	}
+}
+
+func (s *Server) respond(req *request, body interface{}) {
+	s.seq++
+	s.send(response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: true, Body: body})
+}
+
+func (s *Server) fail(req *request, format string, args ...interface{}) {
+	s.seq++
+	s.send(response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *Server) event(name string, body interface{}) {
+	s.seq++
+	s.send(event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// the program's output, or the diagnostics, as the debug session's
+type output struct {
+	s        *Server
+	category string
+}
+
+func (o output) Write(p []byte) (int, error) {
+	o.s.event("output", map[string]string{"category": o.category, "output": string(p)})
+	return len(p), nil
+}
+
+// true when the editor disconnects
+func (s *Server) handle(req *request) bool {
+	args := func(v interface{}) bool {
+		if len(req.Arguments) == 0 {
+			return true
+		}
+		if err := json.Unmarshal(req.Arguments, v); err != nil {
+			s.fail(req, "%v", err)
+			return false
+		}
+		return true
+	}
+	if s.prog == nil && req.Command != "initialize" && req.Command != "launch" && req.Command != "disconnect" {
+		s.fail(req, "Launch a program first")
+		return false
+	}
+	switch req.Command {
+	case "initialize":
+		var a initializeArguments
+		if !args(&a) {
+			return false
+		}
+		s.lines1 = a.LinesStartAt1 == nil || *a.LinesStartAt1
+		s.cols1 = a.ColumnsStartAt1 == nil || *a.ColumnsStartAt1
+		s.respond(req, map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		})
+	case "launch":
+		if !args(&s.args) {
+			return false
+		}
+		if err := s.launch(); err != nil {
+			s.fail(req, "%v", err)
+			s.event("terminated", nil)
+			return false
+		}
+		s.respond(req, nil)
+		s.event("initialized", nil) // breakpoints can be set
+	case "setBreakpoints":
+		var a setBreakpointsArguments
+		if !args(&a) {
+			return false
+		}
+		s.respond(req, map[string]interface{}{"breakpoints": s.setBreakpoints(a)})
+	case "setExceptionBreakpoints":
+		s.respond(req, nil)
+	case "configurationDone":
+		s.respond(req, nil)
+		if s.args.StopOnEntry && !s.args.NoDebug {
+			s.resume("entry")
+		} else {
+			s.resume("continue")
+		}
+	case "threads":
+		s.respond(req, map[string]interface{}{"threads": []map[string]interface{}{{"id": 1, "name": s.prog.Tree.Name}}})
+	case "stackTrace":
+		s.respond(req, map[string]interface{}{"stackFrames": []StackFrame{s.frame()}, "totalFrames": 1})
+	case "scopes":
+		s.respond(req, map[string]interface{}{"scopes": []Scope{{"Variables", 1, false}, {"Constants", 2, false}}})
+	case "variables":
+		var a variablesArguments
+		if !args(&a) {
+			return false
+		}
+		s.respond(req, map[string]interface{}{"variables": s.variables(a.VariablesReference)})
+	case "evaluate":
+		var a evaluateArguments
+		if !args(&a) {
+			return false
+		}
+		if s.waiting && a.Context == "repl" {
+			s.dbg.Input(a.Expression + "\n")
+			s.respond(req, map[string]interface{}{"result": "", "variablesReference": 0})
+			s.resume(s.pending)
+			return false
+		}
+		v, err := evaluate(s.prog.Machine, s.dbg, a.Expression)
+		if err != nil {
+			s.fail(req, "%v", err)
+			return false
+		}
+		s.respond(req, map[string]interface{}{"result": em.FormatWord(v.typ, v.w), "type": s.prog.Spelling(v.typ), "variablesReference": 0})
+	case "continue":
+		s.respond(req, map[string]bool{"allThreadsContinued": true})
+		s.resume("continue")
+	case "next", "stepIn", "stepOut":
+		s.respond(req, nil)
+		s.resume(req.Command)
+	case "pause":
+		s.respond(req, nil)
+		if s.mode != "" {
+			s.stop("pause", "")
+		}
+	case "terminate":
+		s.respond(req, nil)
+		s.mode = ""
+		s.event("terminated", nil)
+	case "disconnect":
+		s.respond(req, nil)
+		return true
+	default:
+		s.fail(req, "%v is not supported", req.Command)
+	}
+	return false
+}
+
+func (s *Server) launch() error {
+	if filepath.Ext(s.args.Program) != ".dap" {
+		return fmt.Errorf("A .dap source is debugged, not %q", s.args.Program)
+	}
+	compiler := parser.Compiler{
+		SearchPath:   filepath.SplitList(s.args.Units),
+		Keywords:     s.args.Keywords,
+		Strict:       s.args.Strict,
+		WordSize:     s.args.Word,
+		OverflowTrap: s.args.Overflow == "trap",
+		Log:          ioutil.Discard,
+	}
+	prog, err := compiler.CompileFile(s.args.Program)
+	s.prog = prog
+	s.report()
+	if err != nil {
+		s.prog = nil
+		return err
+	}
+	s.dbg = prog.Machine.Debugger(output{s, "stdout"})
+	s.dbg.Input(s.args.Stdin)
+	return nil
+}
+
+// the diagnostics not sent yet, as output
+func (s *Server) report() {
+	items := s.prog.Diagnostics.Items()
+	for ; s.reported < len(items); s.reported++ {
+		s.event("output", map[string]string{"category": "stderr", "output": items[s.reported].String() + "\n"})
+	}
+}
+
+// each breakpoint on the first line from it with code
+func (s *Server) setBreakpoints(a setBreakpointsArguments) []Breakpoint {
+	file := s.prog.Machine.SourceIndex(a.Source.Path)
+	lines := s.prog.Machine.CodeLines()[file]
+	s.breaks[file] = map[int]bool{}
+	list := []Breakpoint{}
+	for _, b := range a.Breakpoints {
+		line := b.Line
+		if !s.lines1 {
+			line++
+		}
+		found := 0
+		for _, l := range lines {
+			if l >= line {
+				found = l
+				break
+			}
+		}
+		if file < 0 || found == 0 {
+			list = append(list, Breakpoint{Verified: false, Message: "No statement of the program from this line"})
+			continue
+		}
+		s.breaks[file][found] = true
+		if !s.lines1 {
+			found--
+		}
+		list = append(list, Breakpoint{Verified: true, Line: found})
+	}
+	return list
+}
+
+func (s *Server) resume(mode string) {
+	s.mode, s.depth, s.waiting = mode, s.dbg.Depth(), false
+}
+
+func (s *Server) stop(reason, text string) {
+	s.mode = ""
+	body := map[string]interface{}{"reason": reason, "threadId": 1, "allThreadsStopped": true}
+	if text != "" {
+		body["text"] = text
+	}
+	if reason == "pause" && s.waiting {
+		body["description"] = "Waiting for input"
+	}
+	s.event("stopped", body)
+}
+
+// up to n steps, until the program stops
+func (s *Server) run(n int) {
+	for i := 0; i < n && s.mode != ""; i++ {
+		ev := s.dbg.Step()
+		s.report()
+		switch ev {
+		case em.AtLine:
+			switch {
+			case s.breaks[s.dbg.File][s.dbg.Line] && !s.args.NoDebug:
+				s.stop("breakpoint", "")
+			case s.mode == "entry":
+				s.stop("entry", "")
+			case s.mode == "stepIn",
+				s.mode == "next" && s.dbg.Depth() <= s.depth,
+				s.mode == "stepOut" && s.dbg.Depth() < s.depth:
+				s.stop("step", "")
+			}
+		case em.NeedInput:
+			s.waiting, s.pending = true, s.mode
+			s.event("output", map[string]string{"category": "console", "output": "Waiting for input, type it in the debug console\n"})
+			s.stop("pause", "")
+		case em.Failed:
+			items := s.prog.Diagnostics.Items()
+			s.stop("exception", items[len(items)-1].Message)
+		case em.Exited:
+			s.mode = ""
+			code := 0
+			if s.prog.Diagnostics.Errors() > 0 {
+				code = 1
+			}
+			s.event("exited", map[string]int{"exitCode": code})
+			s.event("terminated", nil)
+		}
+	}
+}
+
+func (s *Server) frame() StackFrame {
+	files := s.prog.Machine.SourceFiles()
+	path := ""
+	if s.dbg.File < len(files) {
+		path = files[s.dbg.File]
+	}
+	f := StackFrame{ID: 1, Name: s.prog.Tree.Name, Source: Source{Name: filepath.Base(path), Path: path}, Line: s.dbg.Line, Column: s.dbg.Col}
+	if !s.lines1 {
+		f.Line--
+	}
+	if s.cols1 {
+		f.Column++
+	}
+	return f
+}
+
+// the variables of scope 1, the constants of scope 2
+func (s *Server) variables(ref int) []Variable {
+	list := []Variable{}
+	for _, v := range s.dbg.Variables() {
+		if v.Const == (ref == 2) {
+			list = append(list, Variable{Name: v.Name, Value: v.Value, Type: s.prog.Spelling(v.Typ)})
+		}
+	}
+	return list
+}
@@ -0,0 +1,68 @@
+package adapter_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"dap/adapter"
+)
+
+/* the session of testdata/debug, each answer as in session.out, the
+   adapter runs in that folder, where the launch finds total.dap
+*/
+func TestSession(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join("..", "testdata", "debug")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+	messages, err := ioutil.ReadFile("session.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("session.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in, out bytes.Buffer
+	for _, m := range strings.Split(strings.TrimSpace(string(messages)), "\n") {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	if err := adapter.NewServer(&in, &out, log.New(ioutil.Discard, "", 0)).Serve(); err != nil {
+		t.Fatal(err)
+	}
+	answers := []string{}
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		answers = append(answers, string(body))
+	}
+	if got := strings.Join(answers, "\n") + "\n"; got != string(want) {
+		t.Errorf("the answers differ from session.out\nwant:\n%s\ngot:\n%v", want, got)
+	}
+}
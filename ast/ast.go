@@ -0,0 +1,299 @@
+package ast
+
+/* syntax tree of a DAP program, as built by the parser
+
+   Expressions carry the type and the constant value found by the parser,
+   Val is emulator.EMPTY when the value is only known at run time.
+   Statements carry the source line and indentation where they start,
+   the same positions the code generator marks with LINE.
+*/
+
+type Pos struct {
+	File      string
+	Line, Col int
+}
+
+func (p Pos) Position() Pos {
+	return p
+}
+
+type Node interface {
+	Position() Pos
+}
+
+// type and constant value of an expression
+type Typed struct {
+	Typ, Val string
+}
+
+func (t Typed) Type() string {
+	return t.Typ
+}
+
+func (t Typed) Value() string {
+	return t.Val
+}
+
+type Expr interface {
+	Node
+	Type() string
+	Value() string
+}
+
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+type Decl interface {
+	Node
+	declNode()
+}
+
+// a declared name, Val is the value of a constant
+type Symbol struct {
+	Unit   string
+	Parent string
+	Name   string
+	Typ    string
+	Val    string
+	Loc    int
+	Const  bool
+}
+
+type (
+	// variable or constant, used within Scope
+	Ident struct {
+		Pos
+		Typed
+		Name  string
+		Scope string
+		Sym   Symbol
+	}
+
+	// $NUMBER, $CHARRAY, $TRUE, or $FALSE, as written in the source
+	Literal struct {
+		Pos
+		Typed
+		Kind string
+		Text string
+	}
+
+	Paren struct {
+		Pos
+		Typed
+		X Expr
+	}
+
+	// $MINUS or $NOT
+	Unary struct {
+		Pos
+		Typed
+		Op string
+		X  Expr
+	}
+
+	// operators of the same level are left associative,
+	// relations bind tighter than $MULT, $DIV, $MOD, $AND,
+	// which bind tighter than $PLUS, $MINUS, $OR
+	Binary struct {
+		Pos
+		Typed
+		Op string
+		X  Expr
+		Y  Expr
+	}
+)
+
+type (
+	Assign struct {
+		Pos
+		Name string
+		Sym  Symbol
+		X    Expr
+	}
+
+	Input struct {
+		Pos
+		Names []*Ident
+	}
+
+	Output struct {
+		Pos
+		Exprs []Expr
+	}
+
+	While struct {
+		Pos
+		Cond     Expr
+		Body     []Stmt
+		EndWhile *Pos
+	}
+
+	Repeat struct {
+		Pos
+		Body  []Stmt
+		Until Pos
+		Cond  Expr
+	}
+
+	If struct {
+		Pos
+		Cond  Expr
+		Then  []Stmt
+		Elifs []*Elif
+		Else  *Else
+		End   Pos // where the last block ends
+		EndIf *Pos
+	}
+
+	Elif struct {
+		Pos
+		Cond Expr
+		Body []Stmt
+	}
+
+	// else of if, or otherwise of case
+	Else struct {
+		Pos
+		Body []Stmt
+	}
+
+	Case struct {
+		Pos
+		X       Expr
+		Labels  []*CaseLabel
+		Default *Else
+		EndCase *Pos
+	}
+
+	CaseLabel struct {
+		Pos
+		X    Expr
+		Body []Stmt
+	}
+)
+
+type (
+	Const struct {
+		Pos
+		Name    string
+		NamePos Pos
+		X       Expr
+		Sym     Symbol
+	}
+
+	// names have a symbol if the type is a proper one
+	Var struct {
+		Pos
+		Names []*Ident
+		Type  string
+	}
+)
+
+type (
+	Unit struct {
+		Pos
+		Name  string
+		Uses  []*Unit
+		Decls []Decl
+	}
+
+	Program struct {
+		Pos
+		Name    string
+		Uses    []*Unit
+		Units   []*Unit // every unit, in the order they are loaded
+		Decls   []Decl
+		Globals int // variables of the program and its units
+		Code    Pos
+		Body    []Stmt
+		End     Pos
+	}
+)
+
+func (*Assign) stmtNode() {}
+func (*Input) stmtNode()  {}
+func (*Output) stmtNode() {}
+func (*While) stmtNode()  {}
+func (*Repeat) stmtNode() {}
+func (*If) stmtNode()     {}
+func (*Case) stmtNode()   {}
+
+func (*Const) declNode() {}
+func (*Var) declNode()   {}
+
+/* visit n and its children depth first, children are skipped
+   when f returns false
+*/
+func Inspect(n Node, f func(Node) bool) {
+	if n == nil || !f(n) {
+		return
+	}
+	stmts := func(list []Stmt) {
+		for _, s := range list {
+			Inspect(s, f)
+		}
+	}
+	switch n := n.(type) {
+	case *Paren:
+		Inspect(n.X, f)
+	case *Unary:
+		Inspect(n.X, f)
+	case *Binary:
+		Inspect(n.X, f)
+		Inspect(n.Y, f)
+	case *Assign:
+		Inspect(n.X, f)
+	case *Input:
+		for _, id := range n.Names {
+			Inspect(id, f)
+		}
+	case *Output:
+		for _, e := range n.Exprs {
+			Inspect(e, f)
+		}
+	case *While:
+		Inspect(n.Cond, f)
+		stmts(n.Body)
+	case *Repeat:
+		stmts(n.Body)
+		Inspect(n.Cond, f)
+	case *If:
+		Inspect(n.Cond, f)
+		stmts(n.Then)
+		for _, e := range n.Elifs {
+			Inspect(e, f)
+		}
+		if n.Else != nil {
+			Inspect(n.Else, f)
+		}
+	case *Elif:
+		Inspect(n.Cond, f)
+		stmts(n.Body)
+	case *Else:
+		stmts(n.Body)
+	case *Case:
+		Inspect(n.X, f)
+		for _, l := range n.Labels {
+			Inspect(l, f)
+		}
+		if n.Default != nil {
+			Inspect(n.Default, f)
+		}
+	case *CaseLabel:
+		Inspect(n.X, f)
+		stmts(n.Body)
+	case *Const:
+		Inspect(n.X, f)
+	case *Unit:
+		for _, d := range n.Decls {
+			Inspect(d, f)
+		}
+	case *Program:
+		for _, d := range n.Decls {
+			Inspect(d, f)
+		}
+		stmts(n.Body)
+	}
+}
@@ -0,0 +1,61 @@
+package ast
+
+import "strings"
+
+/* an expression as source text, spell gives how a token type
+   is written, so that the keyword profile of the program is kept
+*/
+func ExprString(x Expr, spell func(typ string) string) string {
+	var b strings.Builder
+	writeExpr(&b, x, spell)
+	return b.String()
+}
+
+func writeExpr(b *strings.Builder, x Expr, spell func(string) string) {
+	switch x := x.(type) {
+	case *Ident:
+		b.WriteString(x.Name)
+	case *Literal:
+		b.WriteString(x.Text)
+		if x.Kind == "$CHARRAY" && x.Text != "" { // the closing quote is not kept
+			b.WriteByte(x.Text[0])
+		}
+	case *Paren:
+		b.WriteString("(")
+		writeExpr(b, x.X, spell)
+		b.WriteString(")")
+	case *Unary:
+		b.WriteString(spell(x.Op))
+		if x.Op != "$MINUS" {
+			b.WriteString(" ")
+		}
+		writeExpr(b, x.X, spell)
+	case *Binary:
+		writeExpr(b, x.X, spell)
+		b.WriteString(" " + spell(x.Op) + " ")
+		writeExpr(b, x.Y, spell)
+	}
+}
+
+/* an assignment, input, or output as source text,
+   the other statements span several lines, they give ""
+*/
+func StmtString(s Stmt, spell func(typ string) string) string {
+	switch s := s.(type) {
+	case *Assign:
+		return s.Name + " " + spell("$ASSG") + " " + ExprString(s.X, spell)
+	case *Input:
+		names := []string{}
+		for _, id := range s.Names {
+			names = append(names, id.Name)
+		}
+		return spell("$INPUT") + " " + strings.Join(names, ", ")
+	case *Output:
+		exprs := []string{}
+		for _, x := range s.Exprs {
+			exprs = append(exprs, ExprString(x, spell))
+		}
+		return spell("$OUTPUT") + " " + strings.Join(exprs, ", ")
+	}
+	return ""
+}
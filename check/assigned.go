@@ -0,0 +1,179 @@
+package check
+
+import (
+	"dap/ast"
+	"dap/diag"
+)
+
+/* definite assignment, which variables surely have a value at each use
+
+   The emulator stops at the use of an empty variable, but only on the
+   paths that run, here every path through the algorithm is followed.
+   A loop body may not run, the branches of if and case meet with
+   the variables assigned in all of them.
+*/
+
+// variables assigned on every path so far, by unit:name
+type assigned map[string]bool
+
+func (a assigned) copy() assigned {
+	b := make(assigned, len(a))
+	for k := range a {
+		b[k] = true
+	}
+	return b
+}
+
+// the variables assigned in both a and b
+func (a assigned) meet(b assigned) assigned {
+	c := assigned{}
+	for k := range a {
+		if b[k] {
+			c[k] = true
+		}
+	}
+	return c
+}
+
+type flow struct {
+	diags    *diag.List
+	declared map[string]*ast.Ident // variables, where they are declared
+	targets  map[string]bool       // assigned somewhere
+	reported map[string]bool
+}
+
+func key(sym ast.Symbol) string {
+	return sym.Unit + ":" + sym.Name
+}
+
+/* warn of the variables of prog that may be used before being assigned,
+   and of those used but never assigned
+*/
+func Assigned(prog *ast.Program, diags *diag.List) {
+	f := &flow{diags: diags, declared: map[string]*ast.Ident{}, targets: map[string]bool{}, reported: map[string]bool{}}
+	for _, u := range prog.Units {
+		f.decls(u.Decls)
+	}
+	f.decls(prog.Decls)
+	for _, s := range prog.Body {
+		ast.Inspect(s, f.target)
+	}
+	f.stmts(prog.Body, assigned{})
+}
+
+func (f *flow) decls(list []ast.Decl) {
+	for _, d := range list {
+		if d, ok := d.(*ast.Var); ok {
+			for _, id := range d.Names {
+				if _, again := f.declared[key(id.Sym)]; id.Sym.Typ != "" && !again {
+					f.declared[key(id.Sym)] = id
+				}
+			}
+		}
+	}
+}
+
+func (f *flow) target(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.Assign:
+		f.targets[key(n.Sym)] = true
+	case *ast.Input:
+		for _, id := range n.Names {
+			f.targets[key(id.Sym)] = true
+		}
+	}
+	return true
+}
+
+// the variables assigned after list, starting with in
+func (f *flow) stmts(list []ast.Stmt, in assigned) assigned {
+	for _, s := range list {
+		in = f.stmt(s, in)
+	}
+	return in
+}
+
+func (f *flow) stmt(s ast.Stmt, in assigned) assigned {
+	switch s := s.(type) {
+	case *ast.Assign:
+		f.expr(s.X, in)
+		in[key(s.Sym)] = true
+	case *ast.Input:
+		for _, id := range s.Names {
+			in[key(id.Sym)] = true
+		}
+	case *ast.Output:
+		for _, x := range s.Exprs {
+			f.expr(x, in)
+		}
+	case *ast.While:
+		f.expr(s.Cond, in)
+		f.stmts(s.Body, in.copy())
+	case *ast.Repeat:
+		in = f.stmts(s.Body, in)
+		f.expr(s.Cond, in)
+	case *ast.If:
+		f.expr(s.Cond, in)
+		out := f.stmts(s.Then, in.copy())
+		for _, e := range s.Elifs {
+			f.expr(e.Cond, in)
+			out = out.meet(f.stmts(e.Body, in.copy()))
+		}
+		if s.Else != nil {
+			out = out.meet(f.stmts(s.Else.Body, in.copy()))
+		} else {
+			out = out.meet(in)
+		}
+		in = out
+	case *ast.Case:
+		f.expr(s.X, in)
+		var out assigned
+		for _, l := range s.Labels {
+			b := f.stmts(l.Body, in.copy())
+			if out == nil {
+				out = b
+			} else {
+				out = out.meet(b)
+			}
+		}
+		if s.Default != nil {
+			b := f.stmts(s.Default.Body, in.copy())
+			if out == nil {
+				out = b
+			} else {
+				out = out.meet(b)
+			}
+		} else if out != nil {
+			out = out.meet(in)
+		}
+		if out != nil {
+			in = out
+		}
+	}
+	return in
+}
+
+// each variable is reported once, at its first doubtful use
+func (f *flow) expr(x ast.Expr, in assigned) {
+	ast.Inspect(x, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || id.Sym.Const || id.Sym.Typ == "" {
+			return true
+		}
+		k := key(id.Sym)
+		if in[k] || f.reported[k] {
+			return true
+		}
+		f.reported[k] = true
+		if !f.targets[k] {
+			d := id
+			if decl, ok := f.declared[k]; ok {
+				d = decl
+			}
+			f.diags.Add(at(d.Pos, d.Name).Warnf("P402", "Variable %v is used but never assigned", id.Name))
+		} else {
+			f.diags.Add(at(id.Pos, id.Name).Warnf("P401", "Variable %v may be used before being assigned", id.Name))
+		}
+		return true
+	})
+}
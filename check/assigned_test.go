@@ -0,0 +1,150 @@
+package check_test
+
+import "testing"
+
+func TestAssigned(t *testing.T) {
+	for _, c := range []struct {
+		name, src string
+		units     map[string]string
+		want      string
+	}{
+		{"if without else", `program p
+dictionary
+    var a, b : integer
+algorithm
+    input a
+    if a > 0 then
+        b <- 1
+    endif
+    output b
+endprogram
+`, nil, "prog.dap:9:11 P401"},
+		{"if with else", `program p
+dictionary
+    var a, b : integer
+algorithm
+    input a
+    if a > 0 then
+        b <- 1
+    else
+        b <- 2
+    endif
+    output b
+endprogram
+`, nil, ""},
+		{"an elif that does not assign", `program p
+dictionary
+    var a, b : integer
+algorithm
+    input a
+    if a > 0 then
+        b <- 1
+    elif a < 0 then
+        output a
+    else
+        b <- 2
+    endif
+    output b
+endprogram
+`, nil, "prog.dap:13:11 P401"},
+		{"case without otherwise", `program p
+dictionary
+    var a, b, c : integer
+algorithm
+    input a
+    case a of
+    1 :
+        b <- 1
+        c <- 1
+    otherwise
+        b <- 2
+    endcase
+    case a of
+    1 :
+        c <- 1
+    endcase
+    output b, c
+endprogram
+`, nil, "prog.dap:17:14 P401"},
+		{"while body may not run", `program p
+dictionary
+    var a, b : integer
+algorithm
+    input a
+    while a > 0 do
+        b <- a
+        a <- a - 1
+    endwhile
+    output b
+endprogram
+`, nil, "prog.dap:10:11 P401"},
+		{"repeat body runs", `program p
+dictionary
+    var a, b : integer
+algorithm
+    input a
+    repeat
+        b <- a
+        a <- a - 1
+    until a < 0
+    output b
+endprogram
+`, nil, ""},
+		{"read before the loop assigns it", `program p
+dictionary
+    var a, b : integer
+algorithm
+    input a
+    while a > 0 do
+        if a < 5 then
+            output b
+        endif
+        b <- a
+        a <- a - 1
+    endwhile
+endprogram
+`, nil, "prog.dap:8:19 P401"},
+		{"reported once, at the first use", `program p
+dictionary
+    var a, b : integer
+algorithm
+    input a
+    if a > 0 then
+        b <- 1
+    endif
+    output b
+    a <- b + 1
+    output a
+endprogram
+`, nil, "prog.dap:9:11 P401"},
+		{"never assigned, at the declaration", `program p
+dictionary
+    var a, b : integer
+algorithm
+    input a
+    a <- b + a
+    output a, b
+endprogram
+`, nil, "prog.dap:3:11 P402"},
+		{"a unit's variable", `program p
+uses tally
+dictionary
+    var a : integer
+algorithm
+    input a
+    output count, a
+endprogram
+`, map[string]string{"tally": tally}, "tally.dap:4:8 P402"},
+		{"a unit's variable, input by the program", `program p
+uses tally
+algorithm
+    input count
+    output count + start
+endprogram
+`, map[string]string{"tally": tally}, ""},
+	} {
+		if got := diagnose(t, c.src, c.units, "P401", "P402"); got != c.want {
+			t.Errorf("%v: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package check
+
+import (
+	"unicode/utf8"
+	"dap/ast"
+	"dap/diag"
+)
+
+/* semantic checks of a parsed program, the names first
+
+   The parser has looked the names up already, as it folds the constants,
+   an undeclared name is left with a symbol without a type.
+*/
+
+type resolver struct {
+	diags     *diag.List
+	isKeyword func(string) bool
+	declared  map[string]ast.Pos // unit:name, where it is declared first
+}
+
+// report the names of prog that are misused
+func Resolve(prog *ast.Program, isKeyword func(string) bool, diags *diag.List) {
+	r := &resolver{diags: diags, isKeyword: isKeyword, declared: map[string]ast.Pos{}}
+	for _, u := range prog.Units {
+		r.decls(u.Name, u.Decls)
+	}
+	r.decls("", prog.Decls)
+	for _, s := range prog.Body {
+		ast.Inspect(s, r.stmt)
+	}
+}
+
+func at(pos ast.Pos, name string) diag.Diagnostic {
+	return diag.At(pos.File, pos.Line, pos.Col, utf8.RuneCountInString(name))
+}
+
+func (r *resolver) decls(unit string, list []ast.Decl) {
+	for _, d := range list {
+		switch d := d.(type) {
+		case *ast.Const:
+			r.declare(unit, d.Name, d.NamePos)
+		case *ast.Var:
+			for _, id := range d.Names {
+				r.declare(unit, id.Name, id.Pos)
+			}
+		}
+	}
+}
+
+func (r *resolver) declare(unit, name string, pos ast.Pos) {
+	key := unit + ":" + name
+	if r.isKeyword(name) {
+		r.diags.Add(at(pos, name).Errorf("P218", "Keyword %v used as a name", name))
+	} else if first, ok := r.declared[key]; ok {
+		r.diags.Add(at(pos, name).Errorf("P216", "%v is already declared (see line %v)", name, first.Line))
+	} else {
+		r.declared[key] = pos
+	}
+}
+
+// names are looked up by the parser, the targets of a value are checked here
+func (r *resolver) stmt(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.Assign:
+		r.target(n.Name, n.Sym, n.Pos)
+	case *ast.Input:
+		for _, id := range n.Names {
+			r.target(id.Name, id.Sym, id.Pos)
+		}
+		return false
+	}
+	return true
+}
+
+func (r *resolver) target(name string, sym ast.Symbol, pos ast.Pos) {
+	if r.isKeyword(name) {
+		r.diags.Add(at(pos, name).Errorf("P218", "Keyword %v used as a name", name))
+	} else if sym.Typ == "" && !sym.Const {
+		r.diags.Add(at(pos, name).Errorf("P201", "Variable %v is not defined", name))
+	} else if sym.Const {
+		r.diags.Add(at(pos, name).Errorf("P217", "Constant %v cannot be changed", name))
+	}
+}
@@ -0,0 +1,114 @@
+package check_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/parser"
+)
+
+/* the diagnostics of src compiled as prog.dap, beside the units given
+   by their names, those of the codes given, each as file:line:col code
+*/
+func diagnose(t *testing.T, src string, units map[string]string, codes ...string) string {
+	dir := t.TempDir()
+	for name, unit := range units {
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".dap"), []byte(unit), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compiler := parser.Compiler{Log: ioutil.Discard}
+	prog, _ := compiler.Compile(filepath.Join(dir, "prog.dap"), strings.NewReader(src))
+	got := []string{}
+	for _, d := range prog.Diagnostics.Items() {
+		for _, c := range codes {
+			if d.Code == c {
+				got = append(got, fmt.Sprintf("%v:%v:%v %v", filepath.Base(d.File), d.Line, d.Col, d.Code))
+			}
+		}
+	}
+	return strings.Join(got, " ")
+}
+
+const tally = `unit tally
+dictionary
+    const start = 10
+    var count : integer
+endunit
+`
+
+func TestResolve(t *testing.T) {
+	for _, c := range []struct {
+		name, src string
+		units     map[string]string
+		want      string
+	}{
+		{"declared again", `program p
+dictionary
+    var a, b, a : integer
+    const k = 1
+    k : boolean
+algorithm
+    input a, b
+    output a, b, k
+endprogram
+`, nil, "prog.dap:3:14 P216 prog.dap:5:4 P216"},
+		{"constant changed", `program p
+dictionary
+    const k = 1
+    var a : integer
+algorithm
+    k <- 2
+    input a, k
+    output a
+endprogram
+`, nil, "prog.dap:6:4 P217 prog.dap:7:13 P217"},
+		{"not declared", `program p
+dictionary
+    var a : integer
+algorithm
+    b <- 1
+    input a, c
+    output a
+endprogram
+`, nil, "prog.dap:5:4 P201 prog.dap:6:13 P201"},
+		{"keyword as a name", `program p
+dictionary
+    var if, a : integer
+algorithm
+    input a
+    output a
+endprogram
+`, nil, "prog.dap:3:8 P218"},
+		{"a unit's constant", `program p
+uses tally
+dictionary
+    var a : integer
+algorithm
+    start <- 2
+    count <- start
+    input a
+    output a, count
+endprogram
+`, map[string]string{"tally": tally}, "prog.dap:6:4 P217"},
+		{"declared again in a unit, not in the program", `program p
+uses again
+dictionary
+    var n : integer
+algorithm
+    n <- 1
+    output n, x
+endprogram
+`, map[string]string{"again": `unit again
+dictionary
+    var x, x : integer
+endunit
+`}, "again.dap:3:11 P216"},
+	} {
+		if got := diagnose(t, c.src, c.units, "P201", "P216", "P217", "P218"); got != c.want {
+			t.Errorf("%v: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package check
+
+import (
+	"dap/ast"
+	"dap/diag"
+)
+
+/* lint of the declarations, each warning has its own code,
+   so that a course may turn it off
+
+   P501 variable never used
+   P502 constant never used
+   P503 variable assigned but never read
+   P504 name hiding the same name of a used unit
+
+   A unit serves many programs, only the names of the program
+   are reported unused.
+*/
+
+type usage struct {
+	diags   *diag.List
+	read    map[string]bool // unit:name
+	written map[string]bool
+}
+
+func Usage(prog *ast.Program, diags *diag.List) {
+	u := &usage{diags: diags, read: map[string]bool{}, written: map[string]bool{}}
+	for _, unit := range prog.Units {
+		for _, d := range unit.Decls {
+			ast.Inspect(d, u.use)
+		}
+		u.shadows(unit.Decls, unit.Uses)
+	}
+	for _, d := range prog.Decls {
+		ast.Inspect(d, u.use)
+	}
+	u.shadows(prog.Decls, prog.Uses)
+	for _, s := range prog.Body {
+		ast.Inspect(s, u.use)
+	}
+	u.unused(prog.Decls)
+}
+
+func (u *usage) use(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.Ident:
+		u.read[key(n.Sym)] = true
+	case *ast.Assign:
+		u.written[key(n.Sym)] = true
+	case *ast.Input:
+		for _, id := range n.Names {
+			u.written[key(id.Sym)] = true
+		}
+		return false
+	case *ast.Var:
+		return false // the names are declared, not read
+	}
+	return true
+}
+
+// a name declared again is reported by the resolver, here only its first declaration
+func (u *usage) unused(decls []ast.Decl) {
+	seen := map[string]bool{}
+	first := func(sym ast.Symbol) bool {
+		again := seen[key(sym)]
+		seen[key(sym)] = true
+		return !again
+	}
+	for _, d := range decls {
+		switch d := d.(type) {
+		case *ast.Const:
+			if first(d.Sym) && !u.read[key(d.Sym)] {
+				u.diags.Add(at(d.NamePos, d.Name).Warnf("P502", "Constant %v is never used", d.Name))
+			}
+		case *ast.Var:
+			for _, id := range d.Names {
+				k := key(id.Sym)
+				if !first(id.Sym) || id.Sym.Typ == "" || u.read[k] {
+				} else if u.written[k] {
+					u.diags.Add(at(id.Pos, id.Name).Warnf("P503", "Variable %v is assigned but never read", id.Name))
+				} else {
+					u.diags.Add(at(id.Pos, id.Name).Warnf("P501", "Variable %v is never used", id.Name))
+				}
+			}
+		}
+	}
+}
+
+// the names of decls hide those of the units they use
+func (u *usage) shadows(decls []ast.Decl, uses []*ast.Unit) {
+	hidden := func(name string, pos ast.Pos) {
+		for _, used := range uses {
+			for _, d := range used.Decls {
+				if declares(d, name) {
+					u.diags.Add(at(pos, name).Warnf("P504", "%v hides %v of unit %v", name, name, used.Name))
+				}
+			}
+		}
+	}
+	for _, d := range decls {
+		switch d := d.(type) {
+		case *ast.Const:
+			hidden(d.Name, d.NamePos)
+		case *ast.Var:
+			for _, id := range d.Names {
+				hidden(id.Name, id.Pos)
+			}
+		}
+	}
+}
+
+func declares(d ast.Decl, name string) bool {
+	switch d := d.(type) {
+	case *ast.Const:
+		return d.Name == name
+	case *ast.Var:
+		for _, id := range d.Names {
+			if id.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package check_test
+
+import "testing"
+
+func TestUsage(t *testing.T) {
+	for _, c := range []struct {
+		name, src string
+		units     map[string]string
+		want      string
+	}{
+		{"never used, assigned, input", `program p
+dictionary
+    const k = 1
+    const m = 2
+    var a, b, c, d : integer
+algorithm
+    b <- m
+    input c
+    input d
+    output d
+endprogram
+`, nil, "prog.dap:3:10 P502 prog.dap:5:8 P501 prog.dap:5:11 P503 prog.dap:5:14 P503"},
+		{"read in a condition only", `program p
+dictionary
+    var a : integer
+    var ok : boolean
+algorithm
+    input a
+    ok <- a > 0
+    while ok do
+        a <- a - 1
+        ok <- a > 0
+    endwhile
+endprogram
+`, nil, ""},
+		{"declared again, the first declaration only", `program p
+dictionary
+    var a, b, a : integer
+algorithm
+    b <- 1
+    output b
+endprogram
+`, nil, "prog.dap:3:8 P501"},
+		{"the names of a unit are not reported", `program p
+uses tally
+dictionary
+    var a : integer
+algorithm
+    input a
+    output a
+endprogram
+`, map[string]string{"tally": tally}, ""},
+		{"hiding a name of a unit", `program p
+uses tally
+dictionary
+    var count : integer
+algorithm
+    count <- start
+    output count
+endprogram
+`, map[string]string{"tally": tally}, "prog.dap:4:8 P504"},
+		{"hidden inside a unit, its own name is used", `program p
+uses inner
+dictionary
+    var a : integer
+algorithm
+    a <- start
+    output a
+endprogram
+`, map[string]string{"tally": tally, "inner": `unit inner
+uses tally
+dictionary
+    const start = 20
+endunit
+`}, "inner.dap:4:10 P504"},
+	} {
+		if got := diagnose(t, c.src, c.units, "P501", "P502", "P503", "P504"); got != c.want {
+			t.Errorf("%v: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package codegen
+
+import (
+	"dap/ast"
+	em "dap/emulator"
+)
+
+/* s4041 codes of a parsed program, the tree carries the constants
+   folded by the parser, only what is left is computed at run time
+*/
+
+type generator struct {
+	m    *em.Machine
+	done map[*ast.Unit]bool
+}
+
+// the codes of prog go to machine
+func Generate(machine *em.Machine, prog *ast.Program) {
+	g := &generator{m: machine, done: map[*ast.Unit]bool{}}
+	g.m.GenMainFile(prog.Pos.File)
+	for _, u := range prog.Units { // number the source files by first use
+		g.m.GenFile(u.Pos.File)
+	}
+	g.m.GenFile(prog.Pos.File)
+	g.m.GenLine(prog.Pos.Line, prog.Pos.Col) // trace starts from program line
+	for _, u := range prog.Uses {
+		g.unit(u)
+	}
+	g.m.GenFile(prog.Pos.File)
+	g.decls(prog.Decls)
+	if prog.Globals > 0 { // globals of the program and its units
+		g.m.GenClaim(prog.Globals)
+	}
+	g.m.GenLine(prog.Code.Line, prog.Code.Col)
+	g.block(prog.Body)
+	g.m.GenLine(prog.End.Line, prog.End.Col)
+	g.m.GenExit()
+}
+
+/* units used by u go first, as they are loaded
+ */
+func (g *generator) unit(u *ast.Unit) {
+	if g.done[u] {
+		return
+	}
+	g.done[u] = true
+	for _, used := range u.Uses {
+		g.unit(used)
+	}
+	g.m.GenFile(u.Pos.File)
+	g.decls(u.Decls)
+}
+
+// a constant may be given by an expression of variables
+func (g *generator) decls(list []ast.Decl) {
+	for _, d := range list {
+		if c, ok := d.(*ast.Const); ok {
+			g.expr(c.X)
+		}
+	}
+}
+
+func (g *generator) block(list []ast.Stmt) {
+	for _, s := range list {
+		g.stmt(s)
+	}
+}
+
+func (g *generator) line(p ast.Pos) {
+	g.m.GenLine(p.Line, p.Col)
+}
+
+func (g *generator) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.Assign:
+		g.line(s.Pos)
+		g.expr(s.X)
+		g.m.GenStore(s.X.Type(), s.Sym.Parent, s.Sym.Loc, s.X.Value())
+		g.m.CollectAssg(s.Sym.Parent, s.Line, s.Sym.Loc)
+
+	case *ast.Input:
+		g.line(s.Pos)
+		for _, id := range s.Names {
+			g.m.GenInp(id.Sym.Typ, id.Sym.Parent, id.Sym.Loc)
+		}
+		for _, id := range s.Names {
+			g.m.CollectAssg(id.Scope, s.Line, id.Sym.Loc)
+		}
+
+	case *ast.Output:
+		g.line(s.Pos)
+		for _, x := range s.Exprs {
+			g.expr(x)
+			g.m.GenOut(x.Type(), x.Value())
+		}
+
+	case *ast.While:
+		l1 := g.m.GenLabel()
+		l2 := g.m.GenLabel()
+		g.m.GenLoc(l1)
+		g.line(s.Pos)
+		g.expr(s.Cond)
+		g.m.GenCond(l2, s.Cond.Value())
+		g.block(s.Body)
+		if s.EndWhile != nil {
+			g.line(*s.EndWhile)
+		}
+		g.m.GenGoto(l1)
+		g.m.GenLoc(l2)
+
+	case *ast.Repeat:
+		l1 := g.m.GenLabel()
+		g.m.GenLoc(l1)
+		g.line(s.Pos)
+		g.block(s.Body)
+		g.line(s.Until)
+		g.expr(s.Cond)
+		g.m.GenCond(l1, s.Cond.Value())
+
+	case *ast.If:
+		g.line(s.Pos)
+		g.expr(s.Cond)
+		lels := g.m.GenLabel()
+		lfin := g.m.GenLabel()
+		g.m.GenCond(lels, s.Cond.Value())
+		g.block(s.Then)
+		for _, elif := range s.Elifs {
+			g.m.GenGoto(lfin)
+			g.m.GenLoc(lels)
+			lels = g.m.GenLabel()
+			g.line(elif.Pos)
+			g.expr(elif.Cond)
+			g.m.GenCond(lels, elif.Cond.Value())
+			g.block(elif.Body)
+		}
+		if s.Else != nil {
+			g.m.GenGoto(lfin)
+			g.m.GenLoc(lels)
+			g.line(s.Else.Pos)
+			g.block(s.Else.Body)
+		} else {
+			g.m.GenLoc(lels)
+		}
+		g.m.GenLoc(lfin)
+		g.line(s.End)
+		if s.EndIf != nil {
+			g.line(*s.EndIf)
+		}
+
+	case *ast.Case:
+		g.line(s.Pos)
+		g.expr(s.X)
+		if s.X.Value() != em.EMPTY {
+			g.m.GenConst(s.X.Type(), s.X.Value())
+		}
+		lfin := g.m.GenLabel()
+		for _, label := range s.Labels {
+			g.line(label.Pos)
+			g.m.GenDup() // make a copy of case expression, vs. label expression
+			g.expr(label.X)
+			lnex := g.m.GenLabel()
+			g.m.GenCase(lnex, label.X.Type(), label.X.Value())
+			g.block(label.Body)
+			g.m.GenGoto(lfin)
+			g.m.GenLoc(lnex)
+		}
+		if s.Default != nil {
+			g.line(s.Default.Pos)
+			g.block(s.Default.Body)
+		}
+		g.m.GenLoc(lfin)
+		g.m.GenPop()
+		if s.EndCase != nil {
+			g.line(*s.EndCase)
+		}
+	}
+}
+
+/* the codes leaving the value of x on the stack, a value folded
+   by the parser is left to the statement, it pushes it
+*/
+func (g *generator) expr(x ast.Expr) {
+	switch x := x.(type) {
+	case *ast.Ident:
+		if x.Sym.Typ != "" && x.Sym.Val == em.EMPTY {
+			g.m.GenCopy(x.Scope, x.Sym.Loc)
+		}
+
+	case *ast.Paren:
+		g.expr(x.X)
+
+	case *ast.Unary:
+		if x.Value() != em.EMPTY {
+			break
+		}
+		g.operand(x.X)
+		if x.Op == "$MINUS" {
+			g.m.GenOpCmd("$NEG")
+		} else {
+			g.m.GenOpCmd(x.Op)
+		}
+
+	case *ast.Binary:
+		if x.Value() != em.EMPTY {
+			break
+		}
+		g.operand(x.X)
+		g.operand(x.Y)
+		g.m.GenOpCmd(x.Op)
+	}
+}
+
+// an operand is on the stack, in the order of the source, even a folded one
+func (g *generator) operand(x ast.Expr) {
+	if v := x.Value(); v != em.EMPTY {
+		g.m.GenConst(x.Type(), v)
+	} else {
+		g.expr(x)
+	}
+}
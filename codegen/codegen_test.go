@@ -0,0 +1,63 @@
+package codegen_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"dap/parser"
+)
+
+var update = flag.Bool("update", false, "write the .s4041 files of testdata/codegen")
+
+// the codes of each program of testdata/codegen, as its .s4041 has them
+func TestCodes(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", "codegen", "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/codegen: %v", err)
+	}
+	for _, f := range files {
+		compiler := parser.Compiler{SearchPath: []string{filepath.Join("..", "testdata", "codegen", "lib")}, Log: ioutil.Discard}
+		prog, err := compiler.CompileFile(f)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		got := strings.Join(prog.Machine.GenInit(), "\n") + "\n"
+		golden := strings.TrimSuffix(f, ".dap") + ".s4041"
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		if got != string(want) {
+			t.Errorf("%v: the codes differ from %v\n%v", f, golden, diffLines(string(want), got))
+		}
+	}
+}
+
+// the first line where want and got differ
+func diffLines(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(w) || i < len(g); i++ {
+		wl, gl := "", ""
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if wl != gl {
+			return "line " + strconv.Itoa(i+1) + ": want " + strconv.Quote(wl) + ", got " + strconv.Quote(gl)
+		}
+	}
+	return ""
+}
@@ -3,14 +3,23 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"dap/diag"
 	"dap/emulator"
+	"dap/flowchart"
+	"dap/metrics"
 	"dap/parser"
-	"dap/scanner"
+	"dap/structogram"
+	"dap/transpile"
+	"dap/typeset"
 	"dap/ui"
+	"dap/xref"
 )
 
 var (
@@ -19,14 +28,37 @@ var (
 	dapRun      bool
 	dapCompile  bool
 	dapAssembly bool
+	dapPackage  bool
+	dapEmbed    bool
 	listenPort  string
 	dapSrcFile  string
 	dapDestFile string
 	dapAssets   string
+	dapUnitPath string
+	dapKeywords string
+	dapStrict   bool
+	dapWord     int
+	dapOverflow string
 	dapSource   bool = false
 	dapSymbolic bool = false
 	dapInternal bool = false
+	dapPacked   bool = false
+	dapSymFile  string
+	dapSymbols  bool // loaded beside compiled or assembled codes
 	dapSteps    int
+	dapDiags    string
+	dapNoWarn   string
+	dapChart    string
+	dapNS       string
+	dapTarget   string
+	dapTypeset  string
+	dapTeX      string
+	dapListing  string
+	dapXref     string
+	dapMetrics  string
+	dapLimits   metrics.Limits
+	machine     *emulator.Machine
+	diagnostics *diag.List
 )
 
 func dapHelp() {
@@ -36,7 +68,28 @@ It is used to study the trace and the effect of a program execution
 Use the browser to invoke the user interface.
 To store compiled codes, use file with extension .s4041
 To store assembled codes, use file with extension .i4041
-%s [-animate [-l <:port>]|-console|-run] [[-compile|-assembly] -o <destfile.ext]] <source program.dap>
+To store codes with their symbols, line table, and sources, use file with extension .b4041
+The symbol tables of a source are saved beside it, and beside its codes, as .dapsym,
+codes are loaded with them, from -symbols or from the .dapsym of the same name, to be animated
+Units named by "uses" are searched in the source folder, then along -I
+Keywords follow a profile (english, indonesian, or a .json profile file),
+also selected by a {$keywords <profile> [strict]} comment in the source
+Errors and warnings have stable codes, -diagnostics json lists them on the standard output,
+or in a .json file, with those of the run, e.g. -run -diagnostics run.json,
+-nowarn leaves out the warnings of some codes, e.g. -nowarn P501,P503
+-flowchart draws the algorithm as a Graphviz .dot file, or as a .svg picture
+-structogram draws it as a Nassi-Shneiderman diagram, a .svg picture or a .html page
+-transpile writes it as the source of another language, Go, Pascal, or C, for a .go, .pas, or .c file
+-typeset writes it as a LaTeX document, in the package -tex names, or as a highlighted .html page
+-listing writes each source line with the codes generated for it, at their addresses, to a .lst file
+-xref lists where each variable and constant is declared, assigned, and read, as a table or json on the standard output
+-metrics measures the algorithm, complexity, nesting, statements, and Halstead, as a table or json on the standard output
+-maxcomplexity, -maxnesting, and -maxstatements warn of a program above them, M001, M002, and M003
+"%[1]s fmt" formats sources, see %[1]s fmt -h
+"%[1]s translate" rewrites their keywords in another profile, see %[1]s translate -h
+"%[1]s lsp" serves editors the Language Server Protocol, see %[1]s lsp -h
+"%[1]s debug-adapter" serves editors the Debug Adapter Protocol, see %[1]s debug-adapter -h
+%[1]s [-animate [-l <:port>]|-console|-run] [-I <dir:dir>] [-keywords <profile> [-strict]] [-word <bits> [-overflow wrap|trap]] [-diagnostics text|json|<diags.json>] [-nowarn <code,code>] [-flowchart <chart.dot|chart.svg>] [-structogram <ns.svg|ns.html>] [-transpile <prog.go|prog.pas|prog.c>] [-typeset <prog.tex|prog.html> [-tex algorithm2e|algpseudocode]] [-listing <prog.lst>] [-xref text|json] [-metrics text|json] [-maxcomplexity <n>] [-maxnesting <n>] [-maxstatements <n>] [[-compile|-assembly] -o <destfile.ext> [-embed]] [-symbols <program.dapsym>] <source program.dap>
 `, os.Args[0])
 	flag.PrintDefaults()
 }
@@ -50,10 +103,30 @@ func validArgs() (ok bool) {
 	flag.BoolVar(&dapAnimate, "emulate", false, "Animate (instead of run) the codes")
 	flag.BoolVar(&dapConsole, "console", false, "Run the codes using animate protocol")
 	flag.StringVar(&listenPort, "l", ":2345", "Animate at this HTTP port")
-	flag.StringVar(&dapDestFile, "o", "", "Output of compiled (.s4041)/assembled (.i4041) codes")
+	flag.StringVar(&dapDestFile, "o", "", "Output of compiled (.s4041)/assembled (.i4041)/contained (.b4041) codes")
+	flag.BoolVar(&dapEmbed, "embed", false, "Embed the sources in the .b4041 container")
+	flag.StringVar(&dapSymFile, "symbols", "", "Symbol tables of the .s4041/.i4041 codes, a .dapsym file")
 	flag.BoolVar(&dapRun, "run", false, "Run (instead of animate) the codes")
 	flag.IntVar(&dapSteps, "steps", 1000000, "Number of internal code execution")
 	flag.StringVar(&dapAssets, "asset", "ui", "Folder where asset folder is located")
+	flag.StringVar(&dapUnitPath, "I", "", "Folders where units are located, separated by '"+string(filepath.ListSeparator)+"'")
+	flag.StringVar(&dapKeywords, "keywords", "", "Keyword profile, english, indonesian, or a .json profile file")
+	flag.BoolVar(&dapStrict, "strict", false, "Reject keywords of other profiles")
+	flag.IntVar(&dapWord, "word", 64, "Machine word size in bits, 8, 16, 32, or 64")
+	flag.StringVar(&dapOverflow, "overflow", "wrap", "On integer overflow, either wrap or trap")
+	flag.StringVar(&dapDiags, "diagnostics", "text", "Diagnostics as logged text, or also as json alone on the standard output, or in a .json file")
+	flag.StringVar(&dapNoWarn, "nowarn", "", "Codes of the warnings left out, separated by ','")
+	flag.StringVar(&dapChart, "flowchart", "", "Flowchart of the algorithm, as .dot or .svg")
+	flag.StringVar(&dapNS, "structogram", "", "Nassi-Shneiderman diagram of the algorithm, as .svg or .html")
+	flag.StringVar(&dapTarget, "transpile", "", "Program in another language, as .go, .pas, or .c")
+	flag.StringVar(&dapTypeset, "typeset", "", "Program typeset, as .tex or .html")
+	flag.StringVar(&dapTeX, "tex", "algorithm2e", "LaTeX package of the algorithm, algorithm2e or algpseudocode")
+	flag.StringVar(&dapListing, "listing", "", "Source and codes side by side, as .lst")
+	flag.StringVar(&dapXref, "xref", "", "Cross reference of the names, as text or json")
+	flag.StringVar(&dapMetrics, "metrics", "", "Measures of the algorithm, as text or json")
+	flag.IntVar(&dapLimits.Complexity, "maxcomplexity", 0, "Warn of a cyclomatic complexity above it")
+	flag.IntVar(&dapLimits.Nesting, "maxnesting", 0, "Warn of statements nested deeper")
+	flag.IntVar(&dapLimits.Statements, "maxstatements", 0, "Warn of more statements")
 	flag.Parse()
 
 	dapSrcFile = flag.Arg(0)
@@ -62,18 +135,20 @@ func validArgs() (ok bool) {
 		dapSource = true
 	} else if lSrc < 7 {
 		dapHelp()
-		fmt.Fprintln(flag.CommandLine.Output(), "Non .dap source file mast have either .s4041 or .i4041 extension")
+		fmt.Fprintln(flag.CommandLine.Output(), "Non .dap source file mast have either .s4041, .i4041, or .b4041 extension")
 		ok = false
 	} else if dapSrcFile[lSrc-6:] == ".s4041" {
 		dapSymbolic = true
 	} else if dapSrcFile[lSrc-6:] == ".i4041" {
 		dapInternal = true
+	} else if dapSrcFile[lSrc-6:] == ".b4041" {
+		dapPacked = true
 	} else {
 		dapHelp()
-		fmt.Fprintln(flag.CommandLine.Output(), "Source file must have either .dap, .s4041, or .i4041 extension")
+		fmt.Fprintln(flag.CommandLine.Output(), "Source file must have either .dap, .s4041, .i4041, or .b4041 extension")
 		ok = false
 	}
-	if !(dapSource || dapSymbolic || dapInternal) {
+	if !(dapSource || dapSymbolic || dapInternal || dapPacked) {
 		dapHelp()
 		fmt.Fprintln(flag.CommandLine.Output(), "Source file must be given")
 		ok = false
@@ -83,9 +158,108 @@ func validArgs() (ok bool) {
 	if lDest >= 7 {
 		dapCompile = dapDestFile[lDest-6:] == ".s4041"
 		dapAssembly = dapDestFile[lDest-6:] == ".i4041"
+		dapPackage = dapDestFile[lDest-6:] == ".b4041"
 	}
-	if lDest > 0 && !dapCompile && !dapAssembly {
-		fmt.Fprintln(flag.CommandLine.Output(), "File name extension for symbolic codes is '.s4041 and for internal code is .i4041")
+	if lDest > 0 && !dapCompile && !dapAssembly && !dapPackage {
+		fmt.Fprintln(flag.CommandLine.Output(), "File name extension for symbolic codes is '.s4041, for internal code is .i4041, and for a container is .b4041")
+		ok = false
+	}
+	if dapSymFile != "" && !dapSymbolic && !dapInternal {
+		fmt.Fprintln(flag.CommandLine.Output(), "Symbol tables are loaded with .s4041 or .i4041 codes")
+		ok = false
+	} else if dapSymFile != "" && filepath.Ext(dapSymFile) != ".dapsym" {
+		fmt.Fprintln(flag.CommandLine.Output(), "Symbol tables have .dapsym extension")
+		ok = false
+	}
+	if dapEmbed && !dapPackage {
+		fmt.Fprintln(flag.CommandLine.Output(), "Sources are embedded in a .b4041 container")
+		ok = false
+	}
+
+	if dapStrict && dapKeywords == "" {
+		fmt.Fprintln(flag.CommandLine.Output(), "Use -strict along with -keywords")
+		ok = false
+	}
+
+	if dapWord != 8 && dapWord != 16 && dapWord != 32 && dapWord != 64 {
+		fmt.Fprintln(flag.CommandLine.Output(), "Word size is either 8, 16, 32, or 64 bits")
+		ok = false
+	}
+	if dapOverflow != "wrap" && dapOverflow != "trap" {
+		fmt.Fprintln(flag.CommandLine.Output(), "On overflow, either wrap or trap")
+		ok = false
+	}
+
+	if dapDiags != "text" && dapDiags != "json" && filepath.Ext(dapDiags) != ".json" {
+		fmt.Fprintln(flag.CommandLine.Output(), "Diagnostics are either text or json, or a .json file")
+		ok = false
+	}
+
+	if dapChart != "" && !dapSource {
+		fmt.Fprintln(flag.CommandLine.Output(), "A flowchart is drawn from a .dap source")
+		ok = false
+	} else if ext := filepath.Ext(dapChart); dapChart != "" && ext != ".dot" && ext != ".svg" {
+		fmt.Fprintln(flag.CommandLine.Output(), "A flowchart file has either .dot or .svg extension")
+		ok = false
+	}
+	if dapNS != "" && !dapSource {
+		fmt.Fprintln(flag.CommandLine.Output(), "A structogram is drawn from a .dap source")
+		ok = false
+	} else if ext := filepath.Ext(dapNS); dapNS != "" && ext != ".svg" && ext != ".html" {
+		fmt.Fprintln(flag.CommandLine.Output(), "A structogram file has either .svg or .html extension")
+		ok = false
+	}
+	if dapTarget != "" && !dapSource {
+		fmt.Fprintln(flag.CommandLine.Output(), "A program is transpiled from a .dap source")
+		ok = false
+	} else if ext := filepath.Ext(dapTarget); dapTarget != "" && ext != ".go" && ext != ".pas" && ext != ".c" {
+		fmt.Fprintln(flag.CommandLine.Output(), "A transpiled program has either .go, .pas, or .c extension")
+		ok = false
+	}
+
+	if dapTypeset != "" && !dapSource {
+		fmt.Fprintln(flag.CommandLine.Output(), "A program is typeset from a .dap source")
+		ok = false
+	} else if ext := filepath.Ext(dapTypeset); dapTypeset != "" && ext != ".tex" && ext != ".html" {
+		fmt.Fprintln(flag.CommandLine.Output(), "A typeset program has either .tex or .html extension")
+		ok = false
+	}
+	if dapTeX != typeset.Styles[0] && dapTeX != typeset.Styles[1] {
+		fmt.Fprintln(flag.CommandLine.Output(), "LaTeX is typeset with either algorithm2e or algpseudocode")
+		ok = false
+	}
+
+	if dapListing != "" && (dapInternal || dapPacked) {
+		fmt.Fprintln(flag.CommandLine.Output(), "A listing is made from a .dap source or .s4041 codes")
+		ok = false
+	} else if dapListing != "" && filepath.Ext(dapListing) != ".lst" {
+		fmt.Fprintln(flag.CommandLine.Output(), "A listing has .lst extension")
+		ok = false
+	}
+
+	if dapXref != "" && !dapSource {
+		fmt.Fprintln(flag.CommandLine.Output(), "A cross reference is made from a .dap source")
+		ok = false
+	} else if dapXref != "" && dapXref != "text" && dapXref != "json" {
+		fmt.Fprintln(flag.CommandLine.Output(), "A cross reference is either text or json")
+		ok = false
+	}
+	if dapMetrics != "" && !dapSource {
+		fmt.Fprintln(flag.CommandLine.Output(), "Metrics are taken from a .dap source")
+		ok = false
+	} else if dapMetrics != "" && dapMetrics != "text" && dapMetrics != "json" {
+		fmt.Fprintln(flag.CommandLine.Output(), "Metrics are either text or json")
+		ok = false
+	}
+	if dapLimits != (metrics.Limits{}) && !dapSource {
+		fmt.Fprintln(flag.CommandLine.Output(), "The limits of -maxcomplexity, -maxnesting, and -maxstatements are checked on a .dap source")
+		ok = false
+	}
+	if dapDiags == "json" && (dapRun || dapConsole || dapXref != "" || dapMetrics != "") {
+		fmt.Fprintln(flag.CommandLine.Output(), "Diagnostics as json are alone on the standard output, use a .json file with -run, -console, -xref, and -metrics")
+		ok = false
+	} else if dapXref == "json" && dapMetrics == "json" {
+		fmt.Fprintln(flag.CommandLine.Output(), "Use one of -xref json and -metrics json, they are on the standard output")
 		ok = false
 	}
 
@@ -99,57 +273,296 @@ func validArgs() (ok bool) {
 func performAnimation() {
 	chlog := make(chan []byte, 8)
 	chcmd := make(chan []byte, 8)
-	chint := make(chan os.Signal)
+	chint := make(chan os.Signal, 1)
 	go ui.ServeWeb(listenPort, dapAssets, chlog, chcmd)
-	emulator.Wemulate(dapSrcFile, dapSteps, chint, chlog, chcmd)
+	machine.Wemulate(programSource(), dapSteps, chint, chlog, chcmd)
 	signal.Notify(chint, syscall.SIGINT, syscall.SIGTERM)
 	<-chint
 	log.Print("DAP.m * Animation ends")
 }
 
+/* the source the codes come from, as a container or the symbol tables
+   name it, the file of the codes when nothing names it
+*/
+func programSource() string {
+	if main := machine.SourceFiles()[0]; !dapSource && main != "" {
+		return main
+	}
+	return dapSrcFile
+}
+
+// the .dapsym of the codes in fname, prog.dapsym of prog.s4041
+func besideCodes(fname string) string {
+	return strings.TrimSuffix(fname, filepath.Ext(fname)) + ".dapsym"
+}
+
+/* the symbol tables of the codes, from -symbols, or else from the
+   .dapsym beside them, if there is one
+*/
+func loadSymbolTables() {
+	fname := dapSymFile
+	if fname == "" {
+		fname = besideCodes(dapSrcFile)
+		if _, err := os.Stat(fname); err != nil {
+			return
+		}
+	}
+	if err := machine.LoadVariables(fname); err != nil {
+		if dapSymFile != "" {
+			log.Fatal(err)
+		}
+		log.Print(err)
+		return
+	}
+	dapSymbols = true
+}
+
 func openConsole() {
 	chlog := make(chan []byte, 8)
 	chcmd := make(chan []byte, 8)
-	chint := make(chan os.Signal)
+	chint := make(chan os.Signal, 1)
 	go ui.Serve(chlog, chcmd)
-	emulator.Wemulate(dapSrcFile, dapSteps, chint, chlog, chcmd)
+	machine.Wemulate(programSource(), dapSteps, chint, chlog, chcmd)
 	signal.Notify(chint, syscall.SIGINT, syscall.SIGTERM)
 	<-chint
 	log.Print("DAP.m * Console ends")
 }
 
+func saveFlowchart(prog *parser.Program) {
+	chart := flowchart.New(prog.Tree, prog.Spelling)
+	file, err := os.Create(dapChart)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	if filepath.Ext(dapChart) == ".svg" {
+		err = chart.WriteSVG(file)
+	} else {
+		err = chart.WriteDOT(file)
+	}
+	if err != nil {
+		log.Print(err)
+	} else {
+		log.Printf("Saving flowchart %v", dapChart)
+	}
+}
+
+func saveStructogram(prog *parser.Program) {
+	diagram := structogram.New(prog.Tree, prog.Spelling)
+	file, err := os.Create(dapNS)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	if filepath.Ext(dapNS) == ".html" {
+		err = diagram.WriteHTML(file)
+	} else {
+		err = diagram.WriteSVG(file)
+	}
+	if err != nil {
+		log.Print(err)
+	} else {
+		log.Printf("Saving structogram %v", dapNS)
+	}
+}
+
+func saveTranspiled(prog *parser.Program) {
+	var src []byte
+	var err error
+	switch filepath.Ext(dapTarget) {
+	case ".pas":
+		src, err = transpile.Pascal(prog.Tree, prog.Machine.WordSize)
+	case ".c":
+		src, err = transpile.C(prog.Tree, prog.Machine.WordSize)
+	default:
+		src, err = transpile.Go(prog.Tree, prog.Machine.WordSize)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(dapTarget, src, 0644)
+	}
+	if err != nil {
+		log.Print(err)
+	} else {
+		log.Printf("Saving transpiled program %v", dapTarget)
+	}
+}
+
+func saveTypeset(prog *parser.Program) {
+	src, err := ioutil.ReadFile(dapSrcFile)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	doc, err := typeset.New(prog.Tree, src, prog.Spelling)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	file, err := os.Create(dapTypeset)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer file.Close()
+	if filepath.Ext(dapTypeset) == ".html" {
+		err = doc.WriteHTML(file)
+	} else {
+		err = doc.WriteLaTeX(file, dapTeX)
+	}
+	if err != nil {
+		log.Print(err)
+	} else {
+		log.Printf("Saving typeset program %v", dapTypeset)
+	}
+}
+
+func printXref(prog *parser.Program) {
+	table := xref.New(prog.Tree, prog.Spelling)
+	if dapXref == "json" {
+		if data, err := table.JSON(); err != nil {
+			log.Print(err)
+		} else {
+			os.Stdout.Write(data)
+		}
+	} else if err := table.WriteText(os.Stdout); err != nil {
+		log.Print(err)
+	}
+}
+
+func measure(prog *parser.Program) {
+	m := metrics.New(prog.Tree)
+	m.Check(prog.Tree, dapLimits, prog.Diagnostics)
+	if dapMetrics == "json" {
+		if data, err := m.JSON(); err != nil {
+			log.Print(err)
+		} else {
+			os.Stdout.Write(data)
+		}
+	} else if dapMetrics == "text" {
+		if err := m.WriteText(os.Stdout); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+func noWarn() []string {
+	if dapNoWarn == "" {
+		return nil
+	}
+	return strings.Split(dapNoWarn, ",")
+}
+
+// all diagnostics as json, for editors and graders
+/* the diagnostics as json, on the standard output or in a file,
+   those of the run as well, the machine adds them to the same list
+*/
+func reportDiagnostics() {
+	if dapDiags == "text" {
+		return
+	}
+	if data, err := diagnostics.JSON(); err != nil {
+		log.Print(err)
+	} else if dapDiags == "json" {
+		os.Stdout.Write(data)
+	} else if err := ioutil.WriteFile(dapDiags, data, 0644); err != nil {
+		log.Print(err)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		os.Exit(formatMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "translate" {
+		os.Exit(translateMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		os.Exit(lspMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "debug-adapter" {
+		os.Exit(debugMain(os.Args[2:]))
+	}
 	if !validArgs() {
 		log.Fatal("Check command line")
 	}
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	diagnostics = diag.NewList(logger)
+	diagnostics.Disable(noWarn()...)
+	machine = emulator.New(logger, diagnostics)
+	machine.WordSize = dapWord
+	machine.OverflowTrap = dapOverflow == "trap"
 	if dapSource {
-		token := scanner.NewToken(dapSrcFile)
-		parser.Compile(token)
-		parser.ProcessSymbols()
-		emulator.GenCodes()
+		compiler := parser.Compiler{
+			SearchPath:   filepath.SplitList(dapUnitPath),
+			Keywords:     dapKeywords,
+			Strict:       dapStrict,
+			WordSize:     dapWord,
+			OverflowTrap: dapOverflow == "trap",
+			NoWarn:       noWarn(),
+		}
+		prog, err := compiler.CompileFile(dapSrcFile)
+		diagnostics = prog.Diagnostics
+		if err != nil {
+			reportDiagnostics()
+			log.Fatal(err)
+		}
+		machine = prog.Machine
+		if dapChart != "" {
+			saveFlowchart(prog)
+		}
+		if dapNS != "" {
+			saveStructogram(prog)
+		}
+		if dapTarget != "" {
+			saveTranspiled(prog)
+		}
+		if dapTypeset != "" {
+			saveTypeset(prog)
+		}
+		if dapXref != "" {
+			printXref(prog)
+		}
+		measure(prog)
 	} else if dapSymbolic {
-		emulator.LoadSymbols(dapSrcFile)
-		emulator.GenCodes()
+		machine.LoadSymbols(dapSrcFile)
+		machine.GenCodes()
+		loadSymbolTables()
 	} else if dapInternal {
-		emulator.LoadCodes(dapSrcFile)
+		machine.LoadCodes(dapSrcFile)
+		loadSymbolTables()
+	} else if dapPacked {
+		if err := machine.LoadContainer(dapSrcFile); err != nil {
+			log.Fatal(err)
+		}
 	}
 
+	if dapListing != "" {
+		machine.SaveListing(dapListing)
+	}
 	if dapCompile {
-		emulator.SaveSymbols(dapDestFile)
+		machine.SaveSymbols(dapDestFile)
 	} else if dapAssembly {
-		emulator.SaveCodes(dapDestFile)
+		machine.SaveCodes(dapDestFile)
+	} else if dapPackage {
+		machine.SaveContainer(dapDestFile, dapEmbed)
+	}
+	if (dapCompile || dapAssembly) && dapSource {
+		machine.SaveVariables(besideCodes(dapDestFile))
 	}
 	if dapAnimate {
-		if dapSource {
+		if dapSource || dapPacked || dapSymbols {
 			performAnimation()
 		} else {
-			log.Print("At this time, only source can be emulated")
+			log.Print("Compiled codes are animated with their symbol tables, see -symbols")
 		}
 	} else if dapConsole {
 		openConsole()
 	} else if dapRun {
-		emulator.Emulate(dapSteps)
+		machine.Emulate(dapSteps)
 	} else if dapSource {
-		emulator.SaveVariables(dapSrcFile + "sym")
+		machine.SaveVariables(dapSrcFile + "sym")
 	}
+	reportDiagnostics()
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"dap/adapter"
+)
+
+/* dap debug-adapter, a debug adapter for editors, over the standard
+   input and output, the program and its flags come with the launch
+   request, the log goes to the standard error
+*/
+func debugMain(args []string) int {
+	flags := flag.NewFlagSet("debug-adapter", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `
+Serves the Debug Adapter Protocol over the standard input and output, for editors,
+breakpoints, stepping, variables, and expressions of a .dap program running in the emulator,
+the launch request gives the program, its input, and the flags, as
+{"program": "prog.dap", "stdin": "", "stopOnEntry": false, "units": "<dir:dir>", "keywords": "<profile>", "strict": false, "word": 64, "overflow": "wrap|trap"}
+%s debug-adapter
+`, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	logger.Print("*** DAP debug adapter")
+	if err := adapter.NewServer(os.Stdin, os.Stdout, logger).Serve(); err != nil {
+		logger.Print(err)
+		return 1
+	}
+	return 0
+}
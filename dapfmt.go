@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"dap/format"
+)
+
+/* dap fmt, rewrites sources in the canonical layout,
+   to the standard output, or back into the files with -w
+*/
+func formatMain(args []string) int {
+	var (
+		opt   format.Options
+		write bool
+	)
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `
+Formats DAP sources, a style not given is the one the source starts with
+%s fmt [-w] [-indent <n>] [-keywords <profile>] [-comment {|//|(*|/*] [-quote '|"|‘|“] [-assign <-|:=|←] <source.dap> ...
+`, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&write, "w", false, "Write the result back into the source file")
+	flags.IntVar(&opt.Indent, "indent", 4, "Spaces per block level")
+	flags.StringVar(&opt.Keywords, "keywords", "", "Keyword profile, english, indonesian, or a .json profile file")
+	flags.StringVar(&opt.Comment, "comment", "", "Comment style, "+strings.Join(format.Comments, " "))
+	flags.StringVar(&opt.Quote, "quote", "", "String quote, "+strings.Join(format.Quotes, " "))
+	flags.StringVar(&opt.Assign, "assign", "", "Assignment, "+strings.Join(format.Assigns, " "))
+	flags.Parse(args)
+
+	ok := flags.NArg() > 0
+	if !ok {
+		fmt.Fprintln(flags.Output(), "Source file must be given")
+	}
+	for _, choice := range []struct {
+		name, val string
+		valid     []string
+	}{{"comment", opt.Comment, format.Comments}, {"quote", opt.Quote, format.Quotes}, {"assign", opt.Assign, format.Assigns}} {
+		if choice.val != "" && !contains(choice.valid, choice.val) {
+			fmt.Fprintf(flags.Output(), "The %v is one of %v\n", choice.name, strings.Join(choice.valid, " "))
+			ok = false
+		}
+	}
+	if !ok {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, fname := range flags.Args() {
+		src, err := ioutil.ReadFile(fname)
+		if err == nil {
+			src, err = format.Source(fname, src, opt)
+		}
+		if err != nil {
+			log.Print(err)
+			status = 1
+		} else if !write {
+			os.Stdout.Write(src)
+		} else if err := ioutil.WriteFile(fname, src, 0644); err != nil {
+			log.Print(err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"dap/lsp"
+	"dap/parser"
+)
+
+/* dap lsp, a language server for editors, over the standard input
+   and output, the log goes to the standard error
+*/
+func lspMain(args []string) int {
+	var (
+		compiler parser.Compiler
+		units    string
+		nowarn   string
+	)
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `
+Serves the Language Server Protocol over the standard input and output, for editors,
+diagnostics, hover, definition, document symbols, completion, and formatting of .dap sources
+%s lsp [-I <dir:dir>] [-keywords <profile> [-strict]] [-word <bits>] [-nowarn <code,code>]
+`, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&units, "I", "", "Folders where units are located, separated by '"+string(filepath.ListSeparator)+"'")
+	flags.StringVar(&compiler.Keywords, "keywords", "", "Keyword profile, english, indonesian, or a .json profile file")
+	flags.BoolVar(&compiler.Strict, "strict", false, "Reject keywords of other profiles")
+	flags.IntVar(&compiler.WordSize, "word", 64, "Machine word size in bits, 8, 16, 32, or 64")
+	flags.StringVar(&nowarn, "nowarn", "", "Codes of the warnings left out, separated by ','")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	compiler.SearchPath = filepath.SplitList(units)
+	if nowarn != "" {
+		compiler.NoWarn = strings.Split(nowarn, ",")
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	logger.Print("*** DAP language server")
+	if err := lsp.NewServer(compiler, os.Stdin, os.Stdout, logger).Serve(); err != nil {
+		logger.Print(err)
+		return 1
+	}
+	return 0
+}
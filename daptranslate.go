@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"dap/diag"
+	"dap/format"
+)
+
+/* dap translate, the keywords of sources in another profile,
+   to the standard output, or back into the files with -w
+*/
+func translateMain(args []string) int {
+	var (
+		to    string
+		write bool
+	)
+	flags := flag.NewFlagSet("translate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `
+Rewrites the keywords of DAP sources in another profile, the rest is kept as written,
+names that are keywords of a .json profile are reported, those of the builtin
+profiles are reserved in every source
+%s translate -to <profile> [-w] <source.dap> ...
+`, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&to, "to", "", "Keyword profile, english, indonesian, or a .json profile file")
+	flags.BoolVar(&write, "w", false, "Write the result back into the source file")
+	flags.Parse(args)
+	if to == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	status := 0
+	for _, fname := range flags.Args() {
+		src, err := ioutil.ReadFile(fname)
+		if err == nil {
+			src, err = format.Translate(fname, src, to, diag.NewList(logger))
+		}
+		if err != nil {
+			log.Print(err)
+			status = 1
+		} else if !write {
+			os.Stdout.Write(src)
+		} else if err := ioutil.WriteFile(fname, src, 0644); err != nil {
+			log.Print(err)
+			status = 1
+		}
+	}
+	return status
+}
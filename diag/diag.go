@@ -0,0 +1,128 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"unicode"
+)
+
+/* problems found in a program, while compiling or running it
+
+   Each has a stable code, the letter tells who found it, S the scanner,
+   P the parser, E the emulator, e.g. P101 is a missing keyword.
+   Lines count from 1, columns count the characters from 0,
+   as the LINE codes do. The end is just past the problem, it is
+   the start when only a point is known.
+*/
+
+type Severity string
+
+const (
+	Error   Severity = "error"   // the program is not compiled, or stops
+	Warning Severity = "warning" // reported, not counted
+)
+
+// replace the range of the diagnostic by Text, an empty range inserts it
+type Fix struct {
+	Message string `json:"message"`
+	Text    string `json:"text"`
+}
+
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Col      int      `json:"col"`
+	EndLine  int      `json:"endLine"`
+	EndCol   int      `json:"endCol"`
+	Code     string   `json:"code"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Fix      *Fix     `json:"fix,omitempty"`
+}
+
+// a diagnostic of length characters at line:col
+func At(file string, line, col, length int) Diagnostic {
+	return Diagnostic{File: file, Line: line, Col: col, EndLine: line, EndCol: col + length}
+}
+
+func (d Diagnostic) Errorf(code, format string, args ...interface{}) Diagnostic {
+	d.Code, d.Severity, d.Message = code, Error, fmt.Sprintf(format, args...)
+	return d
+}
+
+func (d Diagnostic) Warnf(code, format string, args ...interface{}) Diagnostic {
+	d.Code, d.Severity, d.Message = code, Warning, fmt.Sprintf(format, args...)
+	return d
+}
+
+func (d Diagnostic) WithFix(text, format string, args ...interface{}) Diagnostic {
+	d.Fix = &Fix{Message: fmt.Sprintf(format, args...), Text: text}
+	return d
+}
+
+// as it is logged, DAP.s, DAP.p, or DAP.e after the code
+func (d Diagnostic) String() string {
+	who := 'x'
+	if d.Code != "" {
+		who = unicode.ToLower(rune(d.Code[0]))
+	}
+	if d.Severity == Warning {
+		return fmt.Sprintf("DAP.%c %v:%v -- %v (%v warning)", who, d.Line, d.Col, d.Message, d.Code)
+	}
+	return fmt.Sprintf("DAP.%c %v:%v -- %v (%v)", who, d.Line, d.Col, d.Message, d.Code)
+}
+
+/* diagnostics of a compilation, and of running it,
+   in the order they are found
+*/
+type List struct {
+	log    *log.Logger
+	items  []Diagnostic
+	errors int
+	off    map[string]bool // warnings left out
+}
+
+// each diagnostic is also logged, unless logger is nil
+func NewList(logger *log.Logger) *List {
+	return &List{log: logger, items: []Diagnostic{}, off: map[string]bool{}}
+}
+
+// leave out the warnings of codes, e.g. those a course does not teach yet
+func (l *List) Disable(codes ...string) {
+	for _, c := range codes {
+		l.off[c] = true
+	}
+}
+
+func (l *List) Add(d Diagnostic) {
+	if d.Severity == Warning && l.off[d.Code] {
+		return
+	}
+	if d.Severity == Error {
+		l.errors++
+	}
+	l.items = append(l.items, d)
+	if l.log != nil {
+		l.log.Print(d)
+	}
+}
+
+func (l *List) Errors() int {
+	return l.errors
+}
+
+func (l *List) Items() []Diagnostic {
+	return l.items
+}
+
+// as an indented JSON array, ending with a newline
+func (l *List) JSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // <- is an assignment
+	enc.SetIndent("", "  ")
+	err := enc.Encode(l.items)
+	return buf.Bytes(), err
+}
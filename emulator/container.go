@@ -0,0 +1,337 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+)
+
+/* a compiled program in one .b4041 file, its codes with what the
+   emulator knows of them when it compiles the source
+
+   magic     8 bytes, \x7fDAP4041
+   version   2 bytes, little endian
+   sections  each a 4-byte name, a 4-byte little-endian length, and
+             its content, numbers in it are varints, a string is its
+             length and its bytes
+      INFO   word size, flags, 1 when overflow traps
+      CODE   the count of the codes, the codes
+      FILE   the count of the source files, their names, the main
+             program first, then the units
+      VARS   the count of varcoll, parent, name, type, value, offset
+      ASSG   the count of asscoll, parent, line, offset
+      LINE   the line table, the count of the LINE codes, address,
+             file, line, col of each
+      "SRC " the count of the sources, the text of each, in the order
+             of FILE, only when the sources are embedded
+   checksum  4 bytes, the little-endian CRC-32 (IEEE) of all before it
+
+   A section this version does not know is left out, a container of
+   another version is not loaded, nor one whose codes do not read as
+   instructions.
+*/
+
+const containerVersion = 1
+
+var containerMagic = []byte("\x7fDAP4041")
+
+type lineEntry struct {
+	Addr, File, Line, Col int
+}
+
+// the LINE codes of the program, at their addresses
+func (m *Machine) lineTable() []lineEntry {
+	table := []lineEntry{}
+	file := 0
+	for ip := 0; ip < len(m.prog); {
+		switch m.prog[ip] {
+		case FILE:
+			file = m.prog[ip+1]
+			ip += 2
+		case LINE:
+			table = append(table, lineEntry{ip, file, m.prog[ip+1], m.prog[ip+2]})
+			ip += 3
+		case LVAR:
+			ip += 3
+		case GVAR, PUSH:
+			ip += 2
+		default:
+			ip++
+		}
+	}
+	return table
+}
+
+// the text of the source file numbered i, embedded, or as fname has it
+func (m *Machine) source(i int, fname string) (string, error) {
+	if src, ok := m.embedded[i]; ok {
+		return src, nil
+	}
+	src, err := ioutil.ReadFile(fname)
+	return string(src), err
+}
+
+type section struct {
+	bytes.Buffer
+}
+
+func (s *section) int(v int) {
+	var b [binary.MaxVarintLen64]byte
+	s.Write(b[:binary.PutVarint(b[:], int64(v))])
+}
+
+func (s *section) string(v string) {
+	s.int(len(v))
+	s.WriteString(v)
+}
+
+func (m *Machine) SaveContainer(fname string, embed bool) { // save codes, symbols, and sources
+	m.log.Printf("Saving container %v", fname)
+	if err := ioutil.WriteFile(fname, m.container(embed), 0644); err != nil {
+		m.log.Panic(err)
+	}
+}
+
+func (m *Machine) container(embed bool) []byte {
+	var out bytes.Buffer
+	out.Write(containerMagic)
+	binary.Write(&out, binary.LittleEndian, uint16(containerVersion))
+	add := func(name string, s *section) {
+		out.WriteString(name)
+		binary.Write(&out, binary.LittleEndian, uint32(s.Len()))
+		out.Write(s.Bytes())
+	}
+
+	info := &section{}
+	info.int(m.WordSize)
+	info.int(tf[m.OverflowTrap])
+	add("INFO", info)
+
+	code := &section{}
+	code.int(len(m.prog))
+	for _, c := range m.prog {
+		code.int(c)
+	}
+	add("CODE", code)
+
+	files := &section{}
+	files.int(len(m.srcfiles))
+	for _, f := range m.srcfiles {
+		files.string(f)
+	}
+	add("FILE", files)
+
+	vars := &section{}
+	vars.int(len(m.varcoll))
+	for _, v := range m.varcoll {
+		vars.string(v.Parent)
+		vars.string(v.Name)
+		vars.string(v.Typ)
+		vars.string(v.Val)
+		vars.int(v.Loc)
+	}
+	add("VARS", vars)
+
+	assg := &section{}
+	assg.int(len(m.asscoll))
+	for _, a := range m.asscoll {
+		assg.string(a.Parent)
+		assg.int(a.Line)
+		assg.int(a.Off)
+	}
+	add("ASSG", assg)
+
+	table := m.lineTable()
+	lines := &section{}
+	lines.int(len(table))
+	for _, l := range table {
+		lines.int(l.Addr)
+		lines.int(l.File)
+		lines.int(l.Line)
+		lines.int(l.Col)
+	}
+	add("LINE", lines)
+
+	if embed {
+		srcs := &section{}
+		srcs.int(len(m.srcfiles))
+		for i, f := range m.srcfiles {
+			src, err := m.source(i, f)
+			if err != nil && f != "" {
+				m.log.Print(err)
+			}
+			srcs.string(src)
+		}
+		add("SRC ", srcs)
+	}
+
+	binary.Write(&out, binary.LittleEndian, crc32.ChecksumIEEE(out.Bytes()))
+	return out.Bytes()
+}
+
+var errTruncated = errors.New("the container is truncated")
+
+type reader struct {
+	*bytes.Reader
+	err error
+}
+
+func (r *reader) int() int {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r)
+	if err != nil {
+		r.err = errTruncated
+	}
+	return int(v)
+}
+
+// a count of items, each at least a byte long
+func (r *reader) count() int {
+	n := r.int()
+	if n < 0 || n > r.Len() {
+		if r.err == nil {
+			r.err = errTruncated
+		}
+		return 0
+	}
+	return n
+}
+
+func (r *reader) string() string {
+	n := r.count()
+	b := make([]byte, n)
+	if _, err := r.Read(b); err != nil && n > 0 {
+		r.err = errTruncated
+	}
+	return string(b)
+}
+
+func (m *Machine) LoadContainer(fname string) error { // load codes, symbols, and sources
+	m.log.Printf("Loading container %v", fname)
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	if err := m.unpack(data); err != nil {
+		return fmt.Errorf("%v: %v", fname, err)
+	}
+	return nil
+}
+
+func (m *Machine) unpack(data []byte) error {
+	head := len(containerMagic) + 2
+	if len(data) < head+4 || !bytes.Equal(data[:len(containerMagic)], containerMagic) {
+		return errors.New("not a DAP container")
+	}
+	if version := binary.LittleEndian.Uint16(data[len(containerMagic):]); version != containerVersion {
+		return fmt.Errorf("container version %v, this dap loads version %v", version, containerVersion)
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return errors.New("the checksum of the container does not match, it is damaged")
+	}
+
+	var table []lineEntry
+	seen := map[string]bool{}
+	for rest := body[head:]; len(rest) > 0; {
+		if len(rest) < 8 {
+			return errTruncated
+		}
+		name, size := string(rest[:4]), int(binary.LittleEndian.Uint32(rest[4:8]))
+		if size > len(rest)-8 {
+			return errTruncated
+		}
+		r := &reader{Reader: bytes.NewReader(rest[8 : 8+size])}
+		rest = rest[8+size:]
+		seen[name] = true
+		switch name {
+		case "INFO":
+			m.WordSize = r.int()
+			m.OverflowTrap = r.int()&1 != 0
+			if r.err == nil && m.WordSize != 8 && m.WordSize != 16 && m.WordSize != 32 && m.WordSize != 64 {
+				return fmt.Errorf("a word of %v bits", m.WordSize)
+			}
+		case "CODE":
+			m.prog = make(codes, r.count())
+			for i := range m.prog {
+				m.prog[i] = r.int()
+			}
+		case "FILE":
+			m.srcfiles = make([]string, r.count())
+			for i := range m.srcfiles {
+				m.srcfiles[i] = r.string()
+			}
+		case "VARS":
+			m.varcoll = make([]nameattr, r.count())
+			for i := range m.varcoll {
+				v := &m.varcoll[i]
+				v.Parent, v.Name, v.Typ, v.Val, v.Loc = r.string(), r.string(), r.string(), r.string(), r.int()
+			}
+		case "ASSG":
+			m.asscoll = make([]assgattr, r.count())
+			for i := range m.asscoll {
+				a := &m.asscoll[i]
+				a.Parent, a.Line, a.Off = r.string(), r.int(), r.int()
+			}
+		case "LINE":
+			table = make([]lineEntry, r.count())
+			for i := range table {
+				table[i] = lineEntry{r.int(), r.int(), r.int(), r.int()}
+			}
+		case "SRC ":
+			m.embedded = map[int]string{}
+			for i, n := 0, r.count(); i < n; i++ {
+				m.embedded[i] = r.string()
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("section %v: %v", name, r.err)
+		}
+	}
+	for _, name := range []string{"INFO", "CODE", "FILE", "VARS", "ASSG", "LINE"} {
+		if !seen[name] {
+			return fmt.Errorf("the container has no %v section", name)
+		}
+	}
+	if len(m.srcfiles) == 0 {
+		m.srcfiles = []string{""}
+	}
+	if err := m.checkCodes(len(m.srcfiles)); err != nil {
+		return err
+	}
+	if fmt.Sprint(table) != fmt.Sprint(m.lineTable()) {
+		return errors.New("the line table does not match the codes")
+	}
+	return nil
+}
+
+/* the codes are instructions with their operands, ending with EXIT,
+   a FILE is of one of the files, as the line table and a run read them
+*/
+func (m *Machine) checkCodes(files int) error {
+	names := map[int]string{}
+	for name, c := range sym2num {
+		names[c] = name
+	}
+	last := ""
+	for ip := 0; ip < len(m.prog); ip += codeSize(last) {
+		last = names[m.prog[ip]]
+		switch {
+		case last == "":
+			return fmt.Errorf("code %v at %v is not an instruction", m.prog[ip], ip)
+		case ip+codeSize(last) > len(m.prog):
+			return fmt.Errorf("%v at %v lacks its operands", last, ip)
+		case last == "FILE" && (m.prog[ip+1] < 0 || m.prog[ip+1] >= files):
+			return fmt.Errorf("FILE %v at %v, the container has %v files", m.prog[ip+1], ip, files)
+		}
+	}
+	if last != "EXIT" {
+		return errors.New("the codes do not end with EXIT")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/diag"
+)
+
+// data with its version and its section name replaced, its checksum again
+func rewritten(data []byte, version int, name string, content []byte) []byte {
+	head := len(containerMagic) + 2
+	var out bytes.Buffer
+	out.Write(containerMagic)
+	binary.Write(&out, binary.LittleEndian, uint16(version))
+	for rest := data[head : len(data)-4]; len(rest) > 0; {
+		size := int(binary.LittleEndian.Uint32(rest[4:8]))
+		s := rest[:8+size]
+		if string(rest[:4]) == name {
+			out.WriteString(name)
+			binary.Write(&out, binary.LittleEndian, uint32(len(content)))
+			out.Write(content)
+		} else {
+			out.Write(s)
+		}
+		rest = rest[8+size:]
+	}
+	binary.Write(&out, binary.LittleEndian, crc32.ChecksumIEEE(out.Bytes()))
+	return out.Bytes()
+}
+
+func codeSection(prog ...int) []byte {
+	s := &section{}
+	s.int(len(prog))
+	for _, c := range prog {
+		s.int(c)
+	}
+	return s.Bytes()
+}
+
+/* a container of another version, or whose codes do not read as
+   instructions, is not loaded, though its checksum matches
+*/
+func TestUnpack(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "container", "squares.b4041"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	if err := New(logger, diag.NewList(logger)).unpack(data); err != nil {
+		t.Fatalf("squares.b4041: %v", err)
+	}
+	for _, c := range []struct {
+		data []byte
+		want string
+	}{
+		{rewritten(data, 0, "", nil), "container version 0"},
+		{rewritten(data, 2, "", nil), "container version 2"},
+		{rewritten(data, 1, "CODE", codeSection(LINE, 1)), "LINE at 0 lacks its operands"},
+		{rewritten(data, 1, "CODE", codeSection(PUSH, 1, PUSH)), "PUSH at 2 lacks its operands"},
+		{rewritten(data, 1, "CODE", codeSection(FILE, 7, EXIT)), "FILE 7 at 0"},
+		{rewritten(data, 1, "CODE", codeSection(FILE, -1, EXIT)), "FILE -1 at 0"},
+		{rewritten(data, 1, "CODE", codeSection(99, EXIT)), "code 99 at 0 is not an instruction"},
+		{rewritten(data, 1, "CODE", codeSection(PUSH, EXIT)), "do not end with EXIT"},
+		{rewritten(data, 1, "CODE", codeSection()), "do not end with EXIT"},
+	} {
+		err := New(logger, diag.NewList(logger)).unpack(c.data)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("error %v, want %v", err, c.want)
+		}
+	}
+}
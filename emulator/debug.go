@@ -0,0 +1,247 @@
+package emulator
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/* the program run one instruction at a time, for a debugger
+
+   Each LINE starts a statement, where a debugger stops. The input is
+   given as text, a value the text does not have yet makes the
+   instruction wait for more. The output is written as Emulate writes
+   it. A run-time error is a diagnostic, as Wemulate, the program may
+   go on after it, but not after a trapped overflow.
+*/
+
+// what a step ended with
+type Event int
+
+const (
+	Stepped   Event = iota // an instruction
+	AtLine                 // a LINE, a statement starts
+	NeedInput              // an input instruction waits for more text
+	Failed                 // a run-time error, it is the last diagnostic
+	Exited                 // the program ended
+)
+
+type Debugger struct {
+	File, Line, Col int // where the program is, as the last LINE gives it
+	Steps           int
+
+	m     *Machine
+	r     *run
+	out   *printer
+	input string
+	done  bool
+}
+
+// a variable or a constant, with its value when the program stopped
+type Variable struct {
+	Name  string // a name of a unit is qualified by it
+	Typ   string // $INT, $BOOL, $CHAR, ...
+	Loc   int    // of a variable on the stack, 0 for a constant
+	Const bool
+	Set   bool // assigned, a constant is always
+	Word  int  // the value in the machine
+	Value string
+}
+
+// start the program over, the output goes to out
+func (m *Machine) Debugger(out io.Writer) *Debugger {
+	d := &Debugger{m: m, out: &printer{w: out}}
+	d.r = m.newRun(d.read, d.out.put)
+	m.maxtop = 0
+	return d
+}
+
+// more text for the input instructions
+func (d *Debugger) Input(text string) {
+	d.input += text
+}
+
+// the frames the program runs in, 1 for the program until it claims its variables
+func (d *Debugger) Depth() int {
+	if d.r.frames < 1 {
+		return 1
+	}
+	return d.r.frames
+}
+
+// the next word of the input, "" if there is none yet
+func (d *Debugger) word() string {
+	text := strings.TrimLeftFunc(d.input, unicode.IsSpace)
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		end = len(text)
+	}
+	d.input = text[end:]
+	return text[:end]
+}
+
+// a value of the input for ins, none when the text has none yet
+func (d *Debugger) read(ins int) (int, bool) {
+	if ins == INPC {
+		if d.input == "" {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(d.input)
+		d.input = d.input[size:]
+		return int(r), true
+	}
+	w := d.word()
+	if w == "" {
+		return 0, false
+	}
+	var v int
+	if ins == INPI {
+		fmt.Sscan(w, &v)
+	} else {
+		var b bool
+		fmt.Sscan(w, &b)
+		v = tf[b]
+	}
+	return v, true
+}
+
+// one instruction, or none when it waits for input or the program ended
+func (d *Debugger) Step() Event {
+	if d.done {
+		return Exited
+	}
+	d.Steps++
+	eff, _ := d.m.exec(d.r)
+	d.File, d.Line, d.Col = d.r.file, d.r.line, d.r.col
+	switch {
+	case eff == waits:
+		d.Steps--
+		return NeedInput
+	case eff == exited:
+		if d.out.charline {
+			fmt.Fprintln(d.out.w)
+		}
+		d.done = true
+		return Exited
+	case d.r.halted:
+		d.done = true
+	}
+	if d.r.failed {
+		return Failed
+	}
+	if eff == lined {
+		return AtLine
+	}
+	return Stepped
+}
+
+// the source files of the program, the main program first
+func (m *Machine) SourceFiles() []string {
+	return append([]string{}, m.srcfiles...)
+}
+
+// the index of the source file fname, -1 if the program has none
+func (m *Machine) SourceIndex(fname string) int {
+	abs := func(f string) string {
+		if a, err := filepath.Abs(f); err == nil {
+			return a
+		}
+		return filepath.Clean(f)
+	}
+	for i, f := range m.srcfiles {
+		if f != "" && abs(f) == abs(fname) {
+			return i
+		}
+	}
+	return -1
+}
+
+// the lines with a LINE code, of each source file, in order
+func (m *Machine) CodeLines() map[int][]int {
+	lines := map[int][]int{}
+	for _, l := range m.lineTable() {
+		lines[l.File] = append(lines[l.File], l.Line)
+	}
+	for _, l := range lines {
+		sort.Ints(l)
+	}
+	return lines
+}
+
+// the variables, by offset, then the constants, by name
+func (d *Debugger) Variables() []Variable {
+	vars, consts := []Variable{}, []Variable{}
+	for _, v := range d.m.varcoll {
+		if v.Parent != "" {
+			continue // locals of subprograms, when there are
+		}
+		if v.Loc == 0 {
+			consts = append(consts, d.variable(v))
+		} else {
+			vars = append(vars, d.variable(v))
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Loc < vars[j].Loc })
+	sort.Slice(consts, func(i, j int) bool { return consts[i].Name < consts[j].Name })
+	return append(vars, consts...)
+}
+
+// the variable or constant name, a name of a unit may go without it when only one has it
+func (d *Debugger) Lookup(name string) (Variable, bool) {
+	var found []nameattr
+	for _, v := range d.m.varcoll {
+		if v.Parent != "" {
+			continue
+		}
+		if v.Name == name {
+			return d.variable(v), true
+		}
+		if strings.HasSuffix(v.Name, "."+name) {
+			found = append(found, v)
+		}
+	}
+	if len(found) != 1 {
+		return Variable{}, false
+	}
+	return d.variable(found[0]), true
+}
+
+func (d *Debugger) variable(v nameattr) Variable {
+	typ := v.Typ
+	if typ == "$NUMBER" {
+		typ = "$INT"
+	}
+	vr := Variable{Name: v.Name, Typ: typ, Loc: v.Loc, Const: v.Loc == 0}
+	switch {
+	case v.Loc == 0 && v.Val != EMPTY:
+		vr.Set = true
+		vr.Word, _ = strconv.Atoi(tv2nums(v.Typ, v.Val))
+	case v.Loc == 0: // computed when the program starts, where COPY finds it
+		vr.Set = d.m.top >= 0 && d.r.frames > 0
+		vr.Word = d.m.stack[0]
+	default:
+		vr.Set = !d.r.empty[v.Loc] && d.r.frames > 0
+		vr.Word = d.m.stack[v.Loc]
+	}
+	vr.Value = "not initialized"
+	if vr.Set {
+		vr.Value = FormatWord(typ, vr.Word)
+	}
+	return vr
+}
+
+// a word of the machine as a value of typ
+func FormatWord(typ string, w int) string {
+	switch typ {
+	case "$BOOL":
+		return strconv.FormatBool(w != 0)
+	case "$CHAR", "$CHARRAY":
+		return strconv.QuoteRune(rune(w))
+	}
+	return strconv.Itoa(w)
+}
@@ -2,14 +2,16 @@ package emulator
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
+	"math"
 	"os"
 	"strconv"
-	"time"
+	"unicode/utf8"
+	"dap/diag"
 )
 
 const (
@@ -18,6 +20,7 @@ const (
 	LINE   = 2   // line and col in source
 	GVAR   = 3   // var, global variable
 	LVAR   = 4   // func var, local variable
+	FILE   = 5   // source file index, 0 is the main program
 	CLAIM  = 11  // spc=stack[TOP]; stack[TOP]=BASE; BASE=TOP; TOP+=spc
 	FREE   = 12  // TOP=BASE; BASE=stack[TOP]; TOP-- // if stack[TOP] < BASE
 	COPY   = 13  // stack[TOP]=stack[stack[TOP]] // global var
@@ -74,19 +77,115 @@ type tagVal struct {
 
 var tf = map[bool]int{false: 0, true: 1}
 
-var (
+/* a program, as codes being generated or assembled, and the machine
+   running it, one for each compilation
+*/
+type Machine struct {
+	WordSize     int  // bits of the machine word, 8, 16, 32, or 64
+	OverflowTrap bool // stop on integer overflow, instead of wrapping around
+
+	log      *log.Logger
+	diags    *diag.List
 	stack    memory
 	prog     codes
-	errcount = 0
-	iR       = NOP
-	iP       = 0
-	base     = 0
-	top      = -1
-	maxtop   = 0
-	step     = 0
-	done     = false
-	strinput = ""
-)
+	iR       int
+	iP       int
+	base     int
+	top      int
+	maxtop   int
+	step     int
+	done     bool
+	strinput string
+	given    bool // the web user gave the input
+
+	s4041    scodes
+	ilabel   int
+	lastline int
+	lastcol  int
+	srcfiles []string // the main program, then units by first use
+	curfile  int
+	lastfile int
+	varcoll  []nameattr
+	asscoll  []assgattr
+	embedded map[int]string // sources a container has, by file index
+}
+
+// a 64-bit machine, messages go to logger, problems to diags
+func New(logger *log.Logger, diags *diag.List) *Machine {
+	return &Machine{
+		WordSize: 64,
+		log:      logger,
+		diags:    diags,
+		iR:       NOP,
+		top:      -1,
+		s4041:    scodes{},
+		ilabel:   1000,
+		srcfiles: []string{""},
+		varcoll:  []nameattr{},
+		asscoll:  []assgattr{},
+	}
+}
+
+// a problem at line:col of the source file numbered file
+func (m *Machine) at(file, line, col int) diag.Diagnostic {
+	fname := ""
+	if file >= 0 && file < len(m.srcfiles) {
+		fname = m.srcfiles[file]
+	}
+	return diag.At(fname, line, col, 0)
+}
+
+// wrap around the machine word
+func (m *Machine) wrap(v int) int {
+	if m.WordSize >= 64 {
+		return v
+	}
+	shift := 64 - uint(m.WordSize)
+	return int(int64(v) << shift >> shift)
+}
+
+// results in the machine word, true on overflow
+func (m *Machine) Add(a, b int) (int, bool) {
+	r := a + b
+	if m.WordSize >= 64 {
+		return r, (a >= 0) == (b >= 0) && (r >= 0) != (a >= 0)
+	}
+	return m.wrap(r), m.wrap(r) != r
+}
+
+func (m *Machine) Sub(a, b int) (int, bool) {
+	r := a - b
+	if m.WordSize >= 64 {
+		return r, (a >= 0) != (b >= 0) && (r >= 0) != (a >= 0)
+	}
+	return m.wrap(r), m.wrap(r) != r
+}
+
+func (m *Machine) Mul(a, b int) (int, bool) {
+	r := a * b
+	if m.WordSize >= 64 {
+		return r, a != 0 && (r/a != b || (a == -1 && b == math.MinInt64))
+	}
+	return m.wrap(r), m.wrap(r) != r
+}
+
+// b is not 0
+func (m *Machine) Div(a, b int) (int, bool) {
+	r := a / b
+	if m.WordSize >= 64 {
+		return r, a == math.MinInt64 && b == -1
+	}
+	return m.wrap(r), m.wrap(r) != r
+}
+
+func (m *Machine) Neg(a int) (int, bool) {
+	return m.Sub(0, a)
+}
+
+// v fits in the machine word
+func (m *Machine) InWord(v int) bool {
+	return m.wrap(v) == v
+}
 
 const (
 	traceInitial = iota
@@ -114,26 +213,25 @@ I asking input from the web user (which replied also by I command tag)
 O sending output value to the web user
 E inform web user the program has reached a normal termination
 P sending source program
+U sending sources of the used units
+F executing source code of particular file (index in P/U)
 D sending variable offsets
 A sending (linenum,offset) pairs
 */
-func webResponder(srcFile string, traceStatus int, chlog chan<- []byte, chcmd <-chan []byte) int {
+func (m *Machine) webResponder(srcFile string, traceStatus int, chlog chan<- []byte, chcmd <-chan []byte) int {
 	var respond tagVal
 	respJson := <-chcmd
 	json.Unmarshal(respJson, &respond)
 	// log.Print(string(respJson))
-	// log.Printf("%c:%v", respond.C, respond.V)
+	// m.log.Printf("%c:%v", respond.C, respond.V)
 	switch respond.C {
 	case 'L': // ask for source program and variables' offsets
-		if sf, err := os.Open(srcFile); err != nil {
-			log.Print(err)
-		} else if srcProg, err := ioutil.ReadAll(sf); err != nil {
-			log.Print(err)
+		if srcProg, err := m.source(0, srcFile); err != nil {
+			m.log.Print(err)
 		} else {
-			sf.Close()
-			sources := []tagVal{tagVal{'P', string(srcProg)}, tagVal{'D', varcoll}, tagVal{'A', asscoll}}
+			sources := []tagVal{tagVal{'P', srcProg}, tagVal{'U', m.unitSources()}, tagVal{'D', m.varcoll}, tagVal{'A', m.asscoll}}
 			if srcJson, err := json.Marshal(sources); err != nil {
-				log.Print(err)
+				m.log.Print(err)
 			} else {
 				chlog <- srcJson
 			}
@@ -141,75 +239,71 @@ func webResponder(srcFile string, traceStatus int, chlog chan<- []byte, chcmd <-
 		}
 		traceStatus = traceReady
 
-	case 'I': // delivering input from user
+	case 'I': // delivering input from user, the input instruction runs again with it
 		// if traceStatus == traceInput {}
-		top++
-		switch iR {
-		case INPI:
-			fmt.Sscan(respond.V.(string), &stack[top])
-			strinput = ""
-			// log.Print("input I = ", stack[top])
-		case INPB:
-			var b bool
-			fmt.Sscan(respond.V.(string), &b)
-			stack[top] = tf[b]
-			strinput = ""
-			// log.Print("input B = ", b)
-		case INPC:
-			/*
-				if strinput, err := strconv.Unquote("`" + respond.V.(string) + "`"); err != nil {
-					log.Print(err)
-					strinput = ""
-				} else {
-					stack[top] = int(strinput[0])
-					strinput = strinput[1:]
-				}
-			*/
-			strinput = respond.V.(string)
-			stack[top] = int(strinput[0])
-			strinput = strinput[1:]
-			// log.Printf("input C = %c", stack[top])
-		}
+		m.strinput, m.given = respond.V.(string), true
 		traceStatus = traceMore
 
 	case 'C': // Continue with another next allocated steps
 		// if traceStatus == traceInfinite {}
-		step = 0
+		m.step = 0
 		traceStatus = traceMore
 
 	case 'X': // Web user also agree to terminate
-		done = true
+		m.done = true
 		traceStatus = traceMore // ???
 		//close(chcmd)
 
 	case 'R': // Reset the process from the beginning
-		iP = 0
-		top = -1
-		base = 0
-		step = 0
-		strinput = ""
+		m.iP = 0
+		m.top = -1
+		m.base = 0
+		m.step = 0
+		m.strinput, m.given = "", false
 		traceStatus = traceMore
 
 	default:
-		log.Print("Emu unknown user command")
+		m.log.Print("Emu unknown user command")
 		traceStatus = traceError
 	}
 	return traceStatus
 }
 
 // emulate with web front-end
-func Wemulate(srcFile string, steps int, chint chan<- os.Signal, chlog chan<- []byte, chcmd <-chan []byte) {
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	stack = make(memory, memSIZE)
-	empty := make([]bool, memSIZE)
-	lastline := 0
-	lastcol := 0
+func (m *Machine) Wemulate(srcFile string, steps int, chint chan<- os.Signal, chlog chan<- []byte, chcmd <-chan []byte) {
 	trace := []tagVal{}
-	base = 0
-	top = -1
-	iP = 0
-	step = 0
-	done = false
+	r := m.newRun(func(ins int) (int, bool) {
+		switch {
+		case ins == INPC && m.strinput != "":
+		case !m.given:
+			return 0, false // ask the web user
+		case ins == INPI:
+			var w int
+			fmt.Sscan(m.strinput, &w)
+			m.strinput, m.given = "", false
+			return w, true
+		case ins == INPB:
+			var b bool
+			fmt.Sscan(m.strinput, &b)
+			m.strinput, m.given = "", false
+			return tf[b], true
+		}
+		r, size := utf8.DecodeRuneInString(m.strinput)
+		m.strinput, m.given = m.strinput[size:], false
+		return int(r), true
+	}, func(ins, w int) {
+		switch ins {
+		case OUTI:
+			trace = append(trace, tagVal{'O', w})
+		case OUTC:
+			trace = append(trace, tagVal{'O', fmt.Sprintf("%c", w)})
+		case OUTB:
+			trace = append(trace, tagVal{'O', w != 0})
+		}
+	})
+	m.step = 0
+	m.done = false
+	m.strinput, m.given = "", false
 	/*
 		    for prog[iP] != EXIT && step <= steps {
 				// log.Print( "[",iP,"]", prog[iP], prog[iP+1],"|",top,":", stack[:10] )
@@ -217,9 +311,6 @@ func Wemulate(srcFile string, steps int, chint chan<- os.Signal, chlog chan<- []
 	*/
 	traceStatus := traceInitial
 	for {
-		if maxtop < top {
-			maxtop = top
-		}
 		/*
 			1. if have enough trace (aka looping)/
 			      need input/
@@ -235,378 +326,80 @@ func Wemulate(srcFile string, steps int, chint chan<- os.Signal, chlog chan<- []
 		for traceStatus != traceMore {
 			if len(trace) == 0 { // dont send nothing
 			} else if traceJson, err := json.Marshal(trace); err != nil {
-				log.Printf("DAP.e %v:%v -- Fail to marshal trace data", lastline, lastcol)
+				m.log.Printf("DAP.e %v:%v -- Fail to marshal trace data", r.line, r.col)
 			} else {
-				// log.Print("Emu service sending traces")
+				// m.log.Print("Emu service sending traces")
 				chlog <- traceJson
-				// log.Print("Emu service done sending")
+				// m.log.Print("Emu service done sending")
 			}
-			traceStatus = webResponder(srcFile, traceStatus, chlog, chcmd)
+			traceStatus = m.webResponder(srcFile, traceStatus, chlog, chcmd)
 			if traceStatus == traceError {
 				trace = []tagVal{tagVal{'X', "Unknown user respond, please repeat"}}
 			} else {
 				trace = []tagVal{}
 			}
 		}
-		if done {
+		if m.done {
 			break
 		}
-		step++
-		if step > steps {
+		m.step++
+		if m.step > steps {
 			trace = append(trace, tagVal{C: 'C'})
 			traceStatus = traceInfinite
-		} else {
-			switch iR, iP = prog[iP], iP+1; iR {
-			case NOP, CMT:
-			case LINE:
-				lastline = prog[iP]
-				lastcol = prog[iP+1]
-				trace = append(trace, tagVal{'L', lastline})
-				iP += 2
-			case GVAR:
-				iP++
-			case LVAR:
-				iP += 2
-			case CLAIM:
-				spc := stack[top]
-				stack[top] = base
-				base = top
-				top += spc
-				for si := base + 1; si <= top; si++ {
-					stack[si] = rnd.Intn(7919) + 2
-					empty[si] = true
-				}
-			case FREE:
-				top = base
-				base = stack[top]
-				top--
-			case COPY:
-				if empty[top] {
-					trace = append(trace, tagVal{'X', "Illegal access to uninitialized variable"})
-					traceStatus = traceError
-					log.Printf("DAP.e %v:%v -- Illegal access to uninitialized variable, %v", lastline, lastcol, stack[top])
-					errcount++
-				}
-				stack[top] = stack[stack[top]]
-			case STORE:
-				trace = append(trace, tagVal{'V', stack[top-1]})
-				stack[stack[top]] = stack[top-1]
-				empty[stack[top]] = false
-				top -= 2
-			case LCOPY:
-				if empty[top] {
-					trace = append(trace, tagVal{'X', "Illegal access to uninitialized local variable"})
-					traceStatus = traceError
-					log.Printf("DAP.e %v:%v -- Illegal access to uninitialized local variable, %v", lastline, lastcol, stack[top])
-					errcount++
-				}
-				stack[top] = stack[base+stack[top]]
-			case LSTOR:
-				trace = append(trace, tagVal{'V', stack[top-1]})
-				stack[base+stack[top]] = stack[top-1]
-				empty[base+stack[top]] = false
-				top -= 2
-			case PUSH:
-				top++
-				stack[top] = prog[iP]
-				iP++
-			case POP:
-				top--
-			case DUP:
-				top++
-				stack[top] = stack[top-1]
-			case SWAP:
-				stack[top], stack[top-1] = stack[top-1], stack[top]
-			case NEG:
-				stack[top] = -stack[top]
-			case ADD:
-				stack[top-1] = stack[top-1] + stack[top]
-				top--
-			case SUB:
-				stack[top-1] = stack[top-1] - stack[top]
-				top--
-			case MUL:
-				stack[top-1] = stack[top-1] * stack[top]
-				top--
-			case DIV:
-				if stack[top] == 0 {
-					trace = append(trace, tagVal{'X', "Illegal division by zero"})
-					traceStatus = traceError
-					log.Printf("DAP.e %v:%v -- Illegal division by zero", lastline, lastcol)
-					errcount++
-
-				} else {
-					stack[top-1] = stack[top-1] / stack[top]
-				}
-				top--
-			case MOD:
-				if stack[top] == 0 {
-					trace = append(trace, tagVal{'X', "Illegal modulo division by zero"})
-					traceStatus = traceError
-					log.Printf("DAP.e %v:%v -- Illegal modulo division by zero", lastline, lastcol)
-					errcount++
-
-				} else {
-					stack[top-1] = stack[top-1] % stack[top]
-				}
-				top--
-			case NOT:
-				stack[top] = tf[stack[top] == 0]
-			case OR:
-				stack[top-1] = tf[stack[top-1] != 0 || stack[top] != 0]
-				top--
-			case AND:
-				stack[top-1] = tf[stack[top-1] != 0 && stack[top] != 0]
-				top--
-			case LT:
-				stack[top-1] = tf[stack[top-1] < stack[top]]
-				top--
-			case LEQ:
-				stack[top-1] = tf[stack[top-1] <= stack[top]]
-				top--
-			case GT:
-				stack[top-1] = tf[stack[top-1] > stack[top]]
-				top--
-			case GEQ:
-				stack[top-1] = tf[stack[top-1] >= stack[top]]
-				top--
-			case EQ:
-				stack[top-1] = tf[stack[top-1] == stack[top]]
-				top--
-			case NEQ:
-				stack[top-1] = tf[stack[top-1] != stack[top]]
-				top--
-			case INPI:
-				trace = append(trace, tagVal{C: 'I'})
-				traceStatus = traceInput
-			case INPC:
-				if strinput == "" {
-					trace = append(trace, tagVal{C: 'I'})
-					traceStatus = traceInput
-				} else {
-					top++
-					stack[top] = int(strinput[0])
-					strinput = strinput[1:]
-				}
-			case INPB:
-				trace = append(trace, tagVal{C: 'I'})
-				traceStatus = traceInput
-			case OUTI:
-				trace = append(trace, tagVal{'O', stack[top]})
-				top--
-			case OUTC:
-				trace = append(trace, tagVal{'O', fmt.Sprintf("%c", stack[top])})
-				// trace = append(trace, tagVal{'O', strconv.QuoteRuneToASCII(rune(stack[top]))})
-				// log.Print("output C ", strconv.QuoteRuneToASCII(rune(stack[top])))
-				top--
-			case OUTB:
-				trace = append(trace, tagVal{'O', stack[top] != 0})
-				top--
-			case SAVEIP:
-				top++
-				stack[top] = iP
-			case COND:
-				if stack[top-1] != 0 {
-					iP = stack[top]
-				}
-				top -= 2
-			case NCOND:
-				if stack[top-1] == 0 {
-					iP = stack[top]
-				}
-				top -= 2
-			case CALL:
-				stack[top], iP = iP, stack[top]
-			case GOTO:
-				iP = stack[top]
-				top--
-			case EXIT:
-				trace = append(trace, tagVal{C: 'E'})
-				traceStatus = traceExit
-				iP = 0
-			}
+			continue
+		}
+		switch eff, w := m.exec(r); eff {
+		case lined:
+			trace = append(trace, tagVal{'L', r.line})
+		case filed:
+			trace = append(trace, tagVal{'F', r.file})
+		case stored:
+			trace = append(trace, tagVal{'V', w})
+		case waits:
+			m.step--
+			trace = append(trace, tagVal{C: 'I'})
+			traceStatus = traceInput
+		case exited:
+			trace = append(trace, tagVal{C: 'E'})
+			traceStatus = traceExit
+			m.iP = 0
+		}
+		if r.fault != "" {
+			trace = append(trace, tagVal{'X', r.fault})
+		}
+		if r.failed {
+			traceStatus = traceError
 		}
 	}
-	log.Printf("DAP.e *** Stopped after %v steps", step)
+	m.log.Printf("DAP.e *** Stopped after %v steps", m.step)
 	close(chint)
 }
 
-func Emulate(steps int) {
-	log.Print("*** DAP executing the codes")
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	base = 0
-	top = -1
-	iP = 0
-	stack = make(memory, memSIZE)
-	empty := make([]bool, memSIZE)
-	step := 0
-	lastline := 0
-	lastcol := 0
-	charline := false
-	for prog[iP] != EXIT && step <= steps {
-		if maxtop < top {
-			maxtop = top
-		}
-		// log.Print( "[",iP,"]", prog[iP], prog[iP+1],"|",top,":", stack[:10] )
-		step++
-		switch iR, iP = prog[iP], iP+1; iR {
-		case NOP, CMT:
-		case LINE:
-			lastline = prog[iP]
-			lastcol = prog[iP+1]
-			iP += 2
-		case GVAR:
-			iP++
-		case LVAR:
-			iP += 2
-		case CLAIM:
-			spc := stack[top]
-			stack[top] = base
-			base = top
-			top += spc
-			for si := base + 1; si <= top; si++ {
-				stack[si] = rnd.Intn(7919) + 2
-				empty[si] = true
-			}
-		case FREE:
-			top = base
-			base = stack[top]
-			top--
-		case COPY:
-			if empty[top] {
-				log.Printf("DAP.e %v:%v -- Illegal access to uninitialized variable, %v", lastline, lastcol, stack[top])
-				errcount++
-			}
-			stack[top] = stack[stack[top]]
-		case STORE:
-			stack[stack[top]] = stack[top-1]
-			empty[stack[top]] = false
-			top -= 2
-		case LCOPY:
-			if empty[top] {
-				log.Printf("DAP.e %v:%v -- Illegal access to uninitialized local variable, %v", lastline, lastcol, stack[top])
-				errcount++
-			}
-			stack[top] = stack[base+stack[top]]
-		case LSTOR:
-			stack[base+stack[top]] = stack[top-1]
-			empty[base+stack[top]] = false
-			top -= 2
-		case PUSH:
-			top++
-			stack[top] = prog[iP]
-			iP++
-		case POP:
-			top--
-		case DUP:
-			top++
-			stack[top] = stack[top-1]
-		case SWAP:
-			stack[top], stack[top-1] = stack[top-1], stack[top]
-		case NEG:
-			stack[top] = -stack[top]
-		case ADD:
-			stack[top-1] = stack[top-1] + stack[top]
-			top--
-		case SUB:
-			stack[top-1] = stack[top-1] - stack[top]
-			top--
-		case MUL:
-			stack[top-1] = stack[top-1] * stack[top]
-			top--
-		case DIV:
-			if empty[top] {
-				log.Printf("DAP.e %v:%v -- Illegal division by zero", lastline, lastcol)
-				errcount++
-			} else {
-				stack[top-1] = stack[top-1] / stack[top]
-			}
-			top--
-		case MOD:
-			if empty[top] {
-				log.Printf("DAP.e %v:%v -- Illegal modulo division by zero", lastline, lastcol)
-			} else {
-				stack[top-1] = stack[top-1] % stack[top]
-			}
-			top--
-		case NOT:
-			stack[top] = tf[stack[top] == 0]
-		case OR:
-			stack[top-1] = tf[stack[top-1] != 0 || stack[top] != 0]
-			top--
-		case AND:
-			stack[top-1] = tf[stack[top-1] != 0 && stack[top] != 0]
-			top--
-		case LT:
-			stack[top-1] = tf[stack[top-1] < stack[top]]
-			top--
-		case LEQ:
-			stack[top-1] = tf[stack[top-1] <= stack[top]]
-			top--
-		case GT:
-			stack[top-1] = tf[stack[top-1] > stack[top]]
-			top--
-		case GEQ:
-			stack[top-1] = tf[stack[top-1] >= stack[top]]
-			top--
-		case EQ:
-			stack[top-1] = tf[stack[top-1] == stack[top]]
-			top--
-		case NEQ:
-			stack[top-1] = tf[stack[top-1] != stack[top]]
-			top--
+func (m *Machine) Emulate(steps int) {
+	m.log.Print("*** DAP executing the codes")
+	r := m.newRun(func(ins int) (int, bool) {
+		var w int
+		switch ins {
 		case INPI:
-			top++
-			fmt.Scan(&stack[top])
+			fmt.Scan(&w)
 		case INPC:
-			top++
-			fmt.Scanf("%c", &stack[top])
+			fmt.Scanf("%c", &w)
 		case INPB:
 			var b bool
 			fmt.Scan(&b)
-			top++
-			stack[top] = tf[b]
-		case OUTI:
-			if charline {
-				charline = false
-				fmt.Println()
-			}
-			fmt.Println(stack[top])
-			top--
-		case OUTC:
-			fmt.Printf("%c", stack[top])
-			charline = true
-			top--
-		case OUTB:
-			if charline {
-				charline = false
-				fmt.Println()
-			}
-			fmt.Println(stack[top] != 0)
-			top--
-		case SAVEIP:
-			top++
-			stack[top] = iP
-		case COND:
-			if stack[top-1] != 0 {
-				iP = stack[top]
-			}
-			top -= 2
-		case NCOND:
-			if stack[top-1] == 0 {
-				iP = stack[top]
-			}
-			top -= 2
-		case CALL:
-			stack[top], iP = iP, stack[top]
-		case GOTO:
-			iP = stack[top]
-			top--
+			w = tf[b]
 		}
+		return w, true
+	}, (&printer{w: os.Stdout}).put)
+	step := 0
+	for m.prog[m.iP] != EXIT && step <= steps && !r.halted {
+		// m.log.Print( "[",m.iP,"]", m.prog[m.iP], m.prog[m.iP+1],"|",m.top,":", m.stack[:10] )
+		step++
+		m.exec(r)
 	}
-	log.Printf("DAP.e *** Stopped after %v steps, mem=%v", step, maxtop)
+	m.log.Printf("DAP.e *** Stopped after %v steps, mem=%v", step, m.maxtop)
 	if step > steps {
-		log.Print("DAP.e -- Rerun using -time for more steps")
+		m.log.Print("DAP.e -- Rerun using -time for more steps")
 	}
 }
 
@@ -616,6 +409,7 @@ var sym2num = map[string]int{
 	"LINE":   2,
 	"GVAR":   3,
 	"LVAR":   4,
+	"FILE":   5,
 	"CLAIM":  11,
 	"FREE":   12,
 	"COPY":   13,
@@ -660,60 +454,60 @@ type labelrec struct {
 	c []int
 }
 
-func asm(s4041 []string) {
+func (m *Machine) asm(s4041 []string) {
 	var ins, sp1, sp2 string
 	var op1, op2 int
-	prog = codes{}
-	iP = 0
+	m.prog = codes{}
+	m.iP = 0
 	labels := map[string]labelrec{}
 	for _, cmd := range s4041 {
 		fmt.Sscanln(cmd, &ins, &sp1, &sp2)
 		op1, _ = strconv.Atoi(sp1)
 		op2, _ = strconv.Atoi(sp2)
 		// fmt.Println(iP, ins, sp1, sp2, cmd)
-		iP++
+		m.iP++
 		switch ins {
 		case "LABEL":
-			iP--
+			m.iP--
 			if sp1[0] == '@' {
 				l := labels[sp1]
-				l.p = iP
+				l.p = m.iP
 				labels[sp1] = l
 				// fmt.Println( "ref", sp1, labels[sp1])
 			}
 		case "LINE":
 			// fmt.Print( " ", sym2num[ins], op1, op2 )
-			prog = append(prog, sym2num[ins], op1, op2)
-			iP += 2
+			m.prog = append(m.prog, sym2num[ins], op1, op2)
+			m.iP += 2
 		case "CMT":
 			// fmt.Print( " ", sym2num["NOP"] )
-			prog = append(prog, sym2num["NOP"])
-		case "GVAR":
+			m.prog = append(m.prog, sym2num["NOP"])
+		case "GVAR", "FILE":
 			// fmt.Print( " ", sym2num[ins], op1 )
-			prog = append(prog, sym2num[ins], op1)
-			iP++
+			m.prog = append(m.prog, sym2num[ins], op1)
+			m.iP++
 		case "LVAR":
 			// fmt.Print( " ", sym2num[ins], op1, op2 )
-			prog = append(prog, sym2num[ins], op1, op2)
-			iP += 2
+			m.prog = append(m.prog, sym2num[ins], op1, op2)
+			m.iP += 2
 		case "PUSH":
 			// fmt.Print( " ", sym2num[ins], op1 )
 			if sp1[0] == '@' {
 				l := labels[sp1]
-				l.c = append(l.c, iP)
+				l.c = append(l.c, m.iP)
 				labels[sp1] = l
 				// fmt.Println( "usg", sp1, labels[sp1])
 			}
-			prog = append(prog, sym2num[ins], op1)
-			iP++
+			m.prog = append(m.prog, sym2num[ins], op1)
+			m.iP++
 		default:
 			// fmt.Print( " ", sym2num[ins] )
-			prog = append(prog, sym2num[ins])
+			m.prog = append(m.prog, sym2num[ins])
 		}
 	}
 	for _, l := range labels {
 		for _, op := range l.c {
-			prog[op] = l.p
+			m.prog[op] = l.p
 		}
 	}
 	// fmt.Println( prog )
@@ -725,6 +519,7 @@ var tok2sym = map[string]string{
 	"$LINE":   "LINE",
 	"$GVAR":   "GVAR",
 	"$LVAR":   "LVAR",
+	"$FILE":   "FILE",
 	"$CLAIM":  "CLAIM",
 	"$FREE":   "FREE",
 	"$COPY":   "COPY",
@@ -770,8 +565,6 @@ const FALSE = "false"
 
 type scodes []string
 
-var s4041 = scodes{}
-
 func tv2nums(t, v string) string {
 	newv := v
 	if v != EMPTY {
@@ -780,227 +573,240 @@ func tv2nums(t, v string) string {
 		case "$BOOL":
 			newv = strconv.Itoa(tf[v == TRUE])
 		case "$CHAR", "$CHARRAY":
-			newv = strconv.Itoa(int(v[0]))
+			r, _ := utf8.DecodeRuneInString(v)
+			newv = strconv.Itoa(int(r))
 		}
 	}
 	return newv
 }
 
-func GenNil(t string, p string, loc int) {
-	// log.Printf("NIL generated %v:%v", p, loc)
+func (m *Machine) GenNil(t string, p string, loc int) {
+	// m.log.Printf("NIL generated %v:%v", p, loc)
 }
 
-func GenClaim(spc int) {
-	s4041 = append(s4041, "PUSH "+strconv.Itoa(spc), "CLAIM")
-	// log.Print("CLAIM generated ", spc)
+func (m *Machine) GenClaim(spc int) {
+	m.s4041 = append(m.s4041, "PUSH "+strconv.Itoa(spc), "CLAIM")
+	// m.log.Print("CLAIM generated ", spc)
 }
 
-func GenFree() { // not yet, to be used for subprogram
+func (m *Machine) GenFree() { // not yet, to be used for subprogram
 }
 
-func GenCopy(p string, loc int) {
+func (m *Machine) GenCopy(p string, loc int) {
 	if p == "" {
-		s4041 = append(s4041, "PUSH "+strconv.Itoa(loc), "COPY")
+		m.s4041 = append(m.s4041, "PUSH "+strconv.Itoa(loc), "COPY")
 	} else {
-		s4041 = append(s4041, "PUSH "+strconv.Itoa(loc), "LCOPY")
+		m.s4041 = append(m.s4041, "PUSH "+strconv.Itoa(loc), "LCOPY")
 	}
-	// log.Printf("Copy var %v:%v", p, loc)
+	// m.log.Printf("Copy var %v:%v", p, loc)
 }
 
-func GenStore(t string, p string, loc int, v string) {
+func (m *Machine) GenStore(t string, p string, loc int, v string) {
 	if v != EMPTY {
-		// log.Printf("Push constant first %v:%v", t, v)
-		s4041 = append(s4041, "PUSH "+tv2nums(t, v))
+		// m.log.Printf("Push constant first %v:%v", t, v)
+		m.s4041 = append(m.s4041, "PUSH "+tv2nums(t, v))
 	}
-	s4041 = append(s4041, "PUSH "+strconv.Itoa(loc))
+	m.s4041 = append(m.s4041, "PUSH "+strconv.Itoa(loc))
 	if p == "" {
-		s4041 = append(s4041, "STORE")
+		m.s4041 = append(m.s4041, "STORE")
 	} else {
-		s4041 = append(s4041, "LSTOR")
+		m.s4041 = append(m.s4041, "LSTOR")
 	}
-	// log.Printf("Store var %v:%v", p, loc)
+	// m.log.Printf("Store var %v:%v", p, loc)
 }
 
-func GenConst(t string, v string) {
-	s4041 = append(s4041, "PUSH "+tv2nums(t, v))
-	// log.Print("Push ", v)
+func (m *Machine) GenConst(t string, v string) {
+	m.s4041 = append(m.s4041, "PUSH "+tv2nums(t, v))
+	// m.log.Print("Push ", v)
 }
 
-func GenOpCmd(op string) {
+func (m *Machine) GenOpCmd(op string) {
 	if tok2sym[op] == "" {
-		log.Printf("DAP.e %v:%v -- Empty cmd %v", lastline, lastcol, op)
-		errcount++
+		m.diags.Add(m.at(m.curfile, m.lastline, m.lastcol).Errorf("E001", "Empty cmd %v", op))
 	}
-	s4041 = append(s4041, tok2sym[op])
-	// log.Print(op, " applied")
+	m.s4041 = append(m.s4041, tok2sym[op])
+	// m.log.Print(op, " applied")
 }
 
-func GenOp2Cmd(op, atyp, aval, btyp, bval string) {
+func (m *Machine) GenOp2Cmd(op, atyp, aval, btyp, bval string) {
 	if aval != EMPTY {
-		s4041 = append(s4041, "PUSH "+tv2nums(atyp, aval))
+		m.s4041 = append(m.s4041, "PUSH "+tv2nums(atyp, aval))
 	}
 	if bval != EMPTY {
-		s4041 = append(s4041, "PUSH "+tv2nums(btyp, bval))
+		m.s4041 = append(m.s4041, "PUSH "+tv2nums(btyp, bval))
 	}
 	if tok2sym[op] == "" {
-		log.Printf("DAP.e %v:%v -- Empty cmd %v", lastline, lastcol, op)
-		errcount++
+		m.diags.Add(m.at(m.curfile, m.lastline, m.lastcol).Errorf("E001", "Empty cmd %v", op))
 	}
-	s4041 = append(s4041, tok2sym[op])
-	// log.Print(op, " applied")
+	m.s4041 = append(m.s4041, tok2sym[op])
+	// m.log.Print(op, " applied")
 }
 
-func GenInp(t string, p string, loc int) {
+func (m *Machine) GenInp(t string, p string, loc int) {
 	switch t {
 	case "$NUMBER", "$INT", "$REAL":
-		s4041 = append(s4041, "INPI")
+		m.s4041 = append(m.s4041, "INPI")
 	case "$BOOL":
-		s4041 = append(s4041, "INPB")
+		m.s4041 = append(m.s4041, "INPB")
 	case "$CHAR", "$CHARRAY":
-		s4041 = append(s4041, "INPC")
+		m.s4041 = append(m.s4041, "INPC")
 	}
-	s4041 = append(s4041, "PUSH "+strconv.Itoa(loc))
+	m.s4041 = append(m.s4041, "PUSH "+strconv.Itoa(loc))
 	if p == "" {
-		s4041 = append(s4041, "STORE")
+		m.s4041 = append(m.s4041, "STORE")
 	} else {
-		s4041 = append(s4041, "PUD")
+		m.s4041 = append(m.s4041, "PUD")
 	}
-	// log.Printf("INP generated %v:%v for %v", p, loc, t)
+	// m.log.Printf("INP generated %v:%v for %v", p, loc, t)
 }
 
-func GenOut(t string, v string) {
+func (m *Machine) GenOut(t string, v string) {
 	if v != EMPTY {
-		s4041 = append(s4041, "PUSH "+tv2nums(t, v))
+		m.s4041 = append(m.s4041, "PUSH "+tv2nums(t, v))
 	}
 	switch t {
 	case "$NUMBER", "$INT", "$REAL":
-		s4041 = append(s4041, "OUTI")
+		m.s4041 = append(m.s4041, "OUTI")
 	case "$BOOL":
-		s4041 = append(s4041, "OUTB")
+		m.s4041 = append(m.s4041, "OUTB")
 	case "$CHAR", "$CHARRAY":
-		s4041 = append(s4041, "OUTC")
+		m.s4041 = append(m.s4041, "OUTC")
 	}
-	// log.Printf("OUT generated %v:%v", t, v)
+	// m.log.Printf("OUT generated %v:%v", t, v)
 }
 
-var ilabel = 1000
-
-func GenLabel() string {
-	ilabel++
-	return "@L" + strconv.Itoa(ilabel)
+func (m *Machine) GenLabel() string {
+	m.ilabel++
+	return "@L" + strconv.Itoa(m.ilabel)
 }
 
-func GenLoc(l string) {
-	s4041 = append(s4041, "LABEL "+l)
-	// log.Printf("LABEL %v generated", l)
+func (m *Machine) GenLoc(l string) {
+	m.s4041 = append(m.s4041, "LABEL "+l)
+	// m.log.Printf("LABEL %v generated", l)
 }
 
-func GenGoto(l string) {
-	s4041 = append(s4041, "PUSH "+l, "GOTO")
-	// log.Printf("GOTO %v generated", l)
+func (m *Machine) GenGoto(l string) {
+	m.s4041 = append(m.s4041, "PUSH "+l, "GOTO")
+	// m.log.Printf("GOTO %v generated", l)
 }
 
-func GenCond(l, v string) {
+func (m *Machine) GenCond(l, v string) {
 	if v != EMPTY {
-		s4041 = append(s4041, "PUSH "+tv2nums("$BOOL", v))
+		m.s4041 = append(m.s4041, "PUSH "+tv2nums("$BOOL", v))
 	}
-	s4041 = append(s4041, "PUSH "+l, "NCOND")
-	// log.Printf("COND %v generated", l)
+	m.s4041 = append(m.s4041, "PUSH "+l, "NCOND")
+	// m.log.Printf("COND %v generated", l)
 }
 
-func GenCase(l, t, v string) {
+func (m *Machine) GenCase(l, t, v string) {
 	if v != EMPTY {
-		s4041 = append(s4041, "PUSH "+tv2nums(t, v))
+		m.s4041 = append(m.s4041, "PUSH "+tv2nums(t, v))
 	}
-	s4041 = append(s4041, "NEQ", "PUSH "+l, "COND")
-	// log.Printf("CASE %v generated", l)
+	m.s4041 = append(m.s4041, "NEQ", "PUSH "+l, "COND")
+	// m.log.Printf("CASE %v generated", l)
 }
 
-func GenDup() {
-	s4041 = append(s4041, "DUP")
-	// log.Printf("DUP top stack")
+func (m *Machine) GenDup() {
+	m.s4041 = append(m.s4041, "DUP")
+	// m.log.Printf("DUP top stack")
 }
 
-func GenPop() {
-	s4041 = append(s4041, "POP")
-	// log.Printf("POP top stack")
+func (m *Machine) GenPop() {
+	m.s4041 = append(m.s4041, "POP")
+	// m.log.Printf("POP top stack")
 }
 
-var lastline = 0
-var lastcol = 0
+func (m *Machine) GenLine(l, c int) {
+	if m.curfile != m.lastfile {
+		m.lastfile = m.curfile
+		m.lastline = 0
+		m.s4041 = append(m.s4041, "FILE "+strconv.Itoa(m.curfile))
+	}
+	if l > m.lastline {
+		m.lastline = l
+		m.lastcol = c
+		m.s4041 = append(m.s4041, "LINE "+strconv.Itoa(l)+" "+strconv.Itoa(c))
+	}
+	// m.log.Printf("LINE %v:%v", l, c)
+}
 
-func GenLine(l, c int) {
-	if l > lastline {
-		lastline = l
-		lastcol = c
-		s4041 = append(s4041, "LINE "+strconv.Itoa(l)+" "+strconv.Itoa(c))
+// later LINEs belong to this source file
+func (m *Machine) GenFile(fname string) {
+	for m.curfile = 0; m.curfile < len(m.srcfiles) && m.srcfiles[m.curfile] != fname; m.curfile++ {
+	}
+	if m.curfile == len(m.srcfiles) {
+		m.srcfiles = append(m.srcfiles, fname)
 	}
-	// log.Printf("LINE %v:%v", l, c)
 }
 
-func GenExit() {
-	s4041 = append(s4041, "EXIT")
-	// log.Print("EXIT done finished")
+// the main program goes by index 0
+func (m *Machine) GenMainFile(fname string) {
+	m.srcfiles[0] = fname
 }
 
-func GenInit() scodes {
-	return s4041
+func (m *Machine) GenExit() {
+	m.s4041 = append(m.s4041, "EXIT")
+	// m.log.Print("EXIT done finished")
 }
 
-func GenPrint() {
-	fmt.Printf("len=%v\n", len(s4041))
-	for i, v := range s4041 {
+func (m *Machine) GenInit() scodes {
+	return m.s4041
+}
+
+func (m *Machine) GenPrint() {
+	fmt.Printf("len=%v\n", len(m.s4041))
+	for i, v := range m.s4041 {
 		fmt.Printf("%v %v\n", i, v)
 	}
 }
 
-func GenCodes() {
-	log.Print("*** DAP assembling the codes")
-	asm(s4041)
+func (m *Machine) GenCodes() {
+	m.log.Print("*** DAP assembling the codes")
+	m.asm(m.s4041)
 }
 
-func SaveSymbols(fname string) { // save assembly codes
-	log.Printf("Saving symbolic %v", fname)
+func (m *Machine) SaveSymbols(fname string) { // save assembly codes
+	m.log.Printf("Saving symbolic %v", fname)
 	file, err := os.OpenFile(fname, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Panic(err)
+		m.log.Panic(err)
 	}
-	for _, s := range s4041 {
+	for _, s := range m.s4041 {
 		fmt.Fprintln(file, s)
 	}
 	file.Close()
 }
 
-func LoadSymbols(fname string) { // load assembly codes
-	log.Printf("Loading symbolic %v", fname)
+func (m *Machine) LoadSymbols(fname string) { // load assembly codes
+	m.log.Printf("Loading symbolic %v", fname)
 	file, err := os.Open(fname)
 	if err != nil {
-		log.Panic(err)
+		m.log.Panic(err)
 	}
 	symload := bufio.NewScanner(file)
 	for symload.Scan() {
-		s4041 = append(s4041, symload.Text())
+		m.s4041 = append(m.s4041, symload.Text())
 	}
 	file.Close()
 }
 
-func SaveCodes(fname string) { // save machine codes
-	log.Printf("Saving codes %v", fname)
+func (m *Machine) SaveCodes(fname string) { // save machine codes
+	m.log.Printf("Saving codes %v", fname)
 	file, err := os.OpenFile(fname, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Panic(err)
+		m.log.Panic(err)
 	}
-	for _, c := range prog {
+	for _, c := range m.prog {
 		fmt.Fprint(file, c, " ")
 	}
 	file.Close()
 }
 
-func LoadCodes(fname string) { // load machine codes
-	log.Printf("Loading codes %v", fname)
+func (m *Machine) LoadCodes(fname string) { // load machine codes
+	m.log.Printf("Loading codes %v", fname)
 	file, err := os.Open(fname)
 	if err != nil {
-		log.Panic(err)
+		m.log.Panic(err)
 	}
 	for {
 		var v int
@@ -1008,7 +814,7 @@ func LoadCodes(fname string) { // load machine codes
 		if err != nil {
 			break
 		}
-		prog = append(prog, v)
+		m.prog = append(m.prog, v)
 	}
 	file.Close()
 }
@@ -1029,44 +835,106 @@ type (
 	}
 )
 
-var (
-	varcoll = []nameattr{}
-	asscoll = []assgattr{}
-)
+func (m *Machine) CollectVariable(parent, name, typ, val string, loc int) { // collect variables attributes
+	m.varcoll = append(m.varcoll, nameattr{parent, name, typ, val, loc})
+}
 
-func CollectVariable(parent, name, typ, val string, loc int) { // collect variables attributes
-	varcoll = append(varcoll, nameattr{parent, name, typ, val, loc})
+func (m *Machine) CollectAssg(parent string, line int, offset int) { // collect (line,func:variable) assignment
+	m.asscoll = append(m.asscoll, assgattr{parent, line, offset})
 }
 
-func CollectAssg(parent string, line int, offset int) { // collect (line,func:variable) assignment
-	asscoll = append(asscoll, assgattr{parent, line, offset})
+type unitsrc struct {
+	Name   string
+	Source string
 }
 
-func SaveVariables(fname string) { // save symbol tables
-	log.Printf("Saving symbol tables %v", fname)
-	symbols := []tagVal{tagVal{'D', varcoll}, tagVal{'A', asscoll}}
-	if symJson, err := json.Marshal(symbols); err != nil {
-		log.Print(err)
-	} else if err := ioutil.WriteFile(fname, symJson, 0644); err != nil {
-		log.Panic(err)
+func (m *Machine) unitSources() []unitsrc { // sources of the used units, by file index
+	units := []unitsrc{}
+	for i, fname := range m.srcfiles[1:] {
+		if src, err := m.source(i+1, fname); err != nil {
+			m.log.Print(err)
+			units = append(units, unitsrc{fname, ""})
+		} else {
+			units = append(units, unitsrc{fname, src})
+		}
 	}
+	return units
 }
 
-func LoadVariables(fname string) { // load symbol tables
-	log.Printf("Loading symbol tables %v", fname)
+/* the symbol tables of a program, as a .dapsym file beside its codes,
+   what varcoll and asscoll have, and the source files the FILE codes
+   number, a file of another version is not loaded
+*/
+const symbolsVersion = 1
 
-	if symJson, err := ioutil.ReadFile(fname); err != nil {
-		log.Panic(err)
-	} else {
-		var symbols []tagVal
-		json.Unmarshal(symJson, &symbols)
-		for _, v := range symbols {
-			switch attr := v.V.(type) {
-			case []nameattr:
-				varcoll = attr
-			case []assgattr:
-				asscoll = attr
-			}
-		}
+type (
+	symbolFile struct {
+		Version     int         `json:"version"`
+		Files       []string    `json:"files"`
+		Variables   []symVar    `json:"variables"`
+		Assignments []symAssign `json:"assignments"`
+	}
+
+	symVar struct {
+		Parent string `json:"parent"`
+		Name   string `json:"name"`
+		Type   string `json:"type"`
+		Value  string `json:"value"`
+		Offset int    `json:"offset"`
+	}
+
+	symAssign struct {
+		Parent string `json:"parent"`
+		Line   int    `json:"line"`
+		Offset int    `json:"offset"`
+	}
+)
+
+func (m *Machine) SaveVariables(fname string) { // save symbol tables
+	m.log.Printf("Saving symbol tables %v", fname)
+	symbols := symbolFile{Version: symbolsVersion, Files: m.srcfiles, Variables: []symVar{}, Assignments: []symAssign{}}
+	for _, v := range m.varcoll {
+		symbols.Variables = append(symbols.Variables, symVar{v.Parent, v.Name, v.Typ, v.Val, v.Loc})
+	}
+	for _, a := range m.asscoll {
+		symbols.Assignments = append(symbols.Assignments, symAssign{a.Parent, a.Line, a.Off})
+	}
+	var symJson bytes.Buffer
+	enc := json.NewEncoder(&symJson)
+	enc.SetEscapeHTML(false) // <EMPTY> as it is
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(symbols); err != nil {
+		m.log.Print(err)
+	} else if err := ioutil.WriteFile(fname, symJson.Bytes(), 0644); err != nil {
+		m.log.Panic(err)
+	}
+}
+
+func (m *Machine) LoadVariables(fname string) error { // load symbol tables
+	m.log.Printf("Loading symbol tables %v", fname)
+	symJson, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	var symbols symbolFile
+	if bytes.HasPrefix(bytes.TrimSpace(symJson), []byte("[")) {
+		return fmt.Errorf("%v: symbol tables without a version, of an older dap, compile the source again", fname)
+	} else if err := json.Unmarshal(symJson, &symbols); err != nil {
+		return fmt.Errorf("%v: %v, compile the source again", fname, err)
+	}
+	if symbols.Version != symbolsVersion {
+		return fmt.Errorf("%v: symbol tables of version %v, this dap loads version %v, compile the source again", fname, symbols.Version, symbolsVersion)
+	}
+	m.varcoll = []nameattr{}
+	for _, v := range symbols.Variables {
+		m.varcoll = append(m.varcoll, nameattr{v.Parent, v.Name, v.Type, v.Value, v.Offset})
+	}
+	m.asscoll = []assgattr{}
+	for _, a := range symbols.Assignments {
+		m.asscoll = append(m.asscoll, assgattr{a.Parent, a.Line, a.Offset})
+	}
+	if len(symbols.Files) > 0 {
+		m.srcfiles = symbols.Files
 	}
+	return nil
 }
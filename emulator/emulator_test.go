@@ -0,0 +1,54 @@
+package emulator
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"testing"
+	"dap/diag"
+)
+
+func TestWordArithmetic(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	m := New(logger, diag.NewList(logger))
+	for _, c := range []struct {
+		word int
+		op   string
+		a, b int
+		want int
+		ovf  bool
+	}{
+		{8, "add", 100, 27, 127, false},
+		{8, "add", 100, 28, -128, true},
+		{8, "sub", -100, 28, -128, false},
+		{8, "sub", -100, 30, 126, true},
+		{8, "mul", 100, 100, 16, true},
+		{8, "div", -128, -1, -128, true},
+		{8, "div", -128, 2, -64, false},
+		{8, "neg", -128, 0, -128, true},
+		{16, "mul", 256, 128, -32768, true},
+		{64, "add", math.MaxInt64, 1, math.MinInt64, true},
+		{64, "mul", math.MinInt64, -1, math.MinInt64, true},
+		{64, "div", math.MinInt64, -1, math.MinInt64, true},
+		{64, "div", math.MinInt64, 1, math.MinInt64, false},
+	} {
+		m.WordSize = c.word
+		var got int
+		var ovf bool
+		switch c.op {
+		case "add":
+			got, ovf = m.Add(c.a, c.b)
+		case "sub":
+			got, ovf = m.Sub(c.a, c.b)
+		case "mul":
+			got, ovf = m.Mul(c.a, c.b)
+		case "div":
+			got, ovf = m.Div(c.a, c.b)
+		case "neg":
+			got, ovf = m.Neg(c.a)
+		}
+		if got != c.want || ovf != c.ovf {
+			t.Errorf("%v-bit %v %v %v = %v, %v; want %v, %v", c.word, c.op, c.a, c.b, got, ovf, c.want, c.ovf)
+		}
+	}
+}
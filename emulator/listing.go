@@ -0,0 +1,175 @@
+package emulator
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* a listing of the program, each source line followed by the codes
+   generated for it, at the addresses the assembler gives them, as
+   numbers and as symbols, a label pushed is given with its address,
+   an offset pushed for COPY or STORE with the variable there
+*/
+
+// how many numbers an s4041 instruction takes, as asm assembles it
+func codeSize(ins string) int {
+	switch ins {
+	case "LABEL":
+		return 0
+	case "LINE", "LVAR":
+		return 3
+	case "GVAR", "FILE", "PUSH":
+		return 2
+	}
+	return 1
+}
+
+func (m *Machine) SaveListing(fname string) { // save source and codes side by side
+	m.log.Printf("Saving listing %v", fname)
+	if err := ioutil.WriteFile(fname, m.listing(), 0644); err != nil {
+		m.log.Panic(err)
+	}
+}
+
+func (m *Machine) listing() []byte {
+	var buf bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		buf.WriteString(strings.TrimRight(fmt.Sprintf(format, args...), " "))
+		buf.WriteString("\n")
+	}
+	main := "the symbolic codes"
+	if m.srcfiles[0] != "" {
+		main = filepath.Base(m.srcfiles[0])
+	}
+	line("; Listing of %v, %v-bit words, %v codes", main, m.WordSize, len(m.prog))
+
+	globals := map[int]string{}
+	vars, consts := []nameattr{}, []nameattr{}
+	for _, v := range m.varcoll {
+		if v.Loc == 0 {
+			consts = append(consts, v)
+			continue
+		}
+		vars = append(vars, v)
+		if v.Parent == "" {
+			globals[v.Loc] = v.Name
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		if vars[i].Parent != vars[j].Parent {
+			return vars[i].Parent < vars[j].Parent
+		}
+		return vars[i].Loc < vars[j].Loc
+	})
+	sort.Slice(consts, func(i, j int) bool { return consts[i].Name < consts[j].Name })
+	if len(vars) > 0 {
+		line(";")
+		line("; variables, by offset")
+		for _, v := range vars {
+			line(";   %4d  %-16v %v", v.Loc, qualified(v), v.Typ)
+		}
+	}
+	if len(consts) > 0 {
+		line(";")
+		line("; constants")
+		for _, v := range consts {
+			val := v.Val
+			if val == EMPTY {
+				val = "computed at run time"
+			}
+			line(";         %-16v %v = %v", qualified(v), v.Typ, val)
+		}
+	}
+
+	sources := make([][]string, len(m.srcfiles))
+	for i, fname := range m.srcfiles {
+		if fname == "" {
+			continue
+		}
+		if src, err := m.source(i, fname); err != nil {
+			m.log.Print(err)
+		} else {
+			sources[i] = strings.Split(strings.TrimRight(src, "\n"), "\n")
+		}
+	}
+	listed := make([]int, len(m.srcfiles)) // the lines of each file listed so far
+	file := -1
+	through := func(last int) { // the source lines up to last
+		if file < 0 || file >= len(sources) {
+			return
+		}
+		for ; listed[file] < last && listed[file] < len(sources[file]); listed[file]++ {
+			line("%5d | %v", listed[file]+1, strings.TrimRight(sources[file][listed[file]], "\r"))
+		}
+	}
+	setFile := func(f int) {
+		file = f
+		name := "(source not known)"
+		if f < len(m.srcfiles) && m.srcfiles[f] != "" {
+			name = m.srcfiles[f]
+		}
+		line(";")
+		line("; %v", name)
+	}
+
+	setFile(0)
+	ip := 0
+	for i, cmd := range m.s4041 {
+		fields := strings.Fields(cmd)
+		if len(fields) == 0 {
+			continue
+		}
+		ins := fields[0]
+		switch ins {
+		case "FILE":
+			if f, err := strconv.Atoi(fields[1]); err == nil && f != file {
+				setFile(f)
+			}
+		case "LINE":
+			if l, err := strconv.Atoi(fields[1]); err == nil {
+				through(l)
+			}
+		}
+		size := codeSize(ins)
+		if ins == "LABEL" {
+			line("        %04d  %v:", ip, fields[1])
+			continue
+		}
+		nums := []string{}
+		for a := ip; a < ip+size && a < len(m.prog); a++ {
+			nums = append(nums, strconv.Itoa(m.prog[a]))
+		}
+		note := ""
+		switch {
+		case ins == "PUSH" && strings.HasPrefix(fields[1], "@") && ip+1 < len(m.prog):
+			note = fmt.Sprintf("-> %04d", m.prog[ip+1])
+		case ins == "PUSH" && i+1 < len(m.s4041) && (m.s4041[i+1] == "COPY" || m.s4041[i+1] == "STORE"):
+			if loc, err := strconv.Atoi(fields[1]); err == nil && globals[loc] != "" {
+				note = globals[loc]
+			}
+		case ins == "PUSH" && i+1 < len(m.s4041) && m.s4041[i+1] == "CLAIM":
+			note = "variables"
+		}
+		if note != "" {
+			note = "; " + note
+		}
+		line("        %04d  %-14v %-16v %v", ip, strings.Join(nums, " "), cmd, note)
+		ip += size
+	}
+	through(math.MaxInt32) // what follows the end of the program
+	return buf.Bytes()
+}
+
+// a variable with the subprogram it belongs to
+func qualified(v nameattr) string {
+	if v.Parent == "" {
+		return v.Name
+	}
+	return v.Parent + ":" + v.Name
+}
@@ -0,0 +1,42 @@
+package emulator_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"dap/parser"
+)
+
+/* the listing of count.dap as count.lst has it, the test runs in
+   testdata/listing, where the sources are as the listing names them
+*/
+func TestListing(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join("..", "testdata", "listing")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+	compiler := parser.Compiler{SearchPath: []string{"lib"}, Log: ioutil.Discard}
+	prog, err := compiler.CompileFile("count.dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		prog.Machine.SaveListing("count.lst")
+		return
+	}
+	got := filepath.Join(t.TempDir(), "count.lst")
+	prog.Machine.SaveListing(got)
+	want, err := ioutil.ReadFile("count.lst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(got); !bytes.Equal(data, want) {
+		t.Errorf("the listing differs from count.lst\n%s", data)
+	}
+}
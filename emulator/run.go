@@ -0,0 +1,253 @@
+package emulator
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+/* a run of the program, the state its instructions share, Emulate,
+   Wemulate, and the Debugger each run one with their own input and
+   output
+
+   An input instruction asks in for its value, it waits, and runs
+   again, when in has none yet. An output instruction gives its value
+   to out. A run-time error is a diagnostic, the fault of the step,
+   the program may go on after it, but not after a trapped overflow.
+*/
+type run struct {
+	file, line, col int // where the program is, as the last FILE and LINE give it
+	frames          int // claimed, the program claims the first
+	empty           []bool
+	rnd             *rand.Rand
+
+	in  func(ins int) (int, bool) // a value for INPI, INPB, or INPC
+	out func(ins, w int)          // w of OUTI, OUTB, or OUTC
+
+	fault  string // of the last step, a run-time error or a wrap-around
+	failed bool   // the fault is an error
+	halted bool   // by a trapped overflow
+}
+
+// what a step did, as a run may show it
+type effect int
+
+const (
+	executed effect = iota // an instruction
+	lined                  // a LINE, a statement starts
+	filed                  // a FILE
+	stored                 // a STORE or an LSTOR of a value
+	waits                  // an input instruction has no value yet
+	exited                 // the EXIT, the program ended
+)
+
+// start the program over
+func (m *Machine) newRun(in func(ins int) (int, bool), out func(ins, w int)) *run {
+	m.stack = make(memory, memSIZE)
+	m.base, m.top, m.iP = 0, -1, 0
+	return &run{empty: make([]bool, memSIZE), rnd: rand.New(rand.NewSource(time.Now().UnixNano())), in: in, out: out}
+}
+
+// a run-time error of the instruction, the diagnostic may tell more than the fault
+func (m *Machine) fail(r *run, code, fault, more string) {
+	r.fault, r.failed = fault, true
+	m.diags.Add(m.at(r.file, r.line, r.col).Errorf(code, "%v%v", fault, more))
+}
+
+// one instruction, the value is the one stored
+func (m *Machine) exec(r *run) (effect, int) {
+	if m.maxtop < m.top {
+		m.maxtop = m.top
+	}
+	r.fault, r.failed = "", false
+	ovf := false
+	switch m.iR, m.iP = m.prog[m.iP], m.iP+1; m.iR {
+	case NOP, CMT:
+	case LINE:
+		r.line, r.col = m.prog[m.iP], m.prog[m.iP+1]
+		m.iP += 2
+		return lined, 0
+	case FILE:
+		r.file = m.prog[m.iP]
+		m.iP++
+		return filed, 0
+	case GVAR:
+		m.iP++
+	case LVAR:
+		m.iP += 2
+	case CLAIM:
+		spc := m.stack[m.top]
+		m.stack[m.top] = m.base
+		m.base = m.top
+		m.top += spc
+		for si := m.base + 1; si <= m.top; si++ {
+			m.stack[si] = r.rnd.Intn(7919) + 2
+			r.empty[si] = true
+		}
+		r.frames++
+	case FREE:
+		m.top = m.base
+		m.base = m.stack[m.top]
+		m.top--
+		r.frames--
+	case COPY:
+		if r.empty[m.top] {
+			m.fail(r, "E101", "Illegal access to uninitialized variable", fmt.Sprintf(", %v", m.stack[m.top]))
+		}
+		m.stack[m.top] = m.stack[m.stack[m.top]]
+	case STORE:
+		w := m.stack[m.top-1]
+		m.stack[m.stack[m.top]] = w
+		r.empty[m.stack[m.top]] = false
+		m.top -= 2
+		return stored, w
+	case LCOPY:
+		if r.empty[m.top] {
+			m.fail(r, "E102", "Illegal access to uninitialized local variable", fmt.Sprintf(", %v", m.stack[m.top]))
+		}
+		m.stack[m.top] = m.stack[m.base+m.stack[m.top]]
+	case LSTOR:
+		w := m.stack[m.top-1]
+		m.stack[m.base+m.stack[m.top]] = w
+		r.empty[m.base+m.stack[m.top]] = false
+		m.top -= 2
+		return stored, w
+	case PUSH:
+		m.top++
+		m.stack[m.top] = m.prog[m.iP]
+		m.iP++
+	case POP:
+		m.top--
+	case DUP:
+		m.top++
+		m.stack[m.top] = m.stack[m.top-1]
+	case SWAP:
+		m.stack[m.top], m.stack[m.top-1] = m.stack[m.top-1], m.stack[m.top]
+	case NEG:
+		m.stack[m.top], ovf = m.Neg(m.stack[m.top])
+	case ADD:
+		m.stack[m.top-1], ovf = m.Add(m.stack[m.top-1], m.stack[m.top])
+		m.top--
+	case SUB:
+		m.stack[m.top-1], ovf = m.Sub(m.stack[m.top-1], m.stack[m.top])
+		m.top--
+	case MUL:
+		m.stack[m.top-1], ovf = m.Mul(m.stack[m.top-1], m.stack[m.top])
+		m.top--
+	case DIV:
+		if m.stack[m.top] == 0 {
+			m.fail(r, "E103", "Illegal division by zero", "")
+		} else {
+			m.stack[m.top-1], ovf = m.Div(m.stack[m.top-1], m.stack[m.top])
+		}
+		m.top--
+	case MOD:
+		if m.stack[m.top] == 0 {
+			m.fail(r, "E104", "Illegal modulo division by zero", "")
+		} else {
+			m.stack[m.top-1] = m.stack[m.top-1] % m.stack[m.top]
+		}
+		m.top--
+	case NOT:
+		m.stack[m.top] = tf[m.stack[m.top] == 0]
+	case OR:
+		m.stack[m.top-1] = tf[m.stack[m.top-1] != 0 || m.stack[m.top] != 0]
+		m.top--
+	case AND:
+		m.stack[m.top-1] = tf[m.stack[m.top-1] != 0 && m.stack[m.top] != 0]
+		m.top--
+	case LT:
+		m.stack[m.top-1] = tf[m.stack[m.top-1] < m.stack[m.top]]
+		m.top--
+	case LEQ:
+		m.stack[m.top-1] = tf[m.stack[m.top-1] <= m.stack[m.top]]
+		m.top--
+	case GT:
+		m.stack[m.top-1] = tf[m.stack[m.top-1] > m.stack[m.top]]
+		m.top--
+	case GEQ:
+		m.stack[m.top-1] = tf[m.stack[m.top-1] >= m.stack[m.top]]
+		m.top--
+	case EQ:
+		m.stack[m.top-1] = tf[m.stack[m.top-1] == m.stack[m.top]]
+		m.top--
+	case NEQ:
+		m.stack[m.top-1] = tf[m.stack[m.top-1] != m.stack[m.top]]
+		m.top--
+	case INPI, INPC, INPB:
+		w, ok := r.in(m.iR)
+		if !ok {
+			m.iP-- // again, when there is more input
+			return waits, 0
+		}
+		if m.iR == INPI && !m.InWord(w) {
+			m.overflow(r, fmt.Sprintf("Input %v overflows %v-bit word", w, m.WordSize), fmt.Sprintf("Input %v wrapped around %v-bit word", w, m.WordSize))
+			w = m.wrap(w)
+		}
+		m.top++
+		m.stack[m.top] = w
+	case OUTI, OUTC, OUTB:
+		r.out(m.iR, m.stack[m.top])
+		m.top--
+	case SAVEIP:
+		m.top++
+		m.stack[m.top] = m.iP
+	case COND:
+		if m.stack[m.top-1] != 0 {
+			m.iP = m.stack[m.top]
+		}
+		m.top -= 2
+	case NCOND:
+		if m.stack[m.top-1] == 0 {
+			m.iP = m.stack[m.top]
+		}
+		m.top -= 2
+	case CALL:
+		m.stack[m.top], m.iP = m.iP, m.stack[m.top]
+	case GOTO:
+		m.iP = m.stack[m.top]
+		m.top--
+	case EXIT:
+		m.iP--
+		return exited, 0
+	}
+	if ovf {
+		m.overflow(r, fmt.Sprintf("Integer overflow of %v-bit word", m.WordSize), fmt.Sprintf("Integer overflow, wrapped around %v-bit word", m.WordSize))
+	}
+	return executed, 0
+}
+
+// a value over the machine word, it stops the program when overflow traps
+func (m *Machine) overflow(r *run, trapped, wrapped string) {
+	if m.OverflowTrap {
+		m.fail(r, "E105", trapped, "")
+		r.halted = true
+		return
+	}
+	r.fault = wrapped
+	m.diags.Add(m.at(r.file, r.line, r.col).Warnf("E106", "%v", wrapped))
+}
+
+// the output as text, a line of characters ends before a number or a truth value
+type printer struct {
+	w        io.Writer
+	charline bool
+}
+
+func (p *printer) put(ins, w int) {
+	if ins == OUTC {
+		fmt.Fprintf(p.w, "%c", w)
+		p.charline = true
+		return
+	}
+	if p.charline {
+		p.charline = false
+		fmt.Fprintln(p.w)
+	}
+	if ins == OUTB {
+		fmt.Fprintln(p.w, w != 0)
+	} else {
+		fmt.Fprintln(p.w, w)
+	}
+}
@@ -0,0 +1,71 @@
+package emulator_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"dap/diag"
+	"dap/emulator"
+	"dap/parser"
+)
+
+var update = flag.Bool("update", false, "write symbols.json of testdata/symbols and count.lst of testdata/listing")
+
+/* the symbol tables of digits.dap as symbols.json has them, loaded
+   with its codes they are written again as they are, and the codes
+   go in a container as compiled, the test runs in testdata/symbols,
+   where the files are as the tables name them
+*/
+func TestSymbolTables(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join("..", "testdata", "symbols")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+	tmp := t.TempDir()
+	compiler := parser.Compiler{SearchPath: []string{"lib"}, Log: ioutil.Discard}
+	prog, err := compiler.CompileFile("digits.dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := prog.Machine
+	compiled.SaveSymbols(filepath.Join(tmp, "digits.s4041"))
+	compiled.SaveContainer(filepath.Join(tmp, "digits.b4041"), false)
+	if *update {
+		compiled.SaveVariables("symbols.json")
+		return
+	}
+	compiled.SaveVariables(filepath.Join(tmp, "digits.dapsym"))
+	want, err := ioutil.ReadFile("symbols.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(filepath.Join(tmp, "digits.dapsym")); !bytes.Equal(got, want) {
+		t.Errorf("the symbol tables differ from symbols.json\n%s", got)
+	}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	loaded := emulator.New(logger, diag.NewList(logger))
+	loaded.LoadSymbols(filepath.Join(tmp, "digits.s4041"))
+	loaded.GenCodes()
+	if err := loaded.LoadVariables("symbols.json"); err != nil {
+		t.Fatal(err)
+	}
+	loaded.SaveVariables(filepath.Join(tmp, "loaded.dapsym"))
+	if got, _ := ioutil.ReadFile(filepath.Join(tmp, "loaded.dapsym")); !bytes.Equal(got, want) {
+		t.Errorf("loaded and written again, the symbol tables differ from symbols.json\n%s", got)
+	}
+	loaded.SaveContainer(filepath.Join(tmp, "loaded.b4041"), false)
+	a, _ := ioutil.ReadFile(filepath.Join(tmp, "digits.b4041"))
+	b, _ := ioutil.ReadFile(filepath.Join(tmp, "loaded.b4041"))
+	if !bytes.Equal(a, b) {
+		t.Error("with the loaded symbol tables, the container differs from the compiled one")
+	}
+}
@@ -0,0 +1,110 @@
+package flowchart
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var dotShapes = map[Shape]string{
+	Terminal: `shape=box, style=rounded`,
+	Process:  `shape=box`,
+	Decision: `shape=diamond`,
+	InOut:    `shape=parallelogram`,
+}
+
+// an edge still to be drawn, from a node to the next one
+type pending struct {
+	from  int
+	label string
+}
+
+type dotWriter struct {
+	buf   bytes.Buffer
+	nodes int
+	yes   string
+	no    string
+}
+
+// the chart as a Graphviz digraph, the line of each shape is its xlabel
+func (c *Chart) WriteDOT(w io.Writer) error {
+	d := &dotWriter{yes: c.yes, no: c.no}
+	fmt.Fprintf(&d.buf, "digraph %v {\n", quote(c.Name))
+	fmt.Fprintf(&d.buf, "\tnode [fontname=\"Helvetica\", fontsize=11];\n")
+	fmt.Fprintf(&d.buf, "\tedge [fontname=\"Helvetica\", fontsize=9];\n")
+	start := d.node(c.start)
+	_, exits := d.blocks(c.body, []pending{{start, ""}})
+	d.connect(exits, d.node(c.end))
+	d.buf.WriteString("}\n")
+	_, err := w.Write(d.buf.Bytes())
+	return err
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (d *dotWriter) node(s step) int {
+	id := d.nodes
+	d.nodes++
+	fmt.Fprintf(&d.buf, "\tn%v [%v, label=%v, xlabel=\"%v\"];\n", id, dotShapes[s.shape], quote(s.text), s.line)
+	return id
+}
+
+func (d *dotWriter) connect(from []pending, to int) {
+	for _, e := range from {
+		if e.label == "" {
+			fmt.Fprintf(&d.buf, "\tn%v -> n%v;\n", e.from, to)
+		} else {
+			fmt.Fprintf(&d.buf, "\tn%v -> n%v [label=%v];\n", e.from, to, quote(e.label))
+		}
+	}
+}
+
+/* the nodes of list, entered along in,
+   the first node is -1 if list is empty
+*/
+func (d *dotWriter) blocks(list []block, in []pending) (first int, out []pending) {
+	first = -1
+	for _, b := range list {
+		var entry int
+		entry, in = d.block(b, in)
+		if first < 0 {
+			first = entry
+		}
+	}
+	return first, in
+}
+
+func (d *dotWriter) block(b block, in []pending) (entry int, out []pending) {
+	switch b := b.(type) {
+	case *step:
+		entry = d.node(*b)
+		d.connect(in, entry)
+		return entry, []pending{{entry, ""}}
+	case *branch:
+		entry = d.node(b.cond)
+		d.connect(in, entry)
+		_, yes := d.blocks(b.yes, []pending{{entry, d.yes}})
+		_, no := d.blocks(b.no, []pending{{entry, d.no}})
+		return entry, append(yes, no...)
+	case *loop:
+		if !b.repeat {
+			entry = d.node(b.cond)
+			d.connect(in, entry)
+			_, body := d.blocks(b.body, []pending{{entry, d.yes}})
+			d.connect(body, entry)
+			return entry, []pending{{entry, d.no}}
+		}
+		first, body := d.blocks(b.body, in)
+		cond := d.node(b.cond)
+		if first < 0 {
+			first = cond
+		}
+		d.connect(body, cond)
+		d.connect([]pending{{cond, d.no}}, first)
+		return first, []pending{{cond, d.yes}}
+	}
+	return -1, in
+}
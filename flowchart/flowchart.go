@@ -0,0 +1,137 @@
+package flowchart
+
+import (
+	"fmt"
+	"dap/ast"
+)
+
+/* flowchart of the algorithm of a program, as drawn in textbooks
+
+   The chart keeps the structure of the program, so that it can be laid out
+   without a graph layout tool, elif and case become nested decisions.
+   Each shape is annotated with the line of its statement.
+*/
+
+type Shape int
+
+const (
+	Terminal Shape = iota // start and end, a rounded box
+	Process               // assignment, a box
+	Decision              // condition, a diamond
+	InOut                 // input and output, a parallelogram
+)
+
+type step struct {
+	shape Shape
+	text  string
+	line  int
+}
+
+// a block is a *step, *branch, or *loop
+type block interface{}
+
+type branch struct {
+	cond    step
+	yes, no []block
+}
+
+// the body of a while follows a true condition,
+// a repeat runs its body first and ends on a true condition
+type loop struct {
+	cond   step
+	body   []block
+	repeat bool
+}
+
+type Chart struct {
+	Name       string
+	start, end step
+	body       []block
+	yes, no    string // labels of the decisions
+}
+
+// the chart of prog, spell gives how the profile writes a token type
+func New(prog *ast.Program, spell func(typ string) string) *Chart {
+	b := builder{spell}
+	return &Chart{
+		Name:  prog.Name,
+		start: step{Terminal, spell("$PROGRAM") + " " + prog.Name, prog.Line},
+		end:   step{Terminal, spell("$ENDPROG"), prog.End.Line},
+		body:  b.stmts(prog.Body),
+		yes:   spell("$TRUE"),
+		no:    spell("$FALSE"),
+	}
+}
+
+type builder struct {
+	spell func(string) string
+}
+
+func (b builder) expr(x ast.Expr) string {
+	return ast.ExprString(x, b.spell)
+}
+
+// x as an operand of =, within parentheses if it has an operator of its own
+func (b builder) operand(x ast.Expr) string {
+	if _, ok := x.(*ast.Binary); ok {
+		return "(" + b.expr(x) + ")"
+	}
+	return b.expr(x)
+}
+
+func (b builder) stmts(list []ast.Stmt) []block {
+	blocks := []block{}
+	for _, s := range list {
+		blocks = append(blocks, b.stmt(s)...)
+	}
+	return blocks
+}
+
+// mostly one block, a case without labels is only its otherwise
+func (b builder) stmt(s ast.Stmt) []block {
+	switch s := s.(type) {
+	case *ast.Assign:
+		return []block{&step{Process, ast.StmtString(s, b.spell), s.Line}}
+	case *ast.Input, *ast.Output:
+		return []block{&step{InOut, ast.StmtString(s, b.spell), s.Position().Line}}
+	case *ast.While:
+		return []block{&loop{cond: step{Decision, b.expr(s.Cond), s.Line}, body: b.stmts(s.Body)}}
+	case *ast.Repeat:
+		return []block{&loop{cond: step{Decision, b.expr(s.Cond), s.Until.Line}, body: b.stmts(s.Body), repeat: true}}
+	case *ast.If:
+		top := &branch{cond: step{Decision, b.expr(s.Cond), s.Line}, yes: b.stmts(s.Then), no: []block{}}
+		last := top
+		for _, e := range s.Elifs {
+			next := &branch{cond: step{Decision, b.expr(e.Cond), e.Line}, yes: b.stmts(e.Body), no: []block{}}
+			last.no = []block{next}
+			last = next
+		}
+		if s.Else != nil {
+			last.no = b.stmts(s.Else.Body)
+		}
+		return []block{top}
+	case *ast.Case:
+		x := b.operand(s.X)
+		var top, last *branch
+		for _, l := range s.Labels {
+			next := &branch{cond: step{Decision, fmt.Sprintf("%v %v %v", x, b.spell("$EQ"), b.operand(l.X)), l.Line}, yes: b.stmts(l.Body), no: []block{}}
+			if top == nil {
+				top = next
+			} else {
+				last.no = []block{next}
+			}
+			last = next
+		}
+		if top == nil {
+			if s.Default != nil {
+				return b.stmts(s.Default.Body)
+			}
+			return nil
+		}
+		if s.Default != nil {
+			last.no = b.stmts(s.Default.Body)
+		}
+		return []block{top}
+	}
+	return nil
+}
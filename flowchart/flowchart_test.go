@@ -0,0 +1,53 @@
+package flowchart_test
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/flowchart"
+	"dap/parser"
+)
+
+var update = flag.Bool("update", false, "write the .dot and .svg files of testdata/flowchart")
+
+// the chart of each program of testdata/flowchart, as its .dot and its .svg draw it
+func TestCharts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", "flowchart", "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/flowchart: %v", err)
+	}
+	for _, f := range files {
+		compiler := parser.Compiler{Log: ioutil.Discard}
+		prog, err := compiler.CompileFile(f)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		chart := flowchart.New(prog.Tree, prog.Spelling)
+		for ext, write := range map[string]func(w io.Writer) error{".dot": chart.WriteDOT, ".svg": chart.WriteSVG} {
+			var got bytes.Buffer
+			if err := write(&got); err != nil {
+				t.Fatal(err)
+			}
+			golden := strings.TrimSuffix(f, ".dap") + ext
+			if *update {
+				if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Errorf("%v: %v", f, err)
+				continue
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("%v: the chart differs from %v\nwant:\n%s\ngot:\n%s", f, filepath.Base(golden), want, got.Bytes())
+			}
+		}
+	}
+}
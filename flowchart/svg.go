@@ -0,0 +1,246 @@
+package flowchart
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+/* the chart drawn as SVG, laid out along its structure
+
+   Every block has an axis, the flow enters it at the top of the axis
+   and leaves at the bottom. A decision puts its true branch on the left
+   and its false branch on the right, a loop goes back along a lane
+   on its left, the false exit of a while goes down along its right.
+*/
+
+const (
+	charW    = 7.2 // of the 12px monospace font
+	pad      = 12  // between the text and its shape
+	boxH     = 32
+	diamondH = 52
+	skew     = 10 // of a parallelogram
+	lineW    = 28 // left of a shape, for its line number
+	gap      = 28 // between the blocks of a sequence
+	lane     = 12 // for the lines around a block
+)
+
+type layout struct {
+	w, h, axis float64
+	draw       func(s *svgWriter, x, y float64) // x, y is the top left
+}
+
+type svgWriter struct {
+	buf     bytes.Buffer
+	yes, no string
+}
+
+func (c *Chart) WriteSVG(w io.Writer) error {
+	s := &svgWriter{yes: c.yes, no: c.no}
+	list := append(append([]block{&c.start}, c.body...), &c.end)
+	l := s.seq(list)
+	const margin = 16
+	fmt.Fprintf(&s.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f">`+"\n",
+		l.w+2*margin, l.h+2*margin, l.w+2*margin, l.h+2*margin)
+	s.buf.WriteString(`<title>` + html.EscapeString(c.Name) + "</title>\n")
+	s.buf.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="7" markerHeight="7" orient="auto"><path d="M0,0 L10,5 L0,10 z"/></marker></defs>` + "\n")
+	s.buf.WriteString(`<style>text{font-family:monospace;font-size:12px} .ln{fill:#888;font-size:10px} .lb{font-size:10px} polyline{stroke:#000;fill:none} rect,polygon{stroke:#000;fill:#fff}</style>` + "\n")
+	l.draw(s, margin, margin)
+	s.buf.WriteString("</svg>\n")
+	_, err := w.Write(s.buf.Bytes())
+	return err
+}
+
+func textWidth(text string) float64 {
+	return float64(utf8.RuneCountInString(text)) * charW
+}
+
+// a polyline through x, y pairs, with an arrow head at its end
+func (s *svgWriter) line(arrow bool, xy ...float64) {
+	points := []string{}
+	for i := 0; i+1 < len(xy); i += 2 {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", xy[i], xy[i+1]))
+	}
+	marker := ""
+	if arrow {
+		marker = ` marker-end="url(#arrow)"`
+	}
+	fmt.Fprintf(&s.buf, "<polyline points=\"%v\"%v/>\n", strings.Join(points, " "), marker)
+}
+
+func (s *svgWriter) text(x, y float64, anchor, class, text string) {
+	if class != "" {
+		class = ` class="` + class + `"`
+	}
+	fmt.Fprintf(&s.buf, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"%v\" dominant-baseline=\"middle\"%v>%v</text>\n",
+		x, y, anchor, class, html.EscapeString(text))
+}
+
+// a shape with its line number on the left
+func (s *svgWriter) step(st step) layout {
+	sw, h := textWidth(st.text)+2*pad, float64(boxH)
+	switch st.shape {
+	case Decision:
+		sw, h = textWidth(st.text)+4*pad, diamondH
+	case InOut:
+		sw += 2 * skew
+	}
+	return layout{w: lineW + sw, h: h, axis: lineW + sw/2, draw: func(s *svgWriter, x, y float64) {
+		left, right, mid := x+lineW, x+lineW+sw, y+h/2
+		switch st.shape {
+		case Terminal:
+			fmt.Fprintf(&s.buf, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" rx=\"%.1f\"/>\n", left, y, sw, h, h/2)
+		case Process:
+			fmt.Fprintf(&s.buf, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\"/>\n", left, y, sw, h)
+		case Decision:
+			fmt.Fprintf(&s.buf, "<polygon points=\"%.1f,%.1f %.1f,%.1f %.1f,%.1f %.1f,%.1f\"/>\n",
+				left+sw/2, y, right, mid, left+sw/2, y+h, left, mid)
+		case InOut:
+			fmt.Fprintf(&s.buf, "<polygon points=\"%.1f,%.1f %.1f,%.1f %.1f,%.1f %.1f,%.1f\"/>\n",
+				left+skew, y, right, y, right-skew, y+h, left, y+h)
+		}
+		s.text(left+sw/2, mid, "middle", "", st.text)
+		s.text(left-4, mid, "end", "ln", fmt.Sprint(st.line))
+	}}
+}
+
+func (s *svgWriter) block(b block) layout {
+	switch b := b.(type) {
+	case *step:
+		return s.step(*b)
+	case *branch:
+		return s.branch(b)
+	case *loop:
+		if b.repeat {
+			return s.repeat(b)
+		}
+		return s.while(b)
+	}
+	return layout{draw: func(*svgWriter, float64, float64) {}}
+}
+
+// the blocks below each other, joined by arrows
+func (s *svgWriter) seq(list []block) layout {
+	items := []layout{}
+	l := layout{}
+	right := 0.0
+	for _, b := range list {
+		it := s.block(b)
+		items = append(items, it)
+		l.axis = math.Max(l.axis, it.axis)
+		right = math.Max(right, it.w-it.axis)
+		l.h += it.h
+	}
+	if len(items) > 1 {
+		l.h += gap * float64(len(items)-1)
+	}
+	l.w = l.axis + right
+	l.draw = func(s *svgWriter, x, y float64) {
+		for i, it := range items {
+			it.draw(s, x+l.axis-it.axis, y)
+			y += it.h
+			if i < len(items)-1 {
+				s.line(true, x+l.axis, y, x+l.axis, y+gap)
+				y += gap
+			}
+		}
+	}
+	return l
+}
+
+func (s *svgWriter) branch(b *branch) layout {
+	d, yes, no := s.step(b.cond), s.seq(b.yes), s.seq(b.no)
+	sw := d.w - lineW
+	yesAxis := yes.axis
+	noLeft := yes.w + 2*lane
+	if need := yesAxis + sw + 2*lane - (noLeft + no.axis); need > 0 {
+		noLeft += need
+	}
+	noAxis := noLeft + no.axis
+	a := (yesAxis + noAxis) / 2
+	shift := math.Max(0, d.axis-a)
+	yesAxis, noLeft, noAxis, a = yesAxis+shift, noLeft+shift, noAxis+shift, a+shift
+	top := diamondH + float64(gap)
+	join := top + math.Max(yes.h, no.h) + gap/2
+	l := layout{w: math.Max(math.Max(noLeft+no.w, noAxis), a+sw/2), h: join, axis: a}
+	l.draw = func(s *svgWriter, x, y float64) {
+		d.draw(s, x+a-d.axis, y)
+		mid := y + diamondH/2
+		column := func(col layout, left, axis, vertex float64, label string) {
+			s.text(vertex, mid-8, "middle", "lb", label)
+			if col.drawn() {
+				s.line(true, vertex, mid, x+axis, mid, x+axis, y+top)
+				col.draw(s, x+left, y+top)
+				s.line(false, x+axis, y+top+col.h, x+axis, y+join, x+a, y+join)
+			} else {
+				s.line(false, vertex, mid, x+axis, mid, x+axis, y+join, x+a, y+join)
+			}
+		}
+		column(yes, shift, yesAxis, x+a-sw/2, s.yes)
+		column(no, noLeft, noAxis, x+a+sw/2, s.no)
+	}
+	return l
+}
+
+// a block has something to draw
+func (l layout) drawn() bool {
+	return l.w > 0 || l.h > 0
+}
+
+func (s *svgWriter) while(b *loop) layout {
+	d, body := s.step(b.cond), s.seq(b.body)
+	sw := d.w - lineW
+	a := 2*lane + math.Max(d.axis, body.axis)
+	t := gap / 2.0
+	bodyTop := t + diamondH + gap
+	bodyBot := bodyTop + body.h
+	back := bodyBot + gap/2
+	exit := math.Max(a+sw/2, a-body.axis+body.w) + 2*lane
+	l := layout{w: exit + lane, h: bodyBot + gap, axis: a}
+	l.draw = func(s *svgWriter, x, y float64) {
+		s.line(false, x+a, y, x+a, y+t)
+		d.draw(s, x+a-d.axis, y+t)
+		s.text(x+a+6, y+t+diamondH+8, "start", "lb", s.yes)
+		from := y + t + diamondH
+		if body.drawn() {
+			s.line(true, x+a, from, x+a, y+bodyTop)
+			body.draw(s, x+a-body.axis, y+bodyTop)
+			from = y + bodyBot
+		}
+		s.line(true, x+a, from, x+a, y+back, x+lane, y+back, x+lane, y+t/2, x+a, y+t/2)
+		mid := y + t + diamondH/2
+		s.text(x+a+sw/2+6, mid-8, "start", "lb", s.no)
+		s.line(false, x+a+sw/2, mid, x+exit, mid, x+exit, y+l.h, x+a, y+l.h)
+	}
+	return l
+}
+
+func (s *svgWriter) repeat(b *loop) layout {
+	d, body := s.step(b.cond), s.seq(b.body)
+	sw := d.w - lineW
+	a := 2*lane + math.Max(d.axis, body.axis)
+	t := gap / 2.0
+	bodyBot := t + body.h
+	cond := bodyBot
+	if body.drawn() {
+		cond += gap
+	}
+	l := layout{w: math.Max(a+sw/2, a-body.axis+body.w) + lane, h: cond + diamondH, axis: a}
+	l.draw = func(s *svgWriter, x, y float64) {
+		s.line(false, x+a, y, x+a, y+t)
+		if body.drawn() {
+			body.draw(s, x+a-body.axis, y+t)
+			s.line(true, x+a, y+bodyBot, x+a, y+cond)
+		}
+		d.draw(s, x+a-d.axis, y+cond)
+		mid := y + cond + diamondH/2
+		s.text(x+a+6, y+l.h+8, "start", "lb", s.yes)
+		s.text(x+a-sw/2-6, mid-8, "end", "lb", s.no)
+		s.line(true, x+a-sw/2, mid, x+lane, mid, x+lane, y+t/2, x+a, y+t/2)
+	}
+	return l
+}
@@ -0,0 +1,226 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"dap/diag"
+	"dap/scanner"
+)
+
+/* canonical layout of a DAP source, the fixes of what the scanner complains of
+
+   Each line is indented by its block level, the levels come from the
+   indentation as the parser reads it. Each keyword, operator, comment,
+   string, and assignment is spelled one way, as chosen, or as it is
+   first spelled in the source. Comments are kept, blank lines too,
+   though not more than one in a row.
+*/
+
+type Options struct {
+	Indent   int    // spaces per level, 4 if not given
+	Keywords string // profile whose spellings are used, also named by the pragma
+	Comment  string // {, //, (*, or /*
+	Quote    string // ' " ‘ or “
+	Assign   string // <- := or ←
+}
+
+var (
+	Comments = []string{"{", "//", "(*", "/*"}
+	Quotes   = []string{"'", `"`, "‘", "“"}
+	Assigns  = []string{"<-", ":=", "←"}
+)
+
+type formatter struct {
+	opt   Options
+	spell map[string]string // token type: spelling
+	lines []line
+	buf   bytes.Buffer
+}
+
+// src formatted, name is the file it comes from, for a profile file of a pragma
+func Source(name string, src []byte, opt Options) ([]byte, error) {
+	if opt.Indent <= 0 {
+		opt.Indent = 4
+	}
+	lines, err := sourceLines(name, src)
+	if err != nil {
+		return nil, err
+	}
+	f := &formatter{opt: opt, spell: map[string]string{}, lines: lines}
+	if err := f.choose(name); err != nil {
+		return nil, err
+	}
+	if err := f.format(); err != nil {
+		return nil, fmt.Errorf("%v:%v", name, err)
+	}
+	return f.buf.Bytes(), nil
+}
+
+// the styles not given are those the source starts with
+func (f *formatter) choose(name string) error {
+	var target *scanner.Scanner
+	if f.opt.Keywords != "" {
+		target = scanner.New(name, bytes.NewReader(nil), nil, diag.NewList(nil))
+		if err := target.SelectProfile(f.opt.Keywords, false); err != nil {
+			return err
+		}
+	}
+	for _, ln := range f.lines {
+		for _, t := range ln.toks {
+			switch {
+			case t.kind == comment && f.opt.Comment == "":
+				f.opt.Comment = t.style
+			case t.kind == str && f.opt.Quote == "":
+				f.opt.Quote = t.style
+			case t.typ == "$ASSG" && f.opt.Assign == "":
+				f.opt.Assign = t.text
+			case t.typ != "" && f.spell[t.typ] == "":
+				f.spell[t.typ] = t.text
+				if target != nil {
+					if w := target.Spelling(t.typ); w != "" && t.typ != "$ASSG" {
+						f.spell[t.typ] = w
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// the level of each line, from the columns of the blocks it is within
+func (f *formatter) format() error {
+	cols := []int{0}
+	blank := false
+	for _, ln := range f.lines {
+		if len(ln.toks) == 0 {
+			blank = f.buf.Len() > 0
+			continue
+		}
+		level := 0
+		if ln.toks[0].kind == comment { // within the block, it does not open one
+			for level < len(cols)-1 && cols[level+1] <= ln.indent {
+				level++
+			}
+		} else {
+			for len(cols) > 1 && cols[len(cols)-1] > ln.indent {
+				cols = cols[:len(cols)-1]
+			}
+			if cols[len(cols)-1] < ln.indent {
+				cols = append(cols, ln.indent)
+			}
+			level = len(cols) - 1
+		}
+		if blank {
+			f.buf.WriteString("\n")
+			blank = false
+		}
+		if err := f.line(ln, strings.Repeat(" ", level*f.opt.Indent)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *formatter) line(ln line, indent string) error {
+	f.buf.WriteString(indent)
+	var prev, before *token
+	for i := range ln.toks {
+		t := &ln.toks[i]
+		if prev != nil {
+			if prev.kind == comment && f.opt.Comment == "//" {
+				f.buf.WriteString("\n" + indent)
+			} else if space(before, prev, t) {
+				f.buf.WriteString(" ")
+			}
+		}
+		switch t.kind {
+		case comment:
+			if err := f.comment(*t, indent); err != nil {
+				return fmt.Errorf("%v: %v", ln.no, err)
+			}
+		case str:
+			if err := f.str(*t); err != nil {
+				return fmt.Errorf("%v: %v", ln.no, err)
+			}
+		case word, op:
+			switch {
+			case t.typ == "$ASSG":
+				f.buf.WriteString(f.opt.Assign)
+			case t.typ != "":
+				f.buf.WriteString(f.spell[t.typ])
+			default:
+				f.buf.WriteString(t.text)
+			}
+		default:
+			f.buf.WriteString(t.text)
+		}
+		before, prev = prev, t
+	}
+	f.buf.WriteString("\n")
+	return nil
+}
+
+/* one space between tokens, none inside parentheses and brackets,
+   before a comma, or after a unary minus, unless a number follows it
+*/
+func space(before, prev, t *token) bool {
+	switch {
+	case prev.text == "(" && !strings.HasPrefix(t.text, "*"), prev.text == "[":
+		return false
+	case t.kind == op && (t.text == ")" || t.text == "]" || t.text == ","):
+		return false
+	case prev.text == "-" && t.kind != number && !strings.HasPrefix(t.text, "."):
+		unary := before == nil || (before.kind == op && before.text != ")" && before.text != "]") ||
+			(before.kind == word && before.typ != "" && before.typ != "$TRUE" && before.typ != "$FALSE")
+		return !unary
+	}
+	return true
+}
+
+func (f *formatter) comment(t token, indent string) error {
+	text := strings.TrimSpace(t.text)
+	style := f.opt.Comment
+	if name, isStrict, ok := scanner.ParsePragma(text); ok && f.opt.Keywords != "" && name != f.opt.Keywords {
+		text = "$keywords " + f.opt.Keywords
+		if isStrict {
+			text += " strict"
+		}
+	} else if style == t.style { // as it is written
+		f.buf.WriteString(style + t.text + scanner.Closers[style])
+		return nil
+	}
+	if style == "//" {
+		for i, l := range strings.Split(text, "\n") {
+			if i > 0 {
+				f.buf.WriteString("\n" + indent)
+			}
+			f.buf.WriteString(strings.TrimRight("// "+strings.TrimSpace(l), " "))
+		}
+		return nil
+	}
+	closer := scanner.Closers[style]
+	if strings.Contains(text, closer) || (closer != "}" && (strings.Contains(text, "*)") || strings.Contains(text, "*/"))) {
+		return fmt.Errorf("the comment has %v, it cannot be within %v %v", closer, style, closer)
+	}
+	if text == "" {
+		f.buf.WriteString(style + closer)
+	} else {
+		f.buf.WriteString(style + " " + text + " " + closer)
+	}
+	return nil
+}
+
+// the strings within ' and ‘ are raw, those within " and “ may have escapes
+func (f *formatter) str(t token) error {
+	quote, closer := f.opt.Quote, scanner.Closers[f.opt.Quote]
+	single := func(q string) bool { return q == "'" || q == "‘" }
+	if strings.Contains(t.text, closer) {
+		return fmt.Errorf("the string has %v, it cannot be within %v%v", closer, quote, closer)
+	}
+	if strings.Contains(t.text, `\`) && single(t.style) != single(quote) {
+		return fmt.Errorf("the string has \\, it would mean another string within %v%v", quote, closer)
+	}
+	f.buf.WriteString(quote + t.text + closer)
+	return nil
+}
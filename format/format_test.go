@@ -0,0 +1,58 @@
+package format_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/format"
+)
+
+var update = flag.Bool("update", false, "write the .want files of testdata/fmt and testdata/translate")
+
+/* each source of testdata/fmt as its .want lays it out, and as its
+   .styles.want with the styles chosen, formatting a .want again does
+   not change it
+*/
+func TestSource(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", "fmt", "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no sources in testdata/fmt: %v", err)
+	}
+	styles := format.Options{Indent: 2, Comment: "//", Quote: "'", Assign: "<-"}
+	for _, f := range files {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ext, opt := range map[string]format.Options{".want": {}, ".styles.want": styles} {
+			golden := strings.TrimSuffix(f, ".dap") + ext
+			got, err := format.Source(f, src, opt)
+			if err != nil {
+				t.Errorf("%v: %v", f, err)
+				continue
+			}
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Errorf("%v: %v", f, err)
+				continue
+			}
+			if string(got) != string(want) {
+				t.Errorf("%v: the layout differs from %v\nwant:\n%s\ngot:\n%s", f, filepath.Base(golden), want, got)
+			}
+			again, err := format.Source(golden, want, opt)
+			if err != nil {
+				t.Errorf("%v: %v", golden, err)
+			} else if string(again) != string(want) {
+				t.Errorf("%v: formatting it again changes it\n%s", golden, again)
+			}
+		}
+	}
+}
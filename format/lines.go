@@ -0,0 +1,92 @@
+package format
+
+import (
+	"strings"
+	"unicode/utf8"
+	"dap/scanner"
+)
+
+/* the tokens of a source, as the scanner splits them with its comments,
+   and its lines with their indentation
+*/
+
+const (
+	word = iota // name or keyword
+	number
+	str
+	op
+	comment
+)
+
+type token struct {
+	kind  int
+	text  string // as written, a comment or a string without its delimiters
+	style string // how a comment or a string opens
+	typ   string // of an operator or a keyword
+	no    int    // line where it starts
+	start int    // bytes of the source, where it is
+	end   int
+}
+
+type line struct {
+	no     int // from 1
+	indent int // columns, a tab to the next multiple of 8
+	toks   []token
+}
+
+var kinds = map[string]int{"keyword": word, "name": word, "number": number, "string": str, "operator": op, "comment": comment}
+
+/* the lines of src, blank ones have no tokens,
+   a comment over several lines belongs to the line it starts on
+*/
+func sourceLines(name string, src []byte) ([]line, error) {
+	pieces, err := scanner.Pieces(name, src)
+	if err != nil {
+		return nil, err
+	}
+	lines := []line{{no: 1}}
+	at := 0
+	for _, p := range pieces {
+		if p.Kind == "space" {
+			for i, part := range strings.Split(p.Text, "\n") {
+				if i > 0 {
+					lines = append(lines, line{no: p.Line + i})
+				}
+				if ln := &lines[len(lines)-1]; len(ln.toks) == 0 {
+					ln.indent = indentation(part)
+				}
+			}
+		} else {
+			t := token{kind: kinds[p.Kind], text: p.Text, typ: p.Type, no: p.Line, start: at, end: at + len(p.Text)}
+			if t.kind == comment || t.kind == str {
+				t.text, t.style = p.Body, opening(p)
+			}
+			ln := &lines[len(lines)-1]
+			ln.toks = append(ln.toks, t)
+		}
+		at += len(p.Text)
+	}
+	return lines, nil
+}
+
+// the columns of the spaces a line starts with
+func indentation(text string) int {
+	n := 0
+	for i := 0; i < len(text) && (text[i] == ' ' || text[i] == '\t'); i++ {
+		if text[i] == ' ' {
+			n++
+		} else {
+			n = (n + scanner.TAB) / scanner.TAB * scanner.TAB
+		}
+	}
+	return n
+}
+
+// how a comment or a string opens
+func opening(p scanner.Piece) string {
+	_, size := utf8.DecodeRuneInString(p.Text)
+	if p.Kind == "comment" && p.Text[0] != '{' {
+		size = 2
+	}
+	return p.Text[:size]
+}
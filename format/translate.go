@@ -0,0 +1,73 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+	"dap/diag"
+	"dap/scanner"
+)
+
+/* the keywords of a source in another profile, everything else is kept
+   as it is written, names, comments, strings, and the layout
+
+   T001 a name is a keyword of the profile, the program would not compile,
+        reported where the name is first found
+   T002 the profile has no spelling of a keyword, it is kept
+
+   The keywords of every builtin profile are reserved in any source (P218),
+   so only the words of a .json profile can collide with a name.
+*/
+func Translate(name string, src []byte, to string, diags *diag.List) ([]byte, error) {
+	target := scanner.New(name, bytes.NewReader(nil), nil, diag.NewList(nil))
+	if err := target.SelectProfile(to, false); err != nil {
+		return nil, err
+	}
+	keywords := map[string]bool{}
+	for _, w := range target.Keywords() {
+		keywords[w] = true
+	}
+	lines, err := sourceLines(name, src)
+	if err != nil {
+		return nil, err
+	}
+	text := string(src)
+	at := func(t token) diag.Diagnostic {
+		lineStart := strings.LastIndexByte(text[:t.start], '\n') + 1
+		col := utf8.RuneCountInString(text[lineStart:t.start])
+		return diag.At(name, t.no, col, utf8.RuneCountInString(text[t.start:t.end]))
+	}
+	var out bytes.Buffer
+	last := 0
+	reported := map[string]bool{}
+	replace := func(start, end int, with string) {
+		out.WriteString(text[last:start])
+		out.WriteString(with)
+		last = end
+	}
+	for _, ln := range lines {
+		for _, t := range ln.toks {
+			switch {
+			case t.kind == word && t.typ != "":
+				if w := target.Spelling(t.typ); w == "" {
+					diags.Add(at(t).Warnf("T002", "Keyword %v has no spelling in %v, it is kept", t.text, to))
+				} else if w != t.text {
+					replace(t.start, t.end, w)
+				}
+			case t.kind == word:
+				if keywords[t.text] && !reported[t.text] {
+					reported[t.text] = true
+					diags.Add(at(t).Warnf("T001", "Name %v is a keyword in %v, rename it", t.text, to))
+				}
+			case t.kind == comment:
+				if profile, _, ok := scanner.ParsePragma(t.text); ok && profile != to {
+					i := t.start + strings.Index(text[t.start:t.end], "$keywords") + len("$keywords")
+					i += strings.Index(text[i:t.end], profile)
+					replace(i, i+len(profile), to)
+				}
+			}
+		}
+	}
+	out.WriteString(text[last:])
+	return out.Bytes(), nil
+}
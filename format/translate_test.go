@@ -0,0 +1,80 @@
+package format_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/diag"
+	"dap/format"
+)
+
+/* each source of testdata/translate in the profiles, as its
+   .<profile>.want and its diagnostics as .<profile>.json, a translation
+   without a T001 translates back to the source, the test runs in
+   testdata/translate, where the profile files are
+*/
+func TestTranslate(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join("..", "testdata", "translate")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+	files, err := filepath.Glob("*.dap")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no sources in testdata/translate: %v", err)
+	}
+	for _, f := range files {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, to := range []string{"indonesian", "profiles/kursus.json", "profiles/mini.json"} {
+			golden := strings.TrimSuffix(f, ".dap") + "." + strings.TrimSuffix(filepath.Base(to), ".json")
+			diags := diag.NewList(nil)
+			got, err := format.Translate(f, src, to, diags)
+			if err != nil {
+				t.Errorf("%v to %v: %v", f, to, err)
+				continue
+			}
+			reported, err := diags.JSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				if err := ioutil.WriteFile(golden+".want", got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(golden+".json", reported, 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			for _, c := range []struct {
+				ext  string
+				got  []byte
+				what string
+			}{{".want", got, "the translation"}, {".json", reported, "the diagnostics"}} {
+				if want, err := ioutil.ReadFile(golden + c.ext); err != nil {
+					t.Errorf("%v: %v", f, err)
+				} else if !bytes.Equal(c.got, want) {
+					t.Errorf("%v to %v: %v differ from %v\nwant:\n%s\ngot:\n%s", f, to, c.what, golden+c.ext, want, c.got)
+				}
+			}
+			if bytes.Contains(reported, []byte(`"T001"`)) {
+				continue
+			}
+			back, err := format.Translate(f, got, "english", diag.NewList(nil))
+			if err != nil {
+				t.Errorf("%v from %v: %v", f, to, err)
+			} else if !bytes.Equal(back, src) {
+				t.Errorf("%v: translated back from %v, it differs from the source\n%s", f, to, back)
+			}
+		}
+	}
+}
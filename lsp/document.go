@@ -0,0 +1,323 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+	"dap/ast"
+	"dap/diag"
+	em "dap/emulator"
+	"dap/parser"
+)
+
+/* an open source, compiled again on each change
+
+   The names are taken from the last tree parsed, so an editor still
+   finds them while a line is being typed. A name of a unit is
+   declared in the unit's file, as it is on the disk.
+*/
+
+type document struct {
+	uri, path string
+	version   int
+	lines     []string
+	diags     []diag.Diagnostic
+
+	tree     *ast.Program
+	spell    func(typ string) string
+	keywords []string
+	decls    map[string]*decl // by unit:name
+	order    []*decl          // as they are declared
+	refs     []ref            // the names written in this file
+}
+
+// a declared variable or constant
+type decl struct {
+	name, unit string
+	kind       string // variable or constant
+	typ        string // a token type
+	expr       string // of a constant, as it is written
+	val        string // of a constant, EMPTY when computed at run time
+	offset     int    // of a variable
+	pos        ast.Pos
+}
+
+// a name at pos, declared as key
+type ref struct {
+	pos ast.Pos
+	key string
+	n   int // characters
+}
+
+func key(sym ast.Symbol) string {
+	return sym.Unit + ":" + sym.Name
+}
+
+// the file of a file: URI, other URIs are their own name
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// a unit found with a relative -I is relative to the server's folder
+func pathURI(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// compile text, a failed compilation keeps the names found before
+func (d *document) update(c parser.Compiler, text string) {
+	d.lines = strings.Split(text, "\n")
+	prog, _ := compile(c, d.path, text)
+	d.diags = prog.Diagnostics.Items()
+	if prog.Spelling != nil {
+		d.spell, d.keywords = prog.Spelling, prog.Keywords
+	}
+	if prog.Tree != nil {
+		d.index(prog.Tree)
+	}
+}
+
+// a panic of the compiler is a diagnostic, the server carries on
+func compile(c parser.Compiler, path, text string) (prog *parser.Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			diags := diag.NewList(nil)
+			diags.Add(diag.At(path, 1, 0, 0).Errorf("S000", "%v", r))
+			prog, err = &parser.Program{Diagnostics: diags}, fmt.Errorf("%v", r)
+		}
+	}()
+	return c.Compile(path, strings.NewReader(text))
+}
+
+func (d *document) index(tree *ast.Program) {
+	d.tree, d.decls, d.order, d.refs = tree, map[string]*decl{}, nil, nil
+	for _, u := range tree.Units {
+		d.declare(u.Name, u.Decls)
+	}
+	d.declare("", tree.Decls)
+	for _, dc := range tree.Decls {
+		ast.Inspect(dc, d.use)
+	}
+	for _, s := range tree.Body {
+		ast.Inspect(s, d.use)
+	}
+}
+
+func (d *document) declare(unit string, list []ast.Decl) {
+	add := func(dc *decl, sym ast.Symbol) {
+		dc.unit = unit
+		d.decls[key(sym)] = dc
+		d.order = append(d.order, dc)
+		d.refer(dc.pos, key(sym), dc.name)
+	}
+	for _, dl := range list {
+		switch dl := dl.(type) {
+		case *ast.Const:
+			typ := dl.X.Type()
+			if typ == "$NUMBER" {
+				typ = "$INT"
+			}
+			add(&decl{name: dl.Name, kind: "constant", typ: typ, expr: ast.ExprString(dl.X, d.spell), val: dl.Sym.Val, pos: dl.NamePos}, dl.Sym)
+		case *ast.Var:
+			for _, id := range dl.Names {
+				if id.Sym.Typ != "" {
+					add(&decl{name: id.Name, kind: "variable", typ: dl.Type, offset: id.Sym.Loc, pos: id.Pos}, id.Sym)
+				}
+			}
+		}
+	}
+}
+
+func (d *document) refer(pos ast.Pos, key, name string) {
+	if pos.File == d.path {
+		d.refs = append(d.refs, ref{pos: pos, key: key, n: utf8.RuneCountInString(name)})
+	}
+}
+
+func (d *document) use(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.Ident:
+		d.refer(n.Pos, key(n.Sym), n.Name)
+	case *ast.Assign:
+		d.refer(n.Pos, key(n.Sym), n.Name)
+	case *ast.Var:
+		return false // declared already
+	}
+	return true
+}
+
+// the declaration of the name at p
+func (d *document) at(p Position) (*decl, *ref) {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return nil, nil
+	}
+	line, col := p.Line+1, runeCol(d.lines[p.Line], p.Character)
+	for i, r := range d.refs {
+		if r.pos.Line == line && r.pos.Col <= col && col <= r.pos.Col+r.n && d.decls[r.key] != nil {
+			return d.decls[r.key], &d.refs[i]
+		}
+	}
+	return nil, nil
+}
+
+/* the range of n characters at pos, in lines of its file, within them,
+   a position past the last line is at the end of it
+*/
+func (d *document) span(lines []string, pos ast.Pos, n int) Range {
+	line := pos.Line - 1
+	if line >= len(lines) {
+		line = len(lines) - 1
+		pos.Col, n = 1<<30, 0
+	}
+	if line < 0 {
+		line = 0
+	}
+	text := ""
+	if line < len(lines) {
+		text = lines[line]
+	}
+	return Range{Position{line, utf16Col(text, pos.Col)}, Position{line, utf16Col(text, pos.Col+n)}}
+}
+
+func (d *document) diagnostics() []Diagnostic {
+	list := []Diagnostic{}
+	for _, dg := range d.diags {
+		severity, msg := 1, dg.Message
+		if dg.Severity == diag.Warning {
+			severity = 2
+		}
+		r := Range{}
+		if dg.File == d.path || dg.File == "" {
+			r = d.span(d.lines, ast.Pos{Line: dg.Line, Col: dg.Col}, 0)
+			if dg.EndLine == dg.Line {
+				r.End = d.span(d.lines, ast.Pos{Line: dg.Line, Col: dg.EndCol}, 0).End
+			}
+		} else { // in a unit, shown at the start of the program
+			msg = fmt.Sprintf("%v:%v:%v: %v", filepath.Base(dg.File), dg.Line, dg.Col, msg)
+		}
+		list = append(list, Diagnostic{Range: r, Severity: severity, Code: dg.Code, Source: "dap", Message: msg})
+	}
+	return list
+}
+
+func (d *document) hover(p Position) *Hover {
+	dc, r := d.at(p)
+	if dc == nil {
+		return nil
+	}
+	var text, about string
+	typ := d.spell(dc.typ)
+	if dc.kind == "constant" {
+		text = fmt.Sprintf("%v %v = %v", d.spell("$CONST"), dc.name, dc.expr)
+		about = "constant, " + typ
+		if dc.val == em.EMPTY {
+			about += ", computed when the program starts"
+		} else if dc.val != dc.expr {
+			about += ", " + dc.val
+		}
+	} else {
+		text = fmt.Sprintf("%v : %v", dc.name, typ)
+		about = fmt.Sprintf("variable, %v, at offset %v", typ, dc.offset)
+	}
+	if dc.unit != "" {
+		about += ", of unit " + dc.unit
+	}
+	rg := d.span(d.lines, r.pos, r.n)
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: "```dap\n" + text + "\n```\n" + about}, Range: &rg}
+}
+
+func (d *document) definition(p Position, lines func(path string) []string) *Location {
+	dc, _ := d.at(p)
+	if dc == nil {
+		return nil
+	}
+	return &Location{URI: pathURI(dc.pos.File), Range: d.span(lines(dc.pos.File), dc.pos, utf8.RuneCountInString(dc.name))}
+}
+
+// the program, and the names it declares
+func (d *document) symbols() []DocumentSymbol {
+	if d.tree == nil || d.tree.Pos.File != d.path {
+		return []DocumentSymbol{}
+	}
+	prog := DocumentSymbol{Name: d.tree.Name, Kind: symbolModule}
+	last := len(d.lines) - 1
+	if d.tree.End.Line > 0 && d.tree.End.Line <= len(d.lines) {
+		last = d.tree.End.Line - 1
+	}
+	header := d.span(d.lines, ast.Pos{Line: d.tree.Pos.Line}, 1<<30) // the whole line
+	prog.Range = Range{header.Start, Position{last, utf16Col(d.lines[last], utf8.RuneCountInString(d.lines[last]))}}
+	prog.SelectionRange = header
+	for _, dc := range d.order {
+		if dc.unit != "" {
+			continue
+		}
+		kind := symbolVariable
+		if dc.kind == "constant" {
+			kind = symbolConstant
+		}
+		r := d.span(d.lines, dc.pos, utf8.RuneCountInString(dc.name))
+		prog.Children = append(prog.Children, DocumentSymbol{Name: dc.name, Detail: d.spell(dc.typ), Kind: kind, Range: r, SelectionRange: r})
+	}
+	return []DocumentSymbol{prog}
+}
+
+// the names the program may use, and the keywords of its profile
+func (d *document) completion() []CompletionItem {
+	items := []CompletionItem{}
+	for _, dc := range d.order {
+		kind := completionVariable
+		if dc.kind == "constant" {
+			kind = completionConstant
+		}
+		detail := d.spell(dc.typ) + " " + dc.kind
+		if dc.unit != "" {
+			detail += ", of unit " + dc.unit
+		}
+		items = append(items, CompletionItem{Label: dc.name, Kind: kind, Detail: detail})
+	}
+	for _, w := range d.keywords {
+		items = append(items, CompletionItem{Label: w, Kind: completionKeyword})
+	}
+	return items
+}
+
+// the column of the character at col, in UTF-16 code units, one past the line is at its end
+func utf16Col(line string, col int) int {
+	n := 0
+	for _, r := range line {
+		if col <= 0 {
+			break
+		}
+		if r >= 0x10000 {
+			n++
+		}
+		n++
+		col--
+	}
+	return n
+}
+
+// the character at ch, a column in UTF-16 code units
+func runeCol(line string, ch int) int {
+	n := 0
+	for _, r := range line {
+		if ch <= 0 {
+			break
+		}
+		if r >= 0x10000 {
+			ch--
+		}
+		ch--
+		n++
+	}
+	return n
+}
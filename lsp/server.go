@@ -0,0 +1,224 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"dap/format"
+	"dap/parser"
+)
+
+/* a Language Server Protocol server for DAP sources, over a pair of
+   streams, the editor sends the whole text of a source on each change
+
+   diagnostics       of the scanner, the parser, and the checks, on each change
+   hover             the type of a name, and whether it is a constant
+   definition        where a name is declared, in the program or in a unit
+   documentSymbol    the program and the names it declares
+   completion        the declared names, and the keywords of the profile
+   formatting        as dap fmt, in the style the source starts with
+*/
+
+type Server struct {
+	compiler    parser.Compiler
+	in          *bufio.Reader
+	out         io.Writer
+	log         *log.Logger
+	docs        map[string]*document // by URI
+	initialized bool
+	shutdown    bool
+}
+
+var ErrExit = errors.New("exit before shutdown")
+
+// a server of the sources c compiles, messages are logged to logger
+func NewServer(c parser.Compiler, in io.Reader, out io.Writer, logger *log.Logger) *Server {
+	c.Log = ioutil.Discard
+	return &Server{compiler: c, in: bufio.NewReader(in), out: out, log: logger, docs: map[string]*document{}}
+}
+
+/* serve until the editor exits, ErrExit if it did not shut the server
+   down first, the error of the input if it ends
+*/
+func (s *Server) Serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if msg == nil {
+			return err
+		}
+		if err != nil {
+			s.reply(msg, nil, &respError{parseError, err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrExit
+			}
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID != nil {
+			s.reply(msg, result, rerr)
+		}
+	}
+}
+
+func (s *Server) reply(msg *message, result interface{}, rerr *respError) {
+	resp := response{JSONRPC: "2.0", ID: msg.ID, Error: rerr}
+	if rerr == nil {
+		data, err := marshal(result)
+		if err != nil {
+			s.log.Print(err)
+			data = []byte("null")
+		}
+		raw := json.RawMessage(data)
+		resp.Result = &raw
+	}
+	if err := writeMessage(s.out, resp); err != nil {
+		s.log.Print(err)
+	}
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	if err := writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params}); err != nil {
+		s.log.Print(err)
+	}
+}
+
+func (s *Server) handle(msg *message) (interface{}, *respError) {
+	if !s.initialized && msg.Method != "initialize" {
+		if msg.ID == nil {
+			return nil, nil
+		}
+		return nil, &respError{serverNotInitialized, "initialize first"}
+	}
+	params := func(v interface{}) *respError {
+		if err := json.Unmarshal(msg.Params, v); err != nil {
+			return &respError{invalidParams, err.Error()}
+		}
+		return nil
+	}
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1, // the whole text
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"documentSymbolProvider":     true,
+				"completionProvider":         map[string]interface{}{},
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "dap"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		d := &document{uri: p.TextDocument.URI, path: uriPath(p.TextDocument.URI), version: p.TextDocument.Version}
+		s.docs[d.uri] = d
+		s.change(d, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		if d := s.docs[p.TextDocument.URI]; d != nil && len(p.ContentChanges) > 0 {
+			d.version = p.TextDocument.Version
+			s.change(d, p.ContentChanges[len(p.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		var p documentParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	case "textDocument/hover", "textDocument/definition":
+		var p positionParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return nil, nil
+		}
+		if msg.Method == "textDocument/hover" {
+			if h := d.hover(p.Position); h != nil {
+				return h, nil
+			}
+		} else if l := d.definition(p.Position, s.lines); l != nil {
+			return l, nil
+		}
+	case "textDocument/documentSymbol", "textDocument/completion":
+		var p documentParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return nil, nil
+		}
+		if msg.Method == "textDocument/documentSymbol" {
+			return d.symbols(), nil
+		}
+		return d.completion(), nil
+	case "textDocument/formatting":
+		var p formattingParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		if d := s.docs[p.TextDocument.URI]; d != nil {
+			return s.format(d, p.Options.TabSize)
+		}
+	default:
+		if msg.ID != nil {
+			return nil, &respError{methodNotFound, fmt.Sprintf("%v is not served", msg.Method)}
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) change(d *document, text string) {
+	d.update(s.compiler, text)
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: d.uri, Version: d.version, Diagnostics: d.diagnostics()})
+}
+
+// the lines of a file, as the editor has it if it is open
+func (s *Server) lines(path string) []string {
+	for _, d := range s.docs {
+		if d.path == path {
+			return d.lines
+		}
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(src), "\n")
+}
+
+// the whole text replaced, if formatting changes it
+func (s *Server) format(d *document, indent int) (interface{}, *respError) {
+	text := strings.Join(d.lines, "\n")
+	src, err := format.Source(d.path, []byte(text), format.Options{Indent: indent})
+	if err != nil {
+		return nil, &respError{requestFailed, err.Error()}
+	}
+	if string(src) == text {
+		return []TextEdit{}, nil
+	}
+	last := len(d.lines) - 1
+	end := Position{last, utf16Col(d.lines[last], len([]rune(d.lines[last])))}
+	return []TextEdit{{Range: Range{Position{0, 0}, end}, NewText: string(src)}}, nil
+}
@@ -0,0 +1,107 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"dap/lsp"
+	"dap/parser"
+)
+
+var folder = filepath.Join("..", "testdata", "lsp")
+
+// the answers of a server to the messages, one on each line, as it writes them
+func serve(t *testing.T, messages []string) []string {
+	var in, out bytes.Buffer
+	for _, m := range messages {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	compiler := parser.Compiler{WordSize: 64}
+	if err := lsp.NewServer(compiler, &in, &out, log.New(ioutil.Discard, "", 0)).Serve(); err != nil {
+		t.Fatal(err)
+	}
+	answers := []string{}
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			return answers
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		answers = append(answers, string(body))
+	}
+}
+
+// the session of testdata/lsp, each answer as in session.out
+func TestSession(t *testing.T) {
+	in, err := ioutil.ReadFile(filepath.Join(folder, "session.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join(folder, "session.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := serve(t, strings.Split(strings.TrimSpace(string(in)), "\n"))
+	if text := strings.Join(got, "\n") + "\n"; text != string(want) {
+		t.Errorf("the answers differ from session.out\nwant:\n%s\ngot:\n%v", want, text)
+	}
+}
+
+// a source ending early has its diagnostics within its lines
+func TestEndOfDocument(t *testing.T) {
+	for _, text := range []string{"", "program x", "program x\n", "program x\ndata\n"} {
+		open, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0", "method": "textDocument/didOpen",
+			"params": map[string]interface{}{"textDocument": map[string]interface{}{
+				"uri": "file:///tmp/dap/short.dap", "languageId": "dap", "version": 1, "text": text}},
+		})
+		answers := serve(t, []string{
+			`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"processId":null,"rootUri":null,"capabilities":{}}}`,
+			`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+			string(open),
+			`{"jsonrpc":"2.0","id":2,"method":"textDocument/documentSymbol","params":{"textDocument":{"uri":"file:///tmp/dap/short.dap"}}}`,
+			`{"jsonrpc":"2.0","id":3,"method":"shutdown"}`,
+			`{"jsonrpc":"2.0","method":"exit"}`,
+		})
+		lines := strings.Split(text, "\n")
+		var published struct {
+			Params struct {
+				Diagnostics []struct {
+					Range struct {
+						Start, End struct{ Line, Character int }
+					}
+					Message string
+				}
+			}
+		}
+		if len(answers) < 2 || json.Unmarshal([]byte(answers[1]), &published) != nil || len(published.Params.Diagnostics) == 0 {
+			t.Fatalf("%q: no diagnostics published in %v", text, answers)
+		}
+		for _, d := range published.Params.Diagnostics {
+			for _, p := range []struct{ Line, Character int }{d.Range.Start, d.Range.End} {
+				if p.Line < 0 || p.Line >= len(lines) || p.Character < 0 || p.Character > len(lines[p.Line]) {
+					t.Errorf("%q: %v at %v:%v, beyond the source", text, d.Message, p.Line, p.Character)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"dap/ast"
+	"dap/diag"
+)
+
+/* measures of the algorithm of a program, for grading rubrics
+
+   DAP has no subprograms yet, the algorithm of the program is the one
+   routine measured. The cyclomatic complexity is one more than the
+   decisions, an if, an elif, a label of a case, a while, and a repeat
+   each take one. The nesting of a statement is its block level, the
+   algorithm is level 1. Halstead counts the operators, the keywords of
+   the statements, the assignment, parentheses, and the operators of the
+   expressions, and the operands, the names and the literals.
+
+   A limit exceeded is a warning, each has its own code
+
+   M001 cyclomatic complexity above the limit
+   M002 nesting above the limit
+   M003 more statements than the limit
+*/
+
+type Halstead struct {
+	Operators         int     `json:"operators"` // distinct, n1
+	Operands          int     `json:"operands"`  // distinct, n2
+	TotalOperators    int     `json:"totalOperators"`
+	TotalOperands     int     `json:"totalOperands"`
+	Vocabulary        int     `json:"vocabulary"`
+	Length            int     `json:"length"`
+	Volume            float64 `json:"volume"`
+	Difficulty        float64 `json:"difficulty"`
+	Effort            float64 `json:"effort"`
+
+	operators, operands map[string]int
+}
+
+type Metrics struct {
+	Program    string         `json:"program"`
+	Complexity int            `json:"complexity"`
+	Nesting    int            `json:"nesting"`
+	Statements int            `json:"statements"`
+	ByKind     map[string]int `json:"byKind"` // statements, by keyword
+	Constants  int            `json:"constants"`
+	Variables  int            `json:"variables"`
+	Halstead   Halstead       `json:"halstead"`
+
+	deepest ast.Pos // the first statement of the deepest level
+}
+
+// the limits of a course, 0 is none
+type Limits struct {
+	Complexity, Nesting, Statements int
+}
+
+func New(prog *ast.Program) *Metrics {
+	m := &Metrics{Program: prog.Name, Complexity: 1, ByKind: map[string]int{}, deepest: prog.Code}
+	m.Halstead.operators, m.Halstead.operands = map[string]int{}, map[string]int{}
+	for _, d := range prog.Decls {
+		switch d := d.(type) {
+		case *ast.Const:
+			m.Constants++
+		case *ast.Var:
+			m.Variables += len(d.Names)
+		}
+	}
+	m.block(prog.Body, 1)
+	m.Halstead.count()
+	return m
+}
+
+func (m *Metrics) block(list []ast.Stmt, level int) {
+	for _, s := range list {
+		m.stmt(s, level)
+	}
+}
+
+func (m *Metrics) stmt(s ast.Stmt, level int) {
+	m.Statements++
+	if level > m.Nesting {
+		m.Nesting, m.deepest = level, s.Position()
+	}
+	h := &m.Halstead
+	switch s := s.(type) {
+	case *ast.Assign:
+		m.ByKind["assign"]++
+		h.operators["<-"]++
+		h.operands[s.Name]++
+		m.expr(s.X)
+	case *ast.Input:
+		m.ByKind["input"]++
+		h.operators["input"]++
+		for _, id := range s.Names {
+			h.operands[id.Name]++
+		}
+	case *ast.Output:
+		m.ByKind["output"]++
+		h.operators["output"]++
+		for _, x := range s.Exprs {
+			m.expr(x)
+		}
+	case *ast.While:
+		m.ByKind["while"]++
+		m.Complexity++
+		h.operators["while"]++
+		m.expr(s.Cond)
+		m.block(s.Body, level+1)
+	case *ast.Repeat:
+		m.ByKind["repeat"]++
+		m.Complexity++
+		h.operators["repeat"]++
+		m.block(s.Body, level+1)
+		m.expr(s.Cond)
+	case *ast.If:
+		m.ByKind["if"]++
+		m.Complexity += 1 + len(s.Elifs)
+		h.operators["if"]++
+		m.expr(s.Cond)
+		m.block(s.Then, level+1)
+		for _, elif := range s.Elifs {
+			h.operators["elif"]++
+			m.expr(elif.Cond)
+			m.block(elif.Body, level+1)
+		}
+		if s.Else != nil {
+			h.operators["else"]++
+			m.block(s.Else.Body, level+1)
+		}
+	case *ast.Case:
+		m.ByKind["case"]++
+		m.Complexity += len(s.Labels)
+		h.operators["case"]++
+		m.expr(s.X)
+		for _, label := range s.Labels {
+			m.expr(label.X)
+			m.block(label.Body, level+1)
+		}
+		if s.Default != nil {
+			h.operators["otherwise"]++
+			m.block(s.Default.Body, level+1)
+		}
+	}
+}
+
+func (m *Metrics) expr(x ast.Expr) {
+	h := &m.Halstead
+	switch x := x.(type) {
+	case *ast.Ident:
+		h.operands[x.Name]++
+	case *ast.Literal:
+		h.operands[x.Text]++
+	case *ast.Paren:
+		h.operators["()"]++
+		m.expr(x.X)
+	case *ast.Unary:
+		h.operators["unary "+x.Op]++
+		m.expr(x.X)
+	case *ast.Binary:
+		h.operators[x.Op]++
+		m.expr(x.X)
+		m.expr(x.Y)
+	}
+}
+
+func (h *Halstead) count() {
+	h.Operators, h.Operands = len(h.operators), len(h.operands)
+	for _, n := range h.operators {
+		h.TotalOperators += n
+	}
+	for _, n := range h.operands {
+		h.TotalOperands += n
+	}
+	h.Vocabulary = h.Operators + h.Operands
+	h.Length = h.TotalOperators + h.TotalOperands
+	if h.Vocabulary > 0 {
+		h.Volume = round(float64(h.Length) * math.Log2(float64(h.Vocabulary)))
+	}
+	if h.Operands > 0 {
+		h.Difficulty = round(float64(h.Operators) / 2 * float64(h.TotalOperands) / float64(h.Operands))
+	}
+	h.Effort = round(h.Difficulty * h.Volume)
+}
+
+// to two decimals, as they are reported
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// a warning of each limit m exceeds, at the program header, or at the deepest statement
+func (m *Metrics) Check(prog *ast.Program, limits Limits, diags *diag.List) {
+	at := diag.At(prog.Pos.File, prog.Pos.Line, prog.Pos.Col, 1)
+	if limits.Complexity > 0 && m.Complexity > limits.Complexity {
+		diags.Add(at.Warnf("M001", "Cyclomatic complexity of %v is %v, above %v", m.Program, m.Complexity, limits.Complexity))
+	}
+	if limits.Nesting > 0 && m.Nesting > limits.Nesting {
+		diags.Add(diag.At(m.deepest.File, m.deepest.Line, m.deepest.Col, 1).
+			Warnf("M002", "Statement nested at level %v, above %v", m.Nesting, limits.Nesting))
+	}
+	if limits.Statements > 0 && m.Statements > limits.Statements {
+		diags.Add(at.Warnf("M003", "Program %v has %v statements, above %v", m.Program, m.Statements, limits.Statements))
+	}
+}
+
+// as an indented JSON object, ending with a newline
+func (m *Metrics) JSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(m)
+	return buf.Bytes(), err
+}
+
+var kinds = []string{"assign", "input", "output", "if", "case", "while", "repeat"}
+
+func (m *Metrics) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	h := m.Halstead
+	fmt.Fprintf(tw, "program\t%v\n", m.Program)
+	fmt.Fprintf(tw, "cyclomatic complexity\t%v\n", m.Complexity)
+	fmt.Fprintf(tw, "nesting\t%v\n", m.Nesting)
+	fmt.Fprintf(tw, "statements\t%v\n", m.Statements)
+	for _, k := range kinds {
+		if m.ByKind[k] > 0 {
+			fmt.Fprintf(tw, "  %v\t%v\n", k, m.ByKind[k])
+		}
+	}
+	fmt.Fprintf(tw, "constants\t%v\n", m.Constants)
+	fmt.Fprintf(tw, "variables\t%v\n", m.Variables)
+	fmt.Fprintf(tw, "operators, distinct and total\t%v\t%v\n", h.Operators, h.TotalOperators)
+	fmt.Fprintf(tw, "operands, distinct and total\t%v\t%v\n", h.Operands, h.TotalOperands)
+	fmt.Fprintf(tw, "vocabulary\t%v\n", h.Vocabulary)
+	fmt.Fprintf(tw, "length\t%v\n", h.Length)
+	fmt.Fprintf(tw, "volume\t%v\n", h.Volume)
+	fmt.Fprintf(tw, "difficulty\t%v\n", h.Difficulty)
+	fmt.Fprintf(tw, "effort\t%v\n", h.Effort)
+	return tw.Flush()
+}
@@ -0,0 +1,76 @@
+package metrics_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/diag"
+	"dap/metrics"
+	"dap/parser"
+)
+
+var update = flag.Bool("update", false, "write the .txt and .json files of testdata/metrics")
+
+// the metrics of each program of testdata/metrics, as its .txt prints them and its .json has them
+func TestMetrics(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", "metrics", "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/metrics: %v", err)
+	}
+	for _, f := range files {
+		compiler := parser.Compiler{Log: ioutil.Discard}
+		prog, err := compiler.CompileFile(f)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		m := metrics.New(prog.Tree)
+		var text bytes.Buffer
+		if err := m.WriteText(&text); err != nil {
+			t.Fatal(err)
+		}
+		data, err := m.JSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ext, got := range map[string][]byte{".txt": text.Bytes(), ".json": data} {
+			golden := strings.TrimSuffix(f, ".dap") + ext
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Errorf("%v: %v", f, err)
+				continue
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%v: the metrics differ from %v\nwant:\n%s\ngot:\n%s", f, filepath.Base(golden), want, got)
+			}
+		}
+	}
+}
+
+// the limits a course sets on primes.dap, each exceeded one is warned of once, where the README says
+func TestLimits(t *testing.T) {
+	compiler := parser.Compiler{Log: ioutil.Discard}
+	prog, err := compiler.CompileFile(filepath.Join("..", "testdata", "metrics", "primes.dap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags := diag.NewList(nil)
+	metrics.New(prog.Tree).Check(prog.Tree, metrics.Limits{Complexity: 4, Nesting: 2, Statements: 11}, diags)
+	got := []string{}
+	for _, d := range diags.Items() {
+		got = append(got, fmt.Sprintf("%v:%v %v", d.Line, d.Col, d.Code))
+	}
+	if want := "1:0 M001 13:16 M002"; strings.Join(got, " ") != want {
+		t.Errorf("warned of %v, want %v", strings.Join(got, " "), want)
+	}
+}
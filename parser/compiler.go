@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"dap/ast"
+	"dap/check"
+	"dap/codegen"
+	"dap/diag"
+	"dap/emulator"
+	"dap/scanner"
+)
+
+/* compiles programs, from a source file or any reader,
+   each compilation has its own scanner, parser, and machine,
+   so one Compiler may compile several programs at the same time
+*/
+type Compiler struct {
+	SearchPath   []string  // folders of the units, after the source folder
+	Keywords     string    // keyword profile, a name or a .json profile file
+	Strict       bool      // reject the keywords of other profiles
+	WordSize     int       // 8, 16, 32, or 64 bits, 64 if not given
+	OverflowTrap bool      // stop on integer overflow, instead of wrapping around
+	NoWarn       []string  // codes of the warnings left out, e.g. P501
+	Log          io.Writer // compilation messages, os.Stderr if not given
+}
+
+/* a compiled program, the machine holds its codes and symbols,
+   running it adds to the diagnostics of the compilation
+*/
+type Program struct {
+	Tree        *ast.Program
+	Machine     *em.Machine
+	Diagnostics *diag.List
+	Spelling    func(typ string) string // how the profile of the source writes a token type
+	Keywords    []string                // of the profile of the source
+}
+
+/* compile src, name is the source file it comes from,
+   if compilation fails, the program has its diagnostics,
+   and its tree when the source could be parsed, for an editor
+*/
+func (c Compiler) Compile(name string, src io.Reader) (*Program, error) {
+	w := c.Log
+	if w == nil {
+		w = os.Stderr
+	}
+	logger := log.New(w, "", log.LstdFlags)
+	diags := diag.NewList(logger)
+	diags.Disable(c.NoWarn...)
+	failed := &Program{Diagnostics: diags}
+	machine := em.New(logger, diags)
+	if c.WordSize > 0 {
+		machine.WordSize = c.WordSize
+	}
+	machine.OverflowTrap = c.OverflowTrap
+	scan := scanner.New(name, src, logger, diags)
+	scan.SearchPath = append([]string{filepath.Dir(name)}, c.SearchPath...)
+	if err := scan.SelectProfile(c.Keywords, c.Strict); err != nil {
+		diags.Add(diag.At(name, 1, 0, 0).Errorf("S005", "%v", err))
+		return failed, err
+	}
+	p := New(scan, machine, logger, diags)
+	tree, err := p.Parse()
+	failed.Spelling, failed.Keywords = scan.Spelling, scan.Keywords()
+	if err != nil {
+		return failed, err
+	}
+	failed.Tree = tree
+	check.Resolve(tree, scan.IsKeyword, diags)
+	check.Assigned(tree, diags)
+	check.Usage(tree, diags)
+	if toterr := diags.Errors(); toterr > 0 {
+		return failed, fmt.Errorf("*** DAP compilation error count %v", toterr)
+	}
+	logger.Printf("*** DAP compilation successful")
+	codegen.Generate(machine, tree)
+	if diags.Errors() > 0 {
+		return failed, fmt.Errorf("*** DAP code generation error count %v", diags.Errors())
+	}
+	p.ProcessSymbols()
+	machine.GenCodes()
+	return &Program{Tree: tree, Machine: machine, Diagnostics: diags, Spelling: scan.Spelling, Keywords: scan.Keywords()}, nil
+}
+
+func (c Compiler) CompileFile(fname string) (*Program, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		diags := diag.NewList(nil)
+		diags.Add(diag.At(fname, 1, 0, 0).Errorf("S000", "%v", err))
+		return &Program{Diagnostics: diags}, err
+	}
+	defer file.Close()
+	return c.Compile(fname, file)
+}
@@ -0,0 +1,112 @@
+package parser_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"dap/parser"
+)
+
+var update = flag.Bool("update", false, "write the .json files of testdata/broken")
+
+/* compilations in goroutines of their own share nothing, each gives
+   the codes and the diagnostics one alone gives, go test -race checks
+   that they do not touch the same memory
+*/
+func TestCompileConcurrently(t *testing.T) {
+	folder := filepath.Join("..", "testdata", "codegen")
+	files, err := filepath.Glob(filepath.Join(folder, "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/codegen: %v", err)
+	}
+	compile := func(f string, word int) string {
+		compiler := parser.Compiler{SearchPath: []string{filepath.Join(folder, "lib")}, WordSize: word, Log: ioutil.Discard}
+		prog, err := compiler.CompileFile(f)
+		if err != nil {
+			return err.Error()
+		}
+		text := []string{}
+		for _, d := range prog.Diagnostics.Items() {
+			text = append(text, d.String())
+		}
+		return strings.Join(append(text, prog.Machine.GenInit()...), "\n")
+	}
+	type run struct {
+		file string
+		word int
+	}
+	want := map[run]string{}
+	for _, f := range files {
+		for _, word := range []int{64, 8} {
+			want[run{f, word}] = compile(f, word)
+		}
+	}
+	const n = 8
+	got := map[run][]string{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for r := range want {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(r run) {
+				defer wg.Done()
+				text := compile(r.file, r.word)
+				mu.Lock()
+				got[r] = append(got[r], text)
+				mu.Unlock()
+			}(r)
+		}
+	}
+	wg.Wait()
+	for r, texts := range got {
+		for _, text := range texts {
+			if text != want[r] {
+				t.Errorf("%v, %v-bit word: a compilation differs from one alone", r.file, r.word)
+				break
+			}
+		}
+	}
+}
+
+/* each program of testdata/broken gives the diagnostics of its .json,
+   as dap -diagnostics json writes them in that folder, -update writes
+   them
+*/
+func TestBroken(t *testing.T) {
+	folder := filepath.Join("..", "testdata", "broken")
+	files, err := filepath.Glob(filepath.Join(folder, "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/broken: %v", err)
+	}
+	for _, f := range files {
+		src, err := os.Open(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compiler := parser.Compiler{Log: ioutil.Discard}
+		prog, _ := compiler.Compile(filepath.Base(f), src) // named as in its folder
+		src.Close()
+		got, err := prog.Diagnostics.JSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden := strings.TrimSuffix(f, ".dap") + ".json"
+		if *update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%v: the diagnostics differ from %v\nwant:\n%s\ngot:\n%s", f, filepath.Base(golden), want, got)
+		}
+	}
+}
@@ -1,244 +1,481 @@
 package parser
 
 import (
+	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+	"dap/ast"
+	"dap/diag"
 	"dap/emulator"
 	"dap/scanner"
 )
 
 type nameattr struct {
+	unit   string
 	parent string
 	typ    string
 	val    string
 	loc    int
+	cons   bool
 }
 
-var (
-	errcount = 0
-	parent   = ""
-	varcoll  = map[string]nameattr{}
-	loc      = 0
+/* parsing a program and the units it uses,
+   one for each compilation
+*/
+type Parser struct {
+	log      *log.Logger
+	diags    *diag.List
+	errFile  string // where the last error is
+	errLine  int
+	machine  *em.Machine // its word size folds the constants
+	unit     string // the main program is the nameless unit
+	parent   string
+	varcoll  map[string]nameattr
+	uses     map[string][]string // unit: used units
+	loaded   map[string]bool     // unit: done loading, false while loading
+	units    map[string]*ast.Unit
+	unitlist []*ast.Unit // in the order of loading
+	loc      int
+	tab      int
+	scan     *scanner.Scanner
 	token    *scanner.Token
-)
+}
+
+func New(scan *scanner.Scanner, machine *em.Machine, logger *log.Logger, diags *diag.List) *Parser {
+	return &Parser{
+		log:      logger,
+		diags:    diags,
+		machine:  machine,
+		varcoll:  map[string]nameattr{},
+		uses:     map[string][]string{},
+		loaded:   map[string]bool{},
+		units:    map[string]*ast.Unit{},
+		unitlist: []*ast.Unit{},
+		scan:     scan,
+		token:    scan.Token(),
+	}
+}
+
+func (a nameattr) symbol(name string) ast.Symbol {
+	return ast.Symbol{Unit: a.unit, Parent: a.parent, Name: name, Typ: a.typ, Val: a.val, Loc: a.loc, Const: a.cons}
+}
+
+// where the current token is
+func (p *Parser) tokpos() ast.Pos {
+	return ast.Pos{File: p.token.File, Line: p.token.Lno, Col: p.token.Cno}
+}
+
+// line and indentation of the current token, as LINE marks it
+func (p *Parser) linepos() ast.Pos {
+	l, c := p.token.GetLineCol()
+	return ast.Pos{File: p.token.GetFile(), Line: l, Col: c}
+}
+
+// a problem at the current token
+func (p *Parser) at() diag.Diagnostic {
+	length := utf8.RuneCountInString(p.token.Val)
+	if p.token.AtEnd() {
+		length = 0
+	}
+	return diag.At(p.token.File, p.token.Lno, p.token.Cno, length)
+}
+
+// the current token as a message names what is found
+func (p *Parser) found() string {
+	if p.token.AtEnd() {
+		return "end of file"
+	}
+	return p.token.Val
+}
+
+// where the token before the current one ends
+func (p *Parser) after() diag.Diagnostic {
+	l, c := p.token.After()
+	return diag.At(p.token.File, l, c, 0)
+}
+
+/* one error a line, the others on it would follow from the first one
+ */
+func (p *Parser) report(d diag.Diagnostic) {
+	if d.Severity == diag.Error {
+		if d.File == p.errFile && d.Line == p.errLine {
+			return
+		}
+		p.errFile, p.errLine = d.File, d.Line
+	}
+	p.diags.Add(d)
+}
+
+// a problem at pos, the end is not known
+func atPos(pos ast.Pos) diag.Diagnostic {
+	return diag.At(pos.File, pos.Line, pos.Col, 0)
+}
+
+// where x starts, at its leftmost operand
+func start(x ast.Expr) ast.Pos {
+	for b, ok := x.(*ast.Binary); ok; b, ok = x.(*ast.Binary) {
+		x = b.X
+	}
+	return x.Position()
+}
+
+func vkey(unit, parent, name string) string {
+	if unit == "" {
+		return parent + ":" + name
+	}
+	return unit + "." + parent + ":" + name
+}
+
+/* the current unit first, then the units it uses
+ */
+func (p *Parser) lookup(name string) nameattr {
+	if attr, ok := p.varcoll[vkey(p.unit, p.parent, name)]; ok {
+		return attr
+	}
+	found := nameattr{}
+	for _, u := range p.uses[p.unit] {
+		if attr, ok := p.varcoll[vkey(u, "", name)]; !ok {
+		} else if found != (nameattr{}) {
+			p.report(p.at().Errorf("P202", "Ambiguous name %v, declared in %v and %v", name, found.unit, u))
+		} else {
+			found = attr
+		}
+	}
+	return found
+}
 
 /* skip until typ is found
    alternate stopping tokens are stop and dead
 */
-func sync(typ string, stop string, dead string) {
-	for t := token.Next(); t != typ && t != stop && t != dead; t = token.Next() {
-		// log.Println(" waiting", typ, "skipping", t)
+func (p *Parser) sync(typ string, stop string, dead string) {
+	for t := p.token.Next(); t != typ && t != stop && t != dead; t = p.token.Next() {
+		// p.log.Println(" waiting", typ, "skipping", t)
 	}
-	token.PushBack()
+	p.token.PushBack()
 }
 
 /* skip if typ is found
  */
-func skip(typ string) bool {
-	// log.Print("skip ", typ)
-	matched := token.Next() == typ
+func (p *Parser) skip(typ string) bool {
+	// p.log.Print("skip ", typ)
+	matched := p.token.Next() == typ
 	if !matched {
-		token.PushBack()
+		p.token.PushBack()
 	}
 	return matched
 }
 
-func expect(tok, msg string) {
-	if token.Next() != tok {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- %s", l, c, msg)
-		token.PushBack()
-		errcount++
+func tokens(typs ...string) map[string]bool {
+	set := map[string]bool{}
+	for _, typ := range typs {
+		set[typ] = true
+	}
+	return set
+}
+
+/* follow sets, in panic mode the tokens of a broken construct
+   are skipped up to one of its followers, or up to the next line
+*/
+var (
+	followLine    = tokens()                                               // do, then, <-, and of end their line
+	followName    = tokens("$MEQ", "$ASSG", "$COLON", "$COMMA")             // a declared name
+	followParen   = tokens("$THEN", "$DO", "$OF", "$COLON", "$COMMA")       // ) of an operand
+	followOperand = tokens("$THEN", "$DO", "$OF", "$COLON", "$COMMA", "$RIGHTPAR")
+)
+
+var statementStart = tokens("$NAME", "$INPUT", "$OUTPUT", "$WHILE", "$REPEAT", "$IF", "$CASE")
+
+// tokens taken for another, as students write them
+var mistaken = map[string][]string{
+	"$ASSG": {"$MEQ", "$EQ"},
+}
+
+/* skip the rest of the line up to tok, or up to a token in follow,
+   true if tok is found, the current token is the first one skipped
+*/
+func (p *Parser) skipTo(tok string, follow map[string]bool) bool {
+	for !p.token.First && !follow[p.token.Typ] {
+		if p.token.Typ == tok {
+			return true
+		}
+		p.token.Next()
+	}
+	p.token.PushBack()
+	return false
+}
+
+/* tok is expected in the line, as the token after the previous one,
+   false if it is missing, then the broken part of the line is skipped
+*/
+func (p *Parser) expect(tok, msg string, follow map[string]bool) bool {
+	typ := p.token.Next()
+	if typ == tok {
+		return true
+	}
+	w := p.scan.Spelling(tok)
+	for _, t := range mistaken[tok] {
+		if typ == t {
+			d := p.at().Errorf("P101", "%s, found %v", msg, p.found())
+			p.report(d.WithFix(w, "replace by '%v'", w))
+			return true
+		}
+	}
+	if p.token.First || follow[typ] { // nothing in between
+		d := p.after().Errorf("P101", "%s", msg)
+		if w != "" {
+			d = d.WithFix(" "+w, "insert '%v'", w)
+		}
+		p.report(d)
+		p.token.PushBack()
+		return false
+	}
+	d, found := p.at(), p.token.Val
+	if p.skipTo(tok, follow) {
+		p.report(d.Errorf("P110", "Unexpected %v before %v", found, w))
+		return true
 	}
+	p.report(d.Errorf("P101", "%s", msg))
+	return false
 }
 
 /*
    variable { , variable }*
 */
-func variable_list(gen func(string, string, int)) (namelist []string) {
-	// log.Print("variables", token.Val)
-	namelist = []string{}
-	for {
-		if typ := token.Next(); typ == "$NAME" {
-			// found a declared name
-			namelist = append(namelist, token.Val)
-			attr := varcoll[parent+":"+token.Val]
-			gen(attr.typ, attr.parent, attr.loc)
-			// log.Print(" ", token.Val)
-		}
-		if token.Next() != "$COMMA" {
+func (p *Parser) variable_list() (namelist []*ast.Ident) {
+	// p.log.Print("variables", p.token.Val)
+	namelist = []*ast.Ident{}
+	for listed := false; ; listed = true {
+		var id *ast.Ident
+		typ := p.token.Next()
+		if typ == "$NAME" || p.scan.IsKeyword(p.token.Val) {
+			// found a declared name, or a keyword the resolver reports
+			attr := p.lookup(p.token.Val)
+			id = &ast.Ident{Pos: p.tokpos(), Typed: ast.Typed{Typ: attr.typ, Val: attr.val}, Name: p.token.Val, Scope: p.parent, Sym: attr.symbol(p.token.Val)}
+			// p.log.Print(" ", p.token.Val)
+		}
+		next := p.token.Next()
+		if id != nil && (typ == "$NAME" || listed || next == "$COMMA" || next == "$COLON") {
+			namelist = append(namelist, id)
+		}
+		if next != "$COMMA" {
 			break
 		}
 	}
-	token.PushBack()
+	p.token.PushBack()
 	return
 }
 
 /*
    { [var] variable_list : type }+
 */
-func declaration() {
-	// log.Print("declaring ")
-	totdecl := 0
-	for typ := token.Next(); typ == "$DICT" || typ == "$LOCAL" || typ == "$GLOBAL"; typ = token.Next() {
+func (p *Parser) declaration() (decls []ast.Decl) {
+	// p.log.Print("declaring ")
+	for typ := p.token.Next(); typ == "$DICT" || typ == "$LOCAL" || typ == "$GLOBAL"; typ = p.token.Next() {
 	}
 	for {
-		// em.GenLine(token.GetLineCol())
-		if token.Typ == "$CONST" {
-			skip("$CONST")
-			expect("$NAME", "name expected")
-			clabel := token.Val
-			skip("$MEQ")
-			skip("$ASSIGN")
-			typ, val := expression()
-			varcoll[parent+":"+clabel] = nameattr{parent: parent, typ: typ, val: val} // typ&val from exp
+		if p.token.Typ == "$CONST" {
+			pos := p.tokpos()
+			p.skip("$CONST")
+			if p.token.Next(); !p.scan.IsKeyword(p.token.Val) { // a keyword is reported by the resolver
+				p.token.PushBack()
+				p.expect("$NAME", "name expected", followName)
+			}
+			clabel, namepos := p.token.Val, p.tokpos()
+			p.skip("$MEQ")
+			p.skip("$ASSIGN")
+			x := p.expression()
+			attr := nameattr{unit: p.unit, parent: p.parent, typ: x.Type(), val: x.Value(), cons: true} // typ&val from exp
+			if first, ok := p.varcoll[vkey(p.unit, p.parent, clabel)]; ok {
+				attr = first // declared again, the resolver reports it, the first one stays
+			} else {
+				p.varcoll[vkey(p.unit, p.parent, clabel)] = attr
+			}
+			decls = append(decls, &ast.Const{Pos: pos, Name: clabel, NamePos: namepos, X: x, Sym: attr.symbol(clabel)})
+		} else if end, ok := map[string]string{"$PROC": "$ENDPROC", "$FUNC": "$ENDFUNC"}[p.token.Typ]; ok {
+			// not yet in the language, see docs/addendum.txt, skipped to its end
+			p.report(p.at().Errorf("P111", "%v is not yet part of the language, declare constants and variables", p.token.Val))
+			p.sync(end, "$ENDUNIT", "$ENDPROG")
+			p.skip(end)
 		} else {
-			token.PushBack()
-			skip("$VAR")
-			namelist := variable_list(em.GenNil)
-			totdecl += len(namelist)
-			skip("$COLON")
-			typ := token.Next()
+			p.token.PushBack()
+			p.skip("$VAR")
+			decl := &ast.Var{Pos: p.tokpos()}
+			decl.Names = p.variable_list()
+			p.skip("$COLON")
+			typ := p.token.Next()
 			if typ == "$INT" || typ == "$REAL" || typ == "$CHAR" || typ == "$BOOL" || typ == "$CHARRAY" {
+				decl.Type = typ
 				if typ == "$CHAR" {
 					typ = "$CHARRAY" // for now, later will split again
 				}
-				for _, v := range namelist {
-					loc++
-					varcoll[parent+":"+v] = nameattr{parent: parent, typ: typ, val: em.EMPTY, loc: loc}
+				for _, id := range decl.Names {
+					attr, ok := p.varcoll[vkey(p.unit, p.parent, id.Name)]
+					if !ok { // declared again, the first one stays
+						p.loc++
+						attr = nameattr{unit: p.unit, parent: p.parent, typ: typ, val: em.EMPTY, loc: p.loc}
+						p.varcoll[vkey(p.unit, p.parent, id.Name)] = attr
+					}
+					id.Typed, id.Sym = ast.Typed{Typ: attr.typ, Val: attr.val}, attr.symbol(id.Name)
 				}
-				// log.Print(" ", token.Val)
+				// p.log.Print(" ", p.token.Val)
 			} else {
-				token.PushBack()
+				p.token.PushBack()
 			}
+			decls = append(decls, decl)
 		}
-		if typ := token.Peek(); typ == "$CODE" || typ == "$ENDPROG" {
+		if typ := p.token.Peek(); typ == "$CODE" || typ == "$ENDPROG" || typ == "$ENDUNIT" {
 			break
 		}
 	}
-	// log.Println("declared vars: ", totdecl)
-	if totdecl > 0 {
-		em.GenClaim(totdecl)
-	}
-	token.PushBack()
+	p.token.PushBack()
 	/*
 		for k, v := range varcoll {
 			log.Println("declared variables", k, v)
 		}
 	*/
+	return
 }
 
-func isStartExpression() bool {
-	typ := token.Peek()
+func (p *Parser) isStartExpression() bool {
+	typ := p.token.Peek()
 	return typ == "$NAME" || typ == "$NUMBER" || typ == "$CHAR" || typ == "$CHARRAY" || typ == "$TRUE" || typ == "$FALSE" || typ == "$LEFTPAR" || typ == "$MINUS" || typ == "$NOT"
 }
 
 /* value | variable | - expr | par_expr
  */
-func literal() (string, string) {
+func (p *Parser) literal() ast.Expr {
 	typ := "$NUMBER"
 	val := em.EMPTY
-	// log.Print("literal ", token.Peek())
-	switch token.Next() {
+	var x ast.Expr
+	// p.log.Print("literal ", p.token.Peek())
+	switch p.token.Next() {
 	case "$NAME":
-		attr := varcoll[parent+":"+token.Val]
+		pos := p.tokpos()
+		attr := p.lookup(p.token.Val)
 		typ = attr.typ
 		if typ == "$INT" || typ == "$REAL" {
 			typ = "$NUMBER"
 		}
 		if attr == (nameattr{}) {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- Error, variable %v:%v is not defined", l, c, parent, token.Val)
-			errcount++
+			p.report(p.at().Errorf("P201", "Variable %v is not defined", p.token.Val))
 		} else if attr.val == em.EMPTY {
-			em.GenCopy(parent, attr.loc)
 		} else {
 			val = attr.val
 		}
-		// log.Printf("name=%v type=%v %v val=%v %v", token.Val, attr.typ, typ, attr.val, val)
+		// p.log.Printf("name=%v type=%v %v val=%v %v", p.token.Val, attr.typ, typ, attr.val, val)
+		x = &ast.Ident{Pos: pos, Typed: ast.Typed{Typ: typ, Val: val}, Name: p.token.Val, Scope: p.parent, Sym: attr.symbol(p.token.Val)}
 
 	case "$NUMBER":
 		typ = "$NUMBER"
-		val = token.Val
-		// em.GenConst( token.Val )
-		// log.Print("value=", token.Val)
+		val = p.token.Val
+		if nval, err := strconv.Atoi(val); err == nil && !p.machine.InWord(nval) {
+			p.report(p.at().Warnf("P203", "Constant %v overflows %v-bit word", val, p.machine.WordSize))
+		}
+		x = &ast.Literal{Pos: p.tokpos(), Typed: ast.Typed{Typ: typ, Val: val}, Kind: "$NUMBER", Text: p.token.Val}
+		// p.log.Print("value=", p.token.Val)
 
 	case "$CHAR", "$CHARRAY":
 		typ = "$CHARRAY"
-		if v, err := strconv.Unquote(token.Val + string(token.Val[0])); err != nil {
-			log.Print(err)
-			val = token.Val[1:]
+		if v, err := strconv.Unquote(p.token.Val + string(p.token.Val[0])); err != nil {
+			p.report(p.at().Warnf("P205", "String %v, %v", p.token.Val, err))
+			val = p.token.Val[1:]
 		} else {
 			val = v
 			// log.Print(v)
 		}
-		// log.Print("string=", token.Val)
-		// em.GenConst( token.Val )
+		x = &ast.Literal{Pos: p.tokpos(), Typed: ast.Typed{Typ: typ, Val: val}, Kind: "$CHARRAY", Text: p.token.Val}
+		// p.log.Print("string=", p.token.Val)
 
 	case "$TRUE":
 		typ = "$BOOL"
 		val = "true"
+		x = &ast.Literal{Pos: p.tokpos(), Typed: ast.Typed{Typ: typ, Val: val}, Kind: "$TRUE", Text: p.token.Val}
 
 	case "$FALSE":
 		typ = "$BOOL"
 		val = "false"
-		// em.GenConst( token.Val )
-		// log.Print("boolean=", token.Val)
+		x = &ast.Literal{Pos: p.tokpos(), Typed: ast.Typed{Typ: typ, Val: val}, Kind: "$FALSE", Text: p.token.Val}
+		// p.log.Print("boolean=", p.token.Val)
 
 	case "$LEFTPAR":
-		typ, val = expression()
-		expect("$RIGHTPAR", "missing )")
+		pos := p.tokpos()
+		px := p.expression()
+		typ, val = px.Type(), px.Value()
+		p.expect("$RIGHTPAR", "missing )", followParen)
+		x = &ast.Paren{Pos: pos, Typed: ast.Typed{Typ: typ, Val: val}, X: px}
 
 	case "$MINUS":
-		typ, val = literal()
+		pos := p.tokpos()
+		ux := p.literal()
+		typ, val = ux.Type(), ux.Value()
 		if typ != "$NUMBER" {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p  %v:%v -- Negation on a non numeric value", l, c)
-			errcount++
+			p.report(atPos(pos).Errorf("P206", "Negation on a non numeric value"))
 		} else {
 			nval, err := strconv.Atoi(val)
 			if err != nil {
 				val = em.EMPTY
-				em.GenOpCmd("$MINUS")
 			} else {
-				val = strconv.Itoa(-nval)
+				v, ovf := p.machine.Neg(nval)
+				val = p.checkOverflow(pos, v, ovf)
 			}
 		}
 		typ = "$NUMBER"
+		x = &ast.Unary{Pos: pos, Typed: ast.Typed{Typ: typ, Val: val}, Op: "$MINUS", X: ux}
 
 	case "$NOT":
-		typ, val = literal()
+		pos := p.tokpos()
+		ux := p.literal()
+		typ, val = ux.Type(), ux.Value()
 		if typ != "$BOOL" {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- NOT operation on a non boolean value ", l, c, typ)
-			errcount++
+			p.report(atPos(pos).Errorf("P207", "NOT operation on a non boolean value %v", typ))
 		} else if val == em.TRUE {
 			val = em.FALSE
 		} else if val == em.FALSE {
 			val = em.TRUE
-		} else {
-			em.GenOpCmd("$NOT")
 		}
 		typ = "$BOOL"
+		x = &ast.Unary{Pos: pos, Typed: ast.Typed{Typ: typ, Val: val}, Op: "$NOT", X: ux}
+
+	default: // nothing to take, the token is left to what follows
+		d := p.at()
+		if p.token.First || followOperand[p.token.Typ] {
+			d = p.after()
+			p.token.PushBack()
+		}
+		p.report(d.Errorf("P109", "Operand expected, found %v", p.found()))
+		x = &ast.Literal{Pos: ast.Pos{File: d.File, Line: d.Line, Col: d.Col}, Typed: ast.Typed{Typ: typ, Val: val}}
 	}
-	return typ, val
+	return x
+}
+
+/* folded constant, as the machine word would have it, an overflow
+   is reported at the operator at pos
+*/
+func (p *Parser) checkOverflow(pos ast.Pos, v int, ovf bool) string {
+	if ovf {
+		p.report(atPos(pos).Warnf("P204", "Constant expression overflows %v-bit word", p.machine.WordSize))
+	}
+	return strconv.Itoa(v)
 }
 
 /* literal [relexpr literal]
  */
-func relexpr() (string, string) {
-	//log.Print("comparison")
-	atyp, aval := literal()
-	//log.Print("from lit1 ", atyp, aval)
-	if typ := token.Peek(); typ == "$LEQ" || typ == "$GT" || typ == "$LT" || typ == "$GEQ" || typ == "$EQ" || typ == "$NEQ" {
-		token.Next()
-		btyp, bval := literal()
-		// log.Print("from lit ", atyp, btyp)
+func (p *Parser) relexpr() ast.Expr {
+	//p.log.Print("comparison")
+	a := p.literal()
+	atyp, aval := a.Type(), a.Value()
+	//p.log.Print("from lit1 ", atyp, aval)
+	if typ := p.token.Peek(); typ == "$LEQ" || typ == "$GT" || typ == "$LT" || typ == "$GEQ" || typ == "$EQ" || typ == "$NEQ" {
+		p.token.Next()
+		pos := p.tokpos()
+		b := p.literal()
+		btyp, bval := b.Type(), b.Value()
+		// p.log.Print("from lit ", atyp, btyp)
 		if atyp != btyp { // only $NUMBER is meaningful at this time
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- Mismatch cmp-expression %v vs. %v", l, c, atyp, btyp)
-			errcount++
+			p.report(atPos(pos).Errorf("P208", "Mismatch cmp-expression %v vs. %v", atyp, btyp))
 		}
 		val := em.EMPTY
 		if aval != em.EMPTY && bval != em.EMPTY {
@@ -247,11 +484,11 @@ func relexpr() (string, string) {
 			bnval, errb := strconv.Atoi(bval)
 			if erra == nil && errb == nil {
 				switch typ {
-				case "$LE":
+				case "$LEQ":
 					cmpval = anval <= bnval
 				case "$LT":
 					cmpval = anval < bnval
-				case "$GE":
+				case "$GEQ":
 					cmpval = anval >= bnval
 				case "$GT":
 					cmpval = anval > bnval
@@ -262,47 +499,47 @@ func relexpr() (string, string) {
 				}
 				val = strconv.FormatBool(cmpval)
 			}
-		} else {
-			em.GenOp2Cmd(typ, atyp, aval, btyp, bval)
 		}
-		return "$BOOL", val
+		return &ast.Binary{Pos: pos, Typed: ast.Typed{Typ: "$BOOL", Val: val}, Op: typ, X: a, Y: b}
 	}
-	return atyp, aval
+	return a
 }
 
 /* literal {addop literal}*
    :D relexpr {mulop relexpr}* !!!
 */
-func mulexpr() (string, string) {
-	//log.Print("addition")
+func (p *Parser) mulexpr() ast.Expr {
+	//p.log.Print("addition")
 	atyp := ""
 	aval := em.EMPTY
 	btyp := ""
 	bval := em.EMPTY
 	typ := ""
+	var x ast.Expr
+	pos := ast.Pos{}
 	for {
-		btyp, bval = relexpr()
-		//log.Print("from relexpr ", atyp, btyp)
+		b := p.relexpr()
+		btyp, bval = b.Type(), b.Value()
+		//p.log.Print("from relexpr ", atyp, btyp)
 		if atyp == "" {
 			atyp = btyp
 			aval = bval
 		} else if atyp != btyp {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- Mismatch mul-expression %v vs. %v", l, c, atyp, btyp)
-			errcount++
+			p.report(atPos(pos).Errorf("P208", "Mismatch mul-expression %v vs. %v", atyp, btyp))
 		} else if atyp == "$NUMBER" {
 			anval, erra := strconv.Atoi(aval)
 			bnval, errb := strconv.Atoi(bval)
 			if erra != nil || errb != nil {
-				em.GenOp2Cmd(typ, atyp, aval, btyp, bval) // */%
 				bval = em.EMPTY
 			} else {
 				switch typ {
 				case "$MULT":
-					bval = strconv.Itoa(anval * bnval)
+					v, ovf := p.machine.Mul(anval, bnval)
+					bval = p.checkOverflow(pos, v, ovf)
 				case "$DIV":
 					if bnval != 0 {
-						bval = strconv.Itoa(anval / bnval)
+						v, ovf := p.machine.Div(anval, bnval)
+						bval = p.checkOverflow(pos, v, ovf)
 					} else {
 						bval = em.EMPTY
 					}
@@ -313,15 +550,12 @@ func mulexpr() (string, string) {
 						bval = em.EMPTY
 					}
 				default:
-					l, c := token.GetLineCol()
-					log.Printf("DAP %v:%v -- Illegal operands on numbers %v", l, c, typ)
-					errcount++
+					p.report(atPos(pos).Errorf("P209", "Illegal operands on numbers %v", typ))
 				}
 			}
 		} else if atyp == "$BOOL" {
 			if typ == "$AND" {
 				if aval == em.EMPTY || bval == em.EMPTY {
-					em.GenOp2Cmd(typ, atyp, aval, btyp, bval) // AND
 					bval = em.EMPTY
 				} else if aval == em.TRUE && bval == em.TRUE {
 					bval = em.TRUE
@@ -329,61 +563,63 @@ func mulexpr() (string, string) {
 					bval = em.FALSE
 				}
 			} else {
-				l, c := token.GetLineCol()
-				log.Printf("DAP.p %v:%v -- Illegal operands on boolean %v", l, c, typ)
-				errcount++
+				p.report(atPos(pos).Errorf("P209", "Illegal operands on boolean %v", typ))
 			}
 		} else if atyp == "$CHARRAY" {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- Illegal operation %v on %v", l, c, typ, btyp)
-			errcount++
+			p.report(atPos(pos).Errorf("P209", "Illegal operation %v on %v", typ, btyp))
 		}
-		if typ = token.Peek(); typ != "$MULT" && typ != "$DIV" && typ != "$MOD" && typ != "$AND" {
+		if x == nil {
+			x = b
+		} else {
+			x = &ast.Binary{Pos: pos, Typed: ast.Typed{Typ: btyp, Val: bval}, Op: typ, X: x, Y: b}
+		}
+		aval = bval // what the next operator applies to
+		if typ = p.token.Peek(); typ != "$MULT" && typ != "$DIV" && typ != "$MOD" && typ != "$AND" {
 			break
 		}
-		token.Next()
+		p.token.Next()
+		pos = p.tokpos()
 	}
-	return btyp, bval
+	return x
 }
 
 /* addexpr {mulop addexpr}*
    :D mulexpr {addop mulexpr}* !!!
 */
-func expression() (string, string) {
-	// log.Print("expression")
+func (p *Parser) expression() ast.Expr {
+	// p.log.Print("expression")
 	atyp := ""
 	aval := em.EMPTY
 	btyp := ""
 	bval := em.EMPTY
 	typ := ""
+	var x ast.Expr
+	pos := ast.Pos{}
 	for {
-		btyp, bval = mulexpr()
-		// log.Print("from addexpr ", atyp, btyp)
+		b := p.mulexpr()
+		btyp, bval = b.Type(), b.Value()
+		// p.log.Print("from addexpr ", atyp, btyp)
 		if atyp == "" {
 			atyp = btyp
 			aval = bval
 		} else if atyp != btyp {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- Mismatch add-expression %v vs. %v", l, c, atyp, btyp)
-			errcount++
+			p.report(atPos(pos).Errorf("P208", "Mismatch add-expression %v vs. %v", atyp, btyp))
 		} else if atyp == "$NUMBER" {
 			if typ != "$PLUS" && typ != "$MINUS" {
-				l, c := token.GetLineCol()
-				log.Printf("DAP.p %v:%v -- Illegal operators on numbers %v", l, c, typ)
-				errcount++
+				p.report(atPos(pos).Errorf("P209", "Illegal operators on numbers %v", typ))
 			} else if aval == em.EMPTY && bval == em.EMPTY {
-				em.GenOp2Cmd(typ, atyp, aval, btyp, bval) // +/-
 				bval = em.EMPTY
 			} else {
 				anval, erra := strconv.Atoi(aval)
 				bnval, errb := strconv.Atoi(bval)
 				if erra != nil || errb != nil {
-					em.GenOp2Cmd(typ, atyp, aval, btyp, bval) // +/-
 					bval = em.EMPTY
 				} else if typ == "$PLUS" {
-					bval = strconv.Itoa(anval + bnval)
+					v, ovf := p.machine.Add(anval, bnval)
+					bval = p.checkOverflow(pos, v, ovf)
 				} else { // $MINUS
-					bval = strconv.Itoa(anval - bnval)
+					v, ovf := p.machine.Sub(anval, bnval)
+					bval = p.checkOverflow(pos, v, ovf)
 				}
 			}
 		} else if atyp == "$BOOL" {
@@ -396,316 +632,254 @@ func expression() (string, string) {
 					}
 				} else {
 					bval = em.EMPTY
-					em.GenOp2Cmd(typ, atyp, aval, btyp, bval) // OR
 				}
 			} else {
-				l, c := token.GetLineCol()
-				log.Printf("DAP.p %v:%v -- Illegal operator on boolean %v", l, c, typ)
-				errcount++
+				p.report(atPos(pos).Errorf("P209", "Illegal operator on boolean %v", typ))
 			}
 		} else if typ == "$CHARRAY" {
 			if typ != "$PLUS" { // dunno what to generate yet
-				l, c := token.GetLineCol()
-				log.Printf("DAP.p %v:%v -- illegal operation %v on %v", l, c, typ, atyp)
-				errcount++
+				p.report(atPos(pos).Errorf("P209", "Illegal operation %v on %v", typ, atyp))
 			} else {
 				// genCode?
-				l, c := token.GetLineCol()
-				log.Printf("DAP.p %v:%v -- Unknown string operation %v", l, c, typ)
-				errcount++
+				p.report(atPos(pos).Errorf("P209", "Unknown string operation %v", typ))
 			}
 		}
-		if typ = token.Peek(); typ != "$PLUS" && typ != "$MINUS" && typ != "$OR" {
+		if x == nil {
+			x = b
+		} else {
+			x = &ast.Binary{Pos: pos, Typed: ast.Typed{Typ: btyp, Val: bval}, Op: typ, X: x, Y: b}
+		}
+		aval = bval // what the next operator applies to
+		if typ = p.token.Peek(); typ != "$PLUS" && typ != "$MINUS" && typ != "$OR" {
 			break
 		}
-		token.Next()
+		p.token.Next()
+		pos = p.tokpos()
 	}
-	return btyp, bval
+	return x
 }
 
 /* expr {, expr}*
  */
-func expression_list(gen func(string, string)) int {
-	// log.Print("expressions")
-	count := 0
+func (p *Parser) expression_list() (exprs []ast.Expr) {
+	// p.log.Print("expressions")
 	for {
-		etyp, eval := expression()
-		count++
-		gen(etyp, eval)
-		if typ := token.Peek(); typ != "$COMMA" {
+		exprs = append(exprs, p.expression())
+		if typ := p.token.Peek(); typ != "$COMMA" {
 			break
 		}
-		token.Next()
+		p.token.Next()
 	}
-	return count
+	return
 }
 
 /* variable <- expr
  */
-func assignment(lvl int) {
-	token.Next()
-	// log.Print("assignment ", token.Val)
-	assgline := token.GetLine() // this assignment line number
-	attr := varcoll[parent+":"+token.Val]
+func (p *Parser) assignment(lvl int) *ast.Assign {
+	stmt := &ast.Assign{Pos: p.linepos()}
+	p.token.Next()
+	// p.log.Print("assignment ", p.token.Val)
+	attr := p.lookup(p.token.Val)
+	stmt.Name, stmt.Sym = p.token.Val, attr.symbol(p.token.Val)
 	vtyp := attr.typ
 	if vtyp == "$INT" || vtyp == "$REAL" {
 		vtyp = "$NUMBER"
 	}
 	// keep token, then...
-	expect("$ASSG", "<- expected")
-	etyp, eval := expression()
-	if vtyp != etyp {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Type mismatch in assignment", l, c)
-		errcount++
+	if !p.expect("$ASSG", "<- expected", followLine) {
+		stmt.X = &ast.Literal{Pos: stmt.Pos, Typed: ast.Typed{Typ: vtyp, Val: em.EMPTY}}
+		return stmt
 	}
-	em.GenStore(etyp, attr.parent, attr.loc, eval)
-	em.CollectAssg(attr.parent, assgline, attr.loc)
+	stmt.X = p.expression()
+	if attr.typ != "" && vtyp != stmt.X.Type() { // an undeclared one is reported by the resolver
+		p.report(atPos(start(stmt.X)).Errorf("P210", "Type mismatch in assignment"))
+	}
+	return stmt
 }
 
 /* input variable_list
  */
-func input_stmt(lvl int) {
-	//log.Print("input stmt")
-	token.Next()
-	inpline := token.GetLine() // this input statement line number
-	namelist := variable_list(em.GenInp)
-	for _, v := range namelist {
-		attr := varcoll[parent+":"+v]
-		em.CollectAssg(parent, inpline, attr.loc)
-	}
+func (p *Parser) input_stmt(lvl int) *ast.Input {
+	//p.log.Print("input stmt")
+	stmt := &ast.Input{Pos: p.linepos()}
+	p.token.Next()
+	stmt.Names = p.variable_list()
+	return stmt
 }
 
 /* output expression_list
  */
-func output_stmt(lvl int) {
-	//log.Print("output stmt")
-	token.Next()
-	expression_list(em.GenOut)
+func (p *Parser) output_stmt(lvl int) *ast.Output {
+	//p.log.Print("output stmt")
+	stmt := &ast.Output{Pos: p.linepos()}
+	p.token.Next()
+	stmt.Exprs = p.expression_list()
+	return stmt
 }
 
-/* while bool_expr do code_list endwhile
+/* a condition is boolean, a constant one makes its statement useless
  */
-func while_stmt(lvl int) {
-	//log.Print("while stmt")
-	expect("$WHILE", "while expected")
-	l1 := em.GenLabel()
-	l2 := em.GenLabel()
-	em.GenLoc(l1)
-	em.GenLine(token.GetLineCol()) //!
-	etyp, eval := expression()
-	if etyp != "$BOOL" {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Non boolean condition", l, c)
-		errcount++
+func (p *Parser) condition(x ast.Expr, always, never string) {
+	if etyp, eval := x.Type(), x.Value(); etyp != "$BOOL" {
+		p.report(atPos(start(x)).Errorf("P211", "Non boolean condition"))
 	} else if eval == em.TRUE {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Infinite loop!", l, c)
-		errcount++
+		p.report(atPos(start(x)).Errorf("P212", "%s", always))
 	} else if eval == em.FALSE {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Loop never entered!", l, c)
-		errcount++
+		p.report(atPos(start(x)).Errorf("P212", "%s", never))
 	}
-	em.GenCond(l2, eval)
-	expect("$DO", "do expected") // or skip("$WHILE")
-	code_block(lvl + 1)
-	if skip("$ENDWHILE") { // optional
-		em.GenLine(token.GetLineCol()) //!
+}
+
+/* while bool_expr do code_list endwhile
+ */
+func (p *Parser) while_stmt(lvl int) *ast.While {
+	//p.log.Print("while stmt")
+	p.token.Next() // while
+	stmt := &ast.While{Pos: p.linepos()}
+	stmt.Cond = p.expression()
+	p.condition(stmt.Cond, "Infinite loop!", "Loop never entered!")
+	p.expect("$DO", "do expected", followLine)
+	stmt.Body = p.code_block(lvl + 1)
+	if p.skip("$ENDWHILE") { // optional
+		pos := p.linepos()
+		stmt.EndWhile = &pos
 	}
-	em.GenGoto(l1)
-	em.GenLoc(l2)
+	return stmt
 }
 
 /* repeat code_list until bool_expr
  */
-func repeat_stmt(lvl int) {
-	// log.Print("repeat-until stmt")
-	expect("$REPEAT", "repeat expected")
-	l1 := em.GenLabel()
-	em.GenLoc(l1)
-	em.GenLine(token.GetLineCol()) //!
-	code_block(lvl + 1)
-	expect("$UNTIL", "until expected")
-	em.GenLine(token.GetLineCol())
-	etyp, eval := expression()
-	if etyp != "$BOOL" {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Non boolean condition", l, c)
-		errcount++
-	} else if eval == em.TRUE {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Useless repeat-until", l, c)
-		errcount++
-	} else if eval == em.FALSE {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p  %v:%v -- Infinite repeat-until loop", l, c)
-		errcount++
+func (p *Parser) repeat_stmt(lvl int) *ast.Repeat {
+	// p.log.Print("repeat-until stmt")
+	p.token.Next() // repeat
+	stmt := &ast.Repeat{Pos: p.linepos()}
+	stmt.Body = p.code_block(lvl + 1)
+	if !p.skip("$UNTIL") { // the block ends without it
+		p.report(atPos(stmt.Pos).Errorf("P101", "until expected"))
+		stmt.Until = stmt.Pos
+		stmt.Cond = &ast.Literal{Pos: stmt.Pos, Typed: ast.Typed{Typ: "$BOOL", Val: em.EMPTY}}
+		return stmt
 	}
-	em.GenCond(l1, eval)
+	stmt.Until = p.linepos()
+	stmt.Cond = p.expression()
+	p.condition(stmt.Cond, "Useless repeat-until", "Infinite repeat-until loop")
+	return stmt
 }
 
 /* if bool_expr then code_list
    {elif bool_expr then code_list}* [else code_list] endif
 */
-func if_stmt(lvl int) {
-	// log.Print("if-then-else stmt")
-	expect("$IF", "if expected")
-	etyp, eval := expression()
-	if etyp != "$BOOL" {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Non boolean condition", l, c)
-		errcount++
-	} else if eval == em.TRUE {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Then part is always executed", l, c)
-		errcount++
-	} else if eval == em.FALSE {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Never entering the then part", l, c)
-		errcount++
-	}
-	lels := em.GenLabel()
-	lfin := em.GenLabel()
-	em.GenCond(lels, eval)
-	expect("$THEN", "then expected") // or skip("$THEN")
-	code_block(lvl + 1)
-	typ := token.Next()
+func (p *Parser) if_stmt(lvl int) *ast.If {
+	// p.log.Print("if-then-else stmt")
+	stmt := &ast.If{Pos: p.linepos()}
+	p.token.Next() // if
+	stmt.Cond = p.expression()
+	p.condition(stmt.Cond, "Then part is always executed", "Never entering the then part")
+	p.expect("$THEN", "then expected", followLine)
+	stmt.Then = p.code_block(lvl + 1)
+	typ := p.token.Next()
 	for typ == "$ELIF" { // elif blocks
-		em.GenGoto(lfin)
-		em.GenLoc(lels)
-		lels = em.GenLabel()
-		syncStartBlock(lvl)
-		em.GenLine(token.GetLineCol()) //!
-		etyp, eval = expression()
-		if etyp != "$BOOL" {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- Non boolean condition", l, c)
-			errcount++
-		} else if eval == em.TRUE {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- Else if part is always executed", l, c)
-			errcount++
-		} else if eval == em.FALSE {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- This else if part is never entered", l, c)
-			errcount++
-		}
-		em.GenCond(lels, eval)
-		expect("$THEN", "then of elif expected") // or skip("$THEN")
-		code_block(lvl + 1)
-		typ = token.Next()
+		p.syncStartBlock(lvl)
+		elif := &ast.Elif{Pos: p.linepos()}
+		elif.Cond = p.expression()
+		p.condition(elif.Cond, "Else if part is always executed", "This else if part is never entered")
+		p.expect("$THEN", "then of elif expected", followLine)
+		elif.Body = p.code_block(lvl + 1)
+		stmt.Elifs = append(stmt.Elifs, elif)
+		typ = p.token.Next()
 	}
 	if typ == "$ELSE" { // else block
-		em.GenGoto(lfin)
-		em.GenLoc(lels)
-		em.GenLine(token.GetLineCol()) //!
-		syncStartBlock(lvl)
-		code_block(lvl + 1)
-	} else {
-		em.GenLoc(lels)
+		stmt.Else = &ast.Else{Pos: p.linepos()}
+		p.syncStartBlock(lvl)
+		stmt.Else.Body = p.code_block(lvl + 1)
+	} else { // endif, or the next statement
+		p.token.PushBack()
 	}
-	em.GenLoc(lfin)
-	em.GenLine(token.GetLineCol()) //!
-	if skip("$ENDIF") {            // optional
-		em.GenLine(token.GetLineCol()) //!
+	stmt.End = p.linepos()
+	if p.skip("$ENDIF") { // optional
+		pos := p.linepos()
+		stmt.EndIf = &pos
 	}
-	// log.Print("endif", token.Typ, token.Val, token.Cno)
+	// p.log.Print("endif", p.token.Typ, p.token.Val, p.token.Cno)
+	return stmt
 }
 
 /* case expr of {expr : code_list}* [otherwise code_list] endcase
    labels either "expr : ...", "expr ) ...", or "expr :) ..."
 */
-func case_stmt(lvl int) {
-	// log.Print("case stmt")
-	expect("$CASE", "case expected")
-	ctyp, cval := expression()
-	if cval != em.EMPTY {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Useless switch/case, has constant expression", l, c)
-		// errcount++
-		em.GenConst(ctyp, cval)
-	}
-	lfin := em.GenLabel()
-	expect("$OF", "of expected")
-	for isStartExpression() {
-		em.GenLine(token.GetLineCol()) //!
-		em.GenDup()                    // make a copy of case expression, vs. label expression
-		ltyp, lval := expression()
-		if ltyp != ctyp {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v.%v -- mismatch case label type", l, c)
-			errcount++
-		} else if lval == em.EMPTY {
-			l, c := token.GetLineCol()
-			log.Printf("DAP.p %v:%v -- Should have constant value as label", l, c)
-			// errcount++
-		}
-		lnex := em.GenLabel()
-		em.GenCase(lnex, ltyp, lval)
-
-		skip("$COLON")
-		skip("$RIGHTPAR")
-		code_block(lvl + 1)
-		em.GenGoto(lfin)
-		em.GenLoc(lnex)
-	}
-	if token.Typ == "$DEFAULT" { // default block
-		em.GenLine(token.GetLineCol()) //!
-		skip("$DEFAULT")
-		skip("$COLON")
-		skip("$RIGHTPAR")
-		code_block(lvl + 1)
-	}
-	em.GenLoc(lfin)
-	em.GenPop()
-	if skip("$ENDCASE") { // optional
-		em.GenLine(token.GetLineCol()) //!
-	}
-}
-
-var tab = 0
-
-func syncStartBlock(lvl int) {
-	// log.Print("starting new block ", token.Typ)
-	if !token.First {
-		for !token.First {
-			token.Next()
-		}
-		token.PushBack()
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Must be at the beginning of line", l, c)
-		errcount++
-	} else if tab == 0 && lvl == 1 {
-		tab = token.Cno
-	} else if lvl*tab != token.Cno {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Inconsistent block indentation %v vs. %v", l, c, lvl*tab, token.Cno)
-		errcount++
-	}
-}
-
-func checkBlockLevel(sp, lvl int) {
-	if tab == 0 && lvl == 1 {
-		tab = sp
-	} else if lvl*tab != sp {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Inconsistent block indentation %v vs. %v", l, c, lvl*tab, sp)
-		errcount++
+func (p *Parser) case_stmt(lvl int) *ast.Case {
+	// p.log.Print("case stmt")
+	stmt := &ast.Case{Pos: p.linepos()}
+	p.token.Next() // case
+	stmt.X = p.expression()
+	if stmt.X.Value() != em.EMPTY {
+		p.report(atPos(start(stmt.X)).Warnf("P213", "Useless switch/case, has constant expression"))
+	}
+	p.expect("$OF", "of expected", followLine)
+	for p.isStartExpression() {
+		label := &ast.CaseLabel{Pos: p.linepos()}
+		label.X = p.expression()
+		if label.X.Type() != stmt.X.Type() {
+			p.report(atPos(start(label.X)).Errorf("P214", "Mismatch case label type"))
+		} else if label.X.Value() == em.EMPTY {
+			p.report(atPos(start(label.X)).Warnf("P215", "Should have constant value as label"))
+		}
+		p.skip("$COLON")
+		p.skip("$RIGHTPAR")
+		label.Body = p.code_block(lvl + 1)
+		stmt.Labels = append(stmt.Labels, label)
+	}
+	if p.token.Typ == "$DEFAULT" { // default block
+		stmt.Default = &ast.Else{Pos: p.linepos()}
+		p.skip("$DEFAULT")
+		p.skip("$COLON")
+		p.skip("$RIGHTPAR")
+		stmt.Default.Body = p.code_block(lvl + 1)
+	}
+	if p.skip("$ENDCASE") { // optional
+		pos := p.linepos()
+		stmt.EndCase = &pos
+	}
+	return stmt
+}
+
+/* true if the rest of the line is skipped
+ */
+func (p *Parser) syncStartBlock(lvl int) bool {
+	// p.log.Print("starting new block ", p.token.Typ)
+	if !p.token.First {
+		p.report(p.at().Errorf("P107", "Must be at the beginning of line"))
+		for !p.token.First {
+			p.token.Next()
+		}
+		p.token.PushBack()
+		return true
+	} else if p.tab == 0 && lvl == 1 {
+		p.tab = p.token.Cno
+	} else if lvl*p.tab != p.token.Cno {
+		p.report(p.at().Errorf("P108", "Inconsistent block indentation %v vs. %v", lvl*p.tab, p.token.Cno))
+	}
+	return false
+}
+
+func (p *Parser) checkBlockLevel(sp, lvl int) {
+	if p.tab == 0 && lvl == 1 {
+		p.tab = sp
+	} else if lvl*p.tab != sp {
+		p.report(p.at().Errorf("P108", "Inconsistent block indentation %v vs. %v", lvl*p.tab, sp))
 	}
 }
 
 // tab decreases, at least (can be more) by one tab
-func upLevel(sp, lvl int) bool {
-	return (lvl-1)*tab >= sp
+func (p *Parser) upLevel(sp, lvl int) bool {
+	return (lvl-1)*p.tab >= sp
 }
 
 /* code CR { code CR }*
  */
-func code_block(lvl int) {
-	// log.Print("block of codes ", lvl)
+func (p *Parser) code_block(lvl int) (stmts []ast.Stmt) {
+	// p.log.Print("block of codes ", lvl)
 	for {
-		typ := token.Peek()
+		typ := p.token.Peek()
 		/*
 						if !token.First {
 							for !token.First {
@@ -718,119 +892,229 @@ func code_block(lvl int) {
 							checkBlockLevel(token.Cno, lvl)
 						}
 		*/
-		// log.Print("indent", token.Cno, lvl, token.Typ, token.Val)
-		if typ == "$ENDPROG" || upLevel(token.Cno, lvl) {
+		// p.log.Print("indent", p.token.Cno, lvl, p.token.Typ, p.token.Val)
+		if typ == "$ENDPROG" || p.upLevel(p.token.Cno, lvl) {
 			break
 		}
-		syncStartBlock(lvl)
-		switch typ {
-		case "$NAME":
-			em.GenLine(token.GetLineCol())
-			assignment(lvl)
+		if p.syncStartBlock(lvl) { // the next line may end the block
+			continue
+		}
+		if stmt := p.statement(lvl); stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+		if p.token.Next(); !p.token.First { // the statement ends its line
+			p.report(p.at().Errorf("P110", "Unexpected %v", p.token.Val))
+			p.skipTo("", nil)
+		} else {
+			p.token.PushBack()
+		}
+	}
+	return
+}
 
-		case "$INPUT":
-			em.GenLine(token.GetLineCol())
-			input_stmt(lvl)
+/* a statement starts a line, nil if it does not
+ */
+func (p *Parser) statement(lvl int) ast.Stmt {
+	switch p.token.Peek() {
+	case "$NAME":
+		return p.assignment(lvl)
 
-		case "$OUTPUT":
-			em.GenLine(token.GetLineCol())
-			output_stmt(lvl)
+	case "$INPUT":
+		return p.input_stmt(lvl)
 
-		case "$WHILE":
-			while_stmt(lvl)
+	case "$OUTPUT":
+		return p.output_stmt(lvl)
 
-		case "$REPEAT":
-			repeat_stmt(lvl)
+	case "$WHILE":
+		return p.while_stmt(lvl)
 
-		case "$IF":
-			em.GenLine(token.GetLineCol())
-			if_stmt(lvl)
+	case "$REPEAT":
+		return p.repeat_stmt(lvl)
 
-		case "$CASE":
-			em.GenLine(token.GetLineCol())
-			case_stmt(lvl)
+	case "$IF":
+		return p.if_stmt(lvl)
 
-		default: // remove the impending token
-			token.Next()
-		}
+	case "$CASE":
+		return p.case_stmt(lvl)
+
+	default: // remove the impending token, and the rest of its line
+		p.token.Next()
+		p.report(p.at().Errorf("P110", "Unexpected %v", p.token.Val))
+		p.token.Next()
+		p.skipTo("", nil)
 	}
+	return nil
 }
 
 /* block of codes
  */
-func algorithm() {
-	// log.Print("algorithm")
-	code_block(1)
-}
-
-func programBlock() {
-	sync("$PROGRAM", "$DICT", "$ENDPROG")
-	if token.Next() != "$PROGRAM" {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Missing program header, found %v/%v", l, c, token.Val, token.Typ)
-		errcount++
-	}
-	em.GenLine(token.GetLineCol()) // trace starts from program line
-	if token.Next() != "$NAME" {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Missing program name, found %v/%v", l, c, token.Val, token.Typ)
-		errcount++
+func (p *Parser) algorithm() []ast.Stmt {
+	// p.log.Print("algorithm")
+	return p.code_block(1)
+}
+
+/* uses unit { , unit }*
+ */
+func (p *Parser) uses_list() (used []*ast.Unit) {
+	for {
+		if p.token.Next() != "$NAME" {
+			p.report(p.at().Errorf("P301", "Missing unit name, found %v", p.found()))
+			p.token.PushBack()
+			break
+		}
+		name := p.token.Val
+		p.loadUnit(name)
+		p.uses[p.unit] = append(p.uses[p.unit], name)
+		if u, ok := p.units[name]; ok {
+			used = append(used, u)
+		}
+		if p.token.Next() != "$COMMA" {
+			p.token.PushBack()
+			break
+		}
+	}
+	return
+}
+
+/* find the unit along the search path, and compile it once
+ */
+func (p *Parser) loadUnit(name string) {
+	at := p.at()
+	if done, ok := p.loaded[name]; done {
+		return
+	} else if ok {
+		p.report(at.Errorf("P302", "%v: cyclic use of unit %v", p.token.GetFile(), name))
+		return
+	}
+	fname, ok := p.scan.FindUnit(name)
+	if !ok {
+		p.report(at.Errorf("P303", "%v: unit %v not found", p.token.GetFile(), name))
+		return
+	}
+	if err := p.token.Enter(fname); err != nil {
+		p.report(at.Errorf("P304", "%v", err))
+		return
+	}
+	p.loaded[name] = false
+	used := p.unit
+	p.unit = name
+	u := &ast.Unit{Pos: ast.Pos{File: fname}, Name: name}
+	p.units[name] = u
+	p.unitlist = append(p.unitlist, u)
+	p.unitBlock(u)
+	p.unit = used
+	p.loaded[name] = true
+	p.token.Leave()
+}
+
+/* unit name [uses_list] [declaration] endunit
+ */
+func (p *Parser) unitBlock(u *ast.Unit) {
+	p.sync("$UNIT", "$DICT", "$ENDPROG")
+	if p.token.Next() != "$UNIT" {
+		p.report(p.at().Errorf("P305", "%v: missing unit header, found %v", p.token.File, p.found()))
+	}
+	u.Pos = p.tokpos()
+	if p.token.Next() != "$NAME" {
+		p.report(p.at().Errorf("P306", "%v: missing unit name, found %v", p.token.File, p.found()))
+	} else if p.token.Val != p.unit {
+		p.report(p.at().Errorf("P307", "%v: unit %v is named %v", p.token.File, p.unit, p.token.Val).WithFix(p.unit, "rename to %v", p.unit))
+	}
+	if p.skip("$USES") {
+		u.Uses = p.uses_list()
+	}
+	if typ := p.token.Peek(); typ != "$ENDUNIT" && typ != "$ENDPROG" {
+		u.Decls = p.declaration()
+	}
+	if p.token.Next() != "$ENDUNIT" {
+		p.report(p.at().Errorf("P308", "%v: missing end unit, found %v", p.token.File, p.found()))
+	}
+}
+
+func (p *Parser) programBlock() *ast.Program {
+	prog := &ast.Program{}
+	p.sync("$PROGRAM", "$DICT", "$ENDPROG")
+	if p.token.Next() != "$PROGRAM" {
+		p.report(p.at().Errorf("P102", "Missing program header, found %v", p.found()))
+	}
+	prog.Pos = p.linepos() // trace starts from program line
+	if p.token.Next() != "$NAME" {
+		p.report(p.at().Errorf("P103", "Missing program name, found %v", p.found()))
 	} else {
-		// log.Println("Parsing", token.Val)
-	}
-	// sync("$DICT", "$CODE", "$ENDPROG")
-	if typ := token.Next(); typ != "$DICT" && typ != "$GLOBAL" && typ != "$LOCAL" {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Missing data section, found %v/%v", l, c, token.Val, token.Typ)
-		errcount++
-	}
-	declaration()
-	sync("$CODE", "$NAME", "$ENDPROG")
-	if token.Next() != "$CODE" {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Missing code section, found %v/%v", l, c, token.Val, token.Typ)
-		errcount++
-	}
-	em.GenLine(token.GetLineCol()) //!
-	algorithm()
-	em.GenLine(token.GetLineCol())
-	em.GenExit()
-	sync("$ENDPROG", "$ENDPROG", "$ENDPROG")
-	if token.Next() != "$ENDPROG" {
-		l, c := token.GetLineCol()
-		log.Printf("DAP.p %v:%v -- Missing end program, found %v/%v", l, c, token.Val, token.Typ)
-		errcount++
+		prog.Name = p.token.Val
+		// p.log.Println("Parsing", p.token.Val)
+	}
+	if p.skip("$USES") {
+		prog.Uses = p.uses_list()
+	}
+	// p.sync("$DICT", "$CODE", "$ENDPROG")
+	if typ := p.token.Next(); typ != "$DICT" && typ != "$GLOBAL" && typ != "$LOCAL" {
+		p.report(p.at().Errorf("P104", "Missing data section, found %v", p.found()))
+	}
+	prog.Decls = p.declaration()
+	prog.Globals = p.loc // globals of the program and its units
+	p.sync("$CODE", "$NAME", "$ENDPROG")
+	if p.token.Next() != "$CODE" {
+		p.report(p.at().Errorf("P105", "Missing code section, found %v", p.found()))
+	}
+	prog.Code = p.linepos()
+	prog.Body = p.algorithm()
+	for typ := p.token.Peek(); typ != "$ENDPROG"; typ = p.token.Peek() { // left of the algorithm, carry on
+		if !statementStart[typ] {
+		} else if p.tab > 0 {
+			p.report(p.at().Errorf("P108", "Inconsistent block indentation %v vs. %v", p.tab, p.token.Cno))
+		} else if len(prog.Body) == 0 {
+			p.report(p.at().Errorf("P108", "The algorithm must be indented"))
+		}
+		if stmt := p.statement(1); stmt != nil {
+			prog.Body = append(prog.Body, stmt)
+		}
+		prog.Body = append(prog.Body, p.algorithm()...)
+	}
+	prog.End = p.linepos()
+	if p.token.Next() != "$ENDPROG" {
+		p.report(p.at().Errorf("P106", "Missing end program, found %v", p.found()))
 	} else {
-		// log.Println("Program ends")
+		// p.log.Println("Program ends")
 	}
+	prog.Units = p.unitlist
+	return prog
 }
 
-func endParse() {
+func (p *Parser) endParse() error {
 	/*
 		for token.IsAvail() {
 			log.Printf("%v:%v: %v %v\n", token.Lno, token.Cno, token.Typ, token.Val)
 		}
 	*/
-	if err := token.Err(); err != nil {
-		log.Fatalf("DAP.p *** Fatal error %v", err)
-	}
-	toterr := token.ErrCount() + errcount
-	if toterr > 0 {
-		log.Fatalf("*** DAP compilation error count %v", toterr)
-	} else {
-		log.Printf("*** DAP compilation successful")
+	if err := p.token.Err(); err != nil {
+		p.report(p.at().Errorf("S000", "%v", err))
+		return fmt.Errorf("DAP.p *** Fatal error %v", err)
 	}
+	return nil
 }
 
-func Compile(t *scanner.Token) {
-	log.Printf("*** DAP compiling")
-	token = t
-	programBlock()
-	endParse()
+/* parse the program and the units it uses, code is generated from the tree,
+   the tree is built despite the errors, they are in the diagnostics
+*/
+func (p *Parser) Parse() (*ast.Program, error) {
+	p.log.Printf("*** DAP compiling")
+	prog := p.programBlock()
+	return prog, p.endParse()
 }
 
-func ProcessSymbols() {
-	for vname, vattr := range varcoll {
-		em.CollectVariable(vattr.parent, strings.TrimPrefix(vname, vattr.parent)[1:], vattr.typ, vattr.val, vattr.loc)
+func (p *Parser) ProcessSymbols() {
+	vnames := []string{}
+	for vname := range p.varcoll {
+		vnames = append(vnames, vname)
+	}
+	sort.Strings(vnames) // the same symbols for the same source
+	for _, vname := range vnames {
+		vattr := p.varcoll[vname]
+		name := vname[strings.LastIndex(vname, ":")+1:]
+		if vattr.unit != "" {
+			name = vattr.unit + "." + name
+		}
+		p.machine.CollectVariable(vattr.parent, name, vattr.typ, vattr.val, vattr.loc)
 	}
 }
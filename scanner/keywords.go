@@ -0,0 +1,336 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+/* a keyword profile, spellings per token type, the first one is preferred
+   a profile file is the JSON of it, e.g.
+   {"Name": "kursus", "Base": "indonesian", "Keywords": {"$WHILE": ["selama"]}}
+*/
+type Profile struct {
+	Name     string
+	Base     string
+	Keywords map[string][]string
+}
+
+var english = Profile{Name: "english", Keywords: map[string][]string{
+	"$PROGRAM":  {"program"},
+	"$DICT":     {"dictionary", "declaration"},
+	"$CODE":     {"algorithm", "pseudocode", "code"},
+	"$ENDPROG":  {"endprogram"},
+	"$INPUT":    {"input", "read"},
+	"$OUTPUT":   {"output", "write", "print"},
+	"$WHILE":    {"while"},
+	"$DO":       {"do"},
+	"$ENDWHILE": {"endwhile"},
+	"$REPEAT":   {"repeat"},
+	"$UNTIL":    {"until"},
+	"$FOR":      {"for"},
+	"$ENDFOR":   {"endfor"},
+	"$IF":       {"if"},
+	"$THEN":     {"then"},
+	"$ELSE":     {"else"},
+	"$ELIF":     {"elif", "elseif"},
+	"$ENDIF":    {"endif"},
+	"$CASE":     {"case"},
+	"$OF":       {"of"},
+	"$SWITCH":   {"switch"},
+	"$DEFAULT":  {"otherwise", "default"},
+	"$ENDCASE":  {"endcase", "endswitch"},
+	"$DIV":      {"div", "divide"},
+	"$MOD":      {"mod", "modulo"},
+	"$AND":      {"and"},
+	"$OR":       {"or"},
+	"$NOT":      {"not"},
+	"$TRUE":     {"true"},
+	"$FALSE":    {"false"},
+	"$REF":      {"ref", "io"},
+	"$VAR":      {"var", "variable"},
+	"$CONST":    {"const", "constant"},
+	"$ARRAY":    {"array"},
+	"$FUNC":     {"function"},
+	"$ENDFUNC":  {"endfunc"},
+	"$PROC":     {"procedure"},
+	"$ENDPROC":  {"endproc"},
+	"$CALL":     {"call"},
+	"$INT":      {"integer", "int"},
+	"$REAL":     {"real", "float"},
+	"$CHAR":     {"character", "char"},
+	"$BOOL":     {"boolean", "bool", "logical"},
+	"$CHARRAY":  {"string"},
+	"$LOCAL":    {"local"},
+	"$GLOBAL":   {"global"},
+	"$UNIT":     {"unit"},
+	"$ENDUNIT":  {"endunit"},
+	"$USES":     {"uses", "import"},
+}}
+
+// words without an indonesian spelling are taken as is
+var indonesian = Profile{Name: "indonesian", Keywords: map[string][]string{
+	"$PROGRAM":  {"program"},
+	"$DICT":     {"kamus", "deklarasi"},
+	"$CODE":     {"algoritma", "kode"},
+	"$ENDPROG":  {"endprogram", "endprog"},
+	"$INPUT":    {"baca"},
+	"$OUTPUT":   {"tulis"},
+	"$WHILE":    {"while"},
+	"$DO":       {"do"},
+	"$ENDWHILE": {"endwhile"},
+	"$REPEAT":   {"repeat"},
+	"$UNTIL":    {"until"},
+	"$FOR":      {"for"},
+	"$ENDFOR":   {"endfor"},
+	"$IF":       {"if"},
+	"$THEN":     {"then"},
+	"$ELSE":     {"else"},
+	"$ELIF":     {"elif", "elseif"},
+	"$ENDIF":    {"endif"},
+	"$CASE":     {"case"},
+	"$OF":       {"of"},
+	"$SWITCH":   {"switch"},
+	"$DEFAULT":  {"otherwise", "default"},
+	"$ENDCASE":  {"endcase", "endswitch"},
+	"$DIV":      {"div"},
+	"$MOD":      {"mod", "modulo"},
+	"$AND":      {"dan"},
+	"$OR":       {"atau"},
+	"$NOT":      {"tidak"},
+	"$TRUE":     {"benar"},
+	"$FALSE":    {"salah"},
+	"$REF":      {"inpout"},
+	"$VAR":      {"variabel"},
+	"$CONST":    {"konstan"},
+	"$ARRAY":    {"aray"},
+	"$FUNC":     {"fungsi"},
+	"$ENDFUNC":  {"endfungsi"},
+	"$PROC":     {"prosedur"},
+	"$ENDPROC":  {"endprosedur"},
+	"$CALL":     {"call"},
+	"$INT":      {"integer", "int"},
+	"$REAL":     {"real", "float"},
+	"$CHAR":     {"character", "char"},
+	"$BOOL":     {"boolean", "bool", "logical"},
+	"$CHARRAY":  {"string"},
+	"$LOCAL":    {"lokal"},
+	"$GLOBAL":   {"umum"},
+	"$UNIT":     {"unit"},
+	"$ENDUNIT":  {"endunit"},
+	"$USES":     {"pakai"},
+}}
+
+/* the spellings of a profile, including those of its bases
+ */
+func (s *Scanner) profileWords(name string, seen map[string]bool) map[string]string {
+	words := map[string]string{}
+	p, ok := s.profiles[name]
+	if !ok || seen[name] {
+		return words
+	}
+	seen[name] = true
+	for w, typ := range s.profileWords(p.Base, seen) {
+		words[w] = typ
+	}
+	for typ, spellings := range p.Keywords {
+		for _, w := range spellings {
+			words[w] = typ
+		}
+	}
+	return words
+}
+
+/* read a profile file, name it after the file if it has no name
+ */
+func (s *Scanner) LoadProfile(fname string) (string, error) {
+	var p Profile
+	if data, err := ioutil.ReadFile(fname); err != nil {
+		return "", err
+	} else if err := json.Unmarshal(data, &p); err != nil {
+		return "", fmt.Errorf("%v: %v", fname, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(fname), filepath.Ext(fname))
+	}
+	if p.Base != "" {
+		if _, ok := s.profiles[p.Base]; !ok {
+			return "", fmt.Errorf("%v: unknown base profile %v", fname, p.Base)
+		}
+	}
+	if _, ok := s.profiles[p.Name]; !ok {
+		s.profileOrder = append(s.profileOrder, p.Name)
+	}
+	s.profiles[p.Name] = p
+	s.UseProfile(s.active, s.strict)
+	return p.Name, nil
+}
+
+/* select the keywords, "" accepts those of every profile
+   strict rejects the keywords found only in other profiles
+*/
+func (s *Scanner) UseProfile(name string, isStrict bool) error {
+	if _, ok := s.profiles[name]; !ok && name != "" {
+		return fmt.Errorf("unknown keyword profile %v", name)
+	}
+	s.anyword = map[string]string{}
+	s.wordFrom = map[string]string{}
+	for _, pname := range s.profileOrder {
+		for w, typ := range s.profileWords(pname, map[string]bool{}) {
+			if _, ok := s.anyword[w]; !ok {
+				s.anyword[w] = typ
+				s.wordFrom[w] = pname
+			}
+		}
+	}
+	if name == "" {
+		s.keywords = s.anyword
+	} else {
+		s.keywords = s.profileWords(name, map[string]bool{})
+	}
+	s.active = name
+	s.strict = isStrict && name != ""
+	return nil
+}
+
+/* the profile given by a file name or a profile name
+ */
+func (s *Scanner) SelectProfile(nameOrFile string, isStrict bool) error {
+	name := nameOrFile
+	if strings.HasSuffix(nameOrFile, ".json") {
+		var err error
+		if name, err = s.LoadProfile(nameOrFile); err != nil {
+			return err
+		}
+	}
+	return s.UseProfile(name, isStrict)
+}
+
+/* preferred spelling of a token type, in the active profile,
+   the assignment as the program already spells it,
+   the other symbols as they are usually written
+*/
+func (s *Scanner) Spelling(typ string) string {
+	switch typ {
+	case "$ASSG":
+		if s.usedAssg != 0 {
+			return strings.Trim(assgs[s.usedAssg], "'")
+		}
+		return "<-"
+	case "$LEFTPAR":
+		return "("
+	case "$RIGHTPAR":
+		return ")"
+	case "$COLON":
+		return ":"
+	case "$COMMA":
+		return ","
+	case "$LT":
+		return "<"
+	case "$LEQ":
+		return "<="
+	case "$GT":
+		return ">"
+	case "$GEQ":
+		return ">="
+	case "$EQ":
+		return "=="
+	case "$NEQ":
+		return "<>"
+	case "$PLUS":
+		return "+"
+	case "$MINUS":
+		return "-"
+	case "$MULT":
+		return "*"
+	}
+	name := s.active
+	if name == "" {
+		name = "english"
+	}
+	for seen := map[string]bool{}; name != "" && !seen[name]; name = s.profiles[name].Base {
+		seen[name] = true
+		if spellings := s.profiles[name].Keywords[typ]; len(spellings) > 0 {
+			return spellings[0]
+		}
+	}
+	return ""
+}
+
+// word is a keyword of any profile, so it cannot be a name
+func (s *Scanner) IsKeyword(word string) bool {
+	return s.anyword[word] != ""
+}
+
+// the keywords of the active profile, sorted, english when none is active, as Spelling
+func (s *Scanner) Keywords() []string {
+	name := s.active
+	if name == "" {
+		name = "english"
+	}
+	words := []string{}
+	for w := range s.profileWords(name, map[string]bool{}) {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	return words
+}
+
+func (s *Scanner) ActiveProfile() string {
+	return s.active
+}
+
+// token type of an operator or a keyword, "" for a name, a number, or a string
+func (s *Scanner) TokenType(word string) string {
+	return s.symbol(word, false)
+}
+
+/* token type of an operator or a keyword, keywords of other profiles
+   are still accepted, but not in the strict mode
+*/
+func (s *Scanner) symbol(word string, report bool) string {
+	if typ, ok := symbols[word]; ok {
+		return typ
+	}
+	if typ, ok := s.keywords[word]; ok {
+		return typ
+	}
+	typ := s.anyword[word]
+	if typ != "" && s.strict && report {
+		d := s.at(utf8.RuneCountInString(word)).Errorf("S004", "Keyword '%v' is %v, not %v", word, s.wordFrom[word], s.active)
+		if w := s.Spelling(typ); w != "" {
+			d = d.WithFix(w, "use '%v'", w)
+		}
+		s.diags.Add(d)
+	}
+	return typ
+}
+
+/* {$keywords name [strict]} at the start of a comment selects the profile,
+   a profile file is relative to the source file
+*/
+func (s *Scanner) pragma(text []byte) {
+	name, isStrict, ok := ParsePragma(string(text))
+	if !ok {
+		return
+	}
+	if strings.HasSuffix(name, ".json") && !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(s.filename), name)
+	}
+	if err := s.SelectProfile(name, isStrict); err != nil {
+		s.diags.Add(s.at(0).Errorf("S005", "%v", err))
+	}
+}
+
+// the profile named by the text of a comment, if it is a pragma
+func ParsePragma(text string) (name string, isStrict bool, ok bool) {
+	fields := strings.Fields(strings.Trim(text, "{}()*/ \t"))
+	if len(fields) < 2 || fields[0] != "$keywords" {
+		return "", false, false
+	}
+	return fields[1], len(fields) > 2 && fields[2] == "strict", true
+}
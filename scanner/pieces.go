@@ -0,0 +1,107 @@
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+	"dap/diag"
+)
+
+// a piece of a source, the pieces of a source in a row are the source
+type Piece struct {
+	Kind string // keyword, name, number, string, comment, operator, or space
+	Text string // as written
+	Body string // of a comment or a string, without its delimiters
+	Type string // token type of a keyword or an operator
+	Line int    // where it starts, from 1
+}
+
+// how a comment or a string closes, by how it opens, a comment // at the end of its line
+var Closers = map[string]string{"{": "}", "//": "", "(*": "*)", "/*": "*/", "'": "'", `"`: `"`, "‘": "’", "“": "”"}
+
+/* the pieces of src as the scanner splits it, with the comments and
+   the spaces it skips, for a formatter and a highlighter, a comment
+   selects the keywords as it does for the parser, name is the file
+   src comes from, for a profile file of a pragma
+*/
+func Pieces(name string, src []byte) ([]Piece, error) {
+	s := New(name, bytes.NewReader(nil), nil, diag.NewList(nil))
+	text := string(src)
+	pieces := []Piece{}
+	last, no := 0, 1
+	add := func(p Piece, end int) {
+		p.Text, p.Line = text[last:end], no
+		pieces = append(pieces, p)
+		no += strings.Count(p.Text, "\n")
+		last = end
+	}
+	comment := -1 // where the comment being skipped starts
+	for pos := 0; pos < len(src); {
+		advance, token, _ := s.tokenString(src[pos:], false)
+		if s.closed >= 0 {
+			add(commentPiece(text[comment:pos+s.closed]), pos+s.closed)
+			comment = -1
+		}
+		if token == nil { // spaces up to the end
+			break
+		}
+		if s.tokStart >= 0 {
+			start, end := pos+s.tokStart, pos+advance
+			if start > last {
+				add(Piece{Kind: "space"}, start)
+			}
+			if string(token) == string(COMMENT) && s.comment > 0 {
+				comment = start
+			} else if p, ok := s.piece(text[start:end]); ok {
+				add(p, end)
+			} else {
+				return nil, fmt.Errorf("%v:%v: string is not closed", name, no)
+			}
+		}
+		pos += advance
+	}
+	if comment >= 0 {
+		return nil, fmt.Errorf("%v:%v: comment is not closed", name, 1+strings.Count(text[:comment], "\n"))
+	}
+	if last < len(text) {
+		add(Piece{Kind: "space"}, len(text))
+	}
+	return pieces, nil
+}
+
+// a token as a piece, false if it is a string not closed on its line
+func (s *Scanner) piece(word string) (Piece, bool) {
+	r, size := utf8.DecodeRuneInString(word)
+	switch {
+	case isLetter(r):
+		if typ := s.symbol(word, false); typ != "" {
+			return Piece{Kind: "keyword", Type: typ}, true
+		}
+		return Piece{Kind: "name"}, true
+	case Closers[word[:size]] != "" && word[:size] != "{":
+		closer := Closers[word[:size]]
+		if len(word) < size+len(closer) || !strings.HasSuffix(word, closer) || strings.ContainsAny(word, "\r\n") {
+			return Piece{}, false
+		}
+		return Piece{Kind: "string", Body: word[size : len(word)-len(closer)]}, true
+	case word != "-" && (isDigit(word[0]) || word[0] == '-' || isDot([]byte(word))):
+		return Piece{Kind: "number"}, true
+	}
+	return Piece{Kind: "operator", Type: s.symbol(word, false)}, true
+}
+
+// a comment from its opening to its closing
+func commentPiece(text string) Piece {
+	style := text[:1]
+	if style != "{" {
+		style = text[:2]
+	}
+	body := text[len(style):]
+	if style == "{" {
+		body = strings.TrimSuffix(body, "}")
+	} else if style != "//" {
+		body = body[:len(body)-2] // either *) or */
+	}
+	return Piece{Kind: "comment", Body: body}
+}
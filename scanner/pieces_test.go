@@ -0,0 +1,77 @@
+package scanner_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/scanner"
+)
+
+// the pieces of each source of testdata, in a row, are the source
+func TestPiecesAreSource(t *testing.T) {
+	files := []string{}
+	filepath.Walk(filepath.Join("..", "testdata"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".dap") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if len(files) == 0 {
+		t.Fatal("no sources in testdata")
+	}
+	for _, f := range files {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pieces, err := scanner.Pieces(f, src)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		text := ""
+		for _, p := range pieces {
+			text += p.Text
+		}
+		if text != string(src) {
+			t.Errorf("%v: the pieces are not the source", f)
+		}
+	}
+}
+
+func TestPieces(t *testing.T) {
+	for _, c := range []struct {
+		src, want string // each piece as kind:text
+	}{
+		{"x <- n-1", "name:x space:  operator:<- space:  name:n number:-1"},
+		{"endprogram 12", "keyword:endprogram space:  number:12"},
+		{"a{b}{c\n d}  e", "name:a comment:{b} comment:{c\n d} space:   name:e"},
+		{"(* a *) // b\r\n  “c” 'd'", "comment:(* a *) space:  comment:// b space:\r\n   string:“c” space:  string:'d'"},
+		{"1.5 .5 .. ...", "number:1.5 space:  number:.5 space:  operator:.. space:  operator:..."},
+	} {
+		pieces, err := scanner.Pieces("", []byte(c.src))
+		if err != nil {
+			t.Errorf("%q: %v", c.src, err)
+			continue
+		}
+		got := []string{}
+		for _, p := range pieces {
+			got = append(got, p.Kind+":"+p.Text)
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("%q: pieces\n%v\nwant\n%v", c.src, strings.Join(got, " "), c.want)
+		}
+	}
+	for src, want := range map[string]string{
+		"program x\n{ open\n":  ":2: comment is not closed",
+		"output 'a\nb'\n":      ":1: string is not closed",
+		"output 'a":            ":1: string is not closed",
+		"x <- 1 (* a *) /* b ": ":1: comment is not closed",
+	} {
+		if _, err := scanner.Pieces("", []byte(src)); err == nil || err.Error() != want {
+			t.Errorf("%q: error %v, want %v", src, err, want)
+		}
+	}
+}
@@ -3,8 +3,14 @@ package scanner
 import (
 	"bufio"
 	"errors"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"dap/diag"
 )
 
 const TAB = 8
@@ -15,186 +21,145 @@ const STAR2_COMMENT = 4 // /* */
 const ARROW_ASSG = 1    // <-
 const COLON_ASSG = 2    // :=
 const NORM_ASSG = 3     // =
+const UNI_ASSG = 4      // ←
 
 var COMMENT = []byte("_COMMENT_")
 var comments = []string{"", "{ ... }", "// ... EOL", "(* ... *)", "/* ... */"}
-var assgs = []string{"", "'<-'", "':='", "'='"}
+var assgs = []string{"", "'<-'", "':='", "'='", "'←'"}
 
 var ErrMixComment = errors.New("DAP: Different comment styles")
-var usedComment = 0
 
 var ErrMixQuote = errors.New("DAP: Different string styles")
-var usedQuote = 0 // one quote or double quote
-
-var usedAssg = 0 // <- := or = as assignment
 
+// operators and scanner internals, keywords are in profiles
 var symbols = map[string]string{
-	"program":     "$PROGRAM",
-	"dictionary":  "$DICT",
-	"declaration": "$DICT",
-	"pseudocode":  "$CODE",
-	"code":        "$CODE",
-	"algorithm":   "$CODE",
-	"endprogram":  "$ENDPROG",
-	"input":       "$INPUT",
-	"output":      "$OUTPUT",
-	"while":       "$WHILE",
-	"do":          "$DO",
-	"endwhile":    "$ENDWHILE",
-	"repeat":      "$REPEAT",
-	"until":       "$UNTIL",
-	"for":         "$FOR",
-	"endfor":      "$ENDFOR",
-	"if":          "$IF",
-	"then":        "$THEN",
-	"else":        "$ELSE",
-	"elif":        "$ELIF",
-	"elseif":      "$ELIF",
-	"endif":       "$ENDIF",
-	"case":        "$CASE",
-	"of":          "$OF",
-	"switch":      "$SWITCH",
-	"default":     "$DEFAULT",
-	"otherwise":   "$DEFAULT",
-	"endcase":     "$ENDCASE",
-	"endswitch":   "$ENDCASE",
-	"div":         "$DIV",
-	"mod":         "$MOD",
-	"and":         "$AND",
-	"or":          "$OR",
-	"not":         "$NOT",
-	"true":        "$TRUE",
-	"false":       "$FALSE",
-	"ref":         "$REF",
-	"io":          "$REF",
-	"var":         "$VAR",
-	"variable":    "$VAR",
-	"const":       "$CONST",
-	"constant":    "$CONST",
-	"array":       "$ARRAY",
-	"function":    "$FUNC",
-	"endfunc":     "$ENDFUNC",
-	"procedure":   "$PROC",
-	"endproc":     "$ENDPROC",
-	"call":        "$CALL",
-	"integer":     "$INT",
-	"int":         "$INT",
-	"real":        "$REAL",
-	"float":       "$REAL",
-	"character":   "$CHAR",
-	"char":        "$CHAR",
-	"boolean":     "$BOOL",
-	"bool":        "$BOOL",
-	"logical":     "$BOOL",
-	"string":      "$CHARRAY",
-	"local":       "$LOCAL",
-	"global":      "$GLOBAL",
-	"_COMMENT_":   "$COMMENT",
-	"_LINE_":      "$LINE",
-	"(":           "$LEFTPAR",
-	")":           "$RIGHTPAR",
-	"/":           "$DIV",
-	"<":           "$LT",
-	"<=":          "$LEQ",
-	"<>":          "$NEQ",
-	"<-":          "$ASSG",
-	">":           "$GT",
-	">=":          "$GEQ",
-	"><":          "$NEQ",
-	":=":          "$ASSG",
-	":":           "$COLON",
-	";":           "$SEMICOLON",
-	",":           "$COMMA",
-	"*":           "$MULT",
-	"**":          "$POWER",
-	"==":          "$EQ",
-	"=":           "$MEQ",
-	"!=":          "$NEQ",
-	"!":           "$NOT",
-	"&":           "$BAND",
-	"&&":          "$AND",
-	"|":           "$BOR",
-	"||":          "$OR",
-	"%":           "$MOD",
-	"^":           "$POWER",
-	"+":           "$PLUS",
-	"-":           "$MINUS",
-	"[":           "$LEFTBRACK",
-	"]":           "$RIGHTBRACK",
-	"..":          "$RANGE",
-	"...":         "$RANGE",
-	// "←":           "$ASSG",
-	"kamus":       "$DICT",
-	"deklarasi":   "$DICT",
-	"kode":        "$CODE",
-	"algoritma":   "$CODE",
-	"endprog":     "$ENDPROG",
-	"baca":        "$INPUT",
-	"tulis":       "$OUTPUT",
-	"read":        "$INPUT",
-	"write":       "$OUTPUT",
-	"print":       "$OUTPUT",
-	"divide":      "$DIV",
-	"modulo":      "$MOD",
-	"dan":         "$AND",
-	"atau":        "$OR",
-	"tidak":       "$NOT",
-	"benar":       "$TRUE",
-	"salah":       "$FALSE",
-	"inpout":      "$REF",
-	"aray":        "$ARRAY",
-	"fungsi":      "$FUNC",
-	"endfungsi":   "$ENDFUNC",
-	"prosedur":    "$PROC",
-	"endprosedur": "$ENDPROC",
-	"variabel":    "$VAR",
-	"konstan":     "$CONST",
-	"lokal":       "$LOCAL",
-	"umum":        "$GLOBAL",
-}
-
-var lastcol = 0
-var colno = 0
-var lineno = 0
-var comment = 0
-var errcount = 0
-
-var linecmt = 0
-
-func checkCommentStyle(comment int) {
-	if usedComment == 0 {
-		usedComment = comment
-		linecmt = lineno
-	} else if usedComment != comment {
-		log.Printf("DAP.s %v:%v -- Don't mix '%s' vs. '%s' (see line %v)", lineno, colno, comments[usedComment], comments[comment], linecmt)
-		errcount++
-	}
+	"_COMMENT_": "$COMMENT",
+	"_LINE_":    "$LINE",
+	"(":         "$LEFTPAR",
+	")":         "$RIGHTPAR",
+	"/":         "$DIV",
+	"<":         "$LT",
+	"<=":        "$LEQ",
+	"<>":        "$NEQ",
+	"<-":        "$ASSG",
+	">":         "$GT",
+	">=":        "$GEQ",
+	"><":        "$NEQ",
+	":=":        "$ASSG",
+	":":         "$COLON",
+	";":         "$SEMICOLON",
+	",":         "$COMMA",
+	"*":         "$MULT",
+	"**":        "$POWER",
+	"==":        "$EQ",
+	"=":         "$MEQ",
+	"!=":        "$NEQ",
+	"!":         "$NOT",
+	"&":         "$BAND",
+	"&&":        "$AND",
+	"|":         "$BOR",
+	"||":        "$OR",
+	"%":         "$MOD",
+	"^":         "$POWER",
+	"+":         "$PLUS",
+	"-":         "$MINUS",
+	"[":         "$LEFTBRACK",
+	"]":         "$RIGHTBRACK",
+	"..":        "$RANGE",
+	"...":       "$RANGE",
+	"←":         "$ASSG",
+	"≤":         "$LEQ",
+	"≥":         "$GEQ",
+	"≠":         "$NEQ",
+}
+
+/* scanning a source file, and the units it uses,
+   one for each compilation
+*/
+type Scanner struct {
+	SearchPath []string // directories searched for units, in order
+
+	log      *log.Logger
+	scanner  *bufio.Scanner
+	srcfile  io.Reader
+	filename string
+	lastcol  int
+	colno    int
+	lineno   int
+	comment  int
+	pushback bool
+	lastLine Token // don't return $LINE, but keep its last value
+	diags    *diag.List
+
+	atComment   bool // comment just opened, may carry a pragma
+	usedComment int
+	usedQuote   int // one quote, double quote, or their curly forms
+	usedAssg    int // <- := or = as assignment
+	linecmt     int
+	linestr     int
+	lineasg     int
+	keyUse      map[string]Token
+	including   []unitstate
+
+	// where the last split found its token, and closed a comment, in its data, -1 if not, for Pieces
+	tokStart int
+	closed   int
+
+	profiles     map[string]Profile
+	profileOrder []string
+	keywords     map[string]string // word: type, the active profile
+	anyword      map[string]string // word: type, of every known profile
+	wordFrom     map[string]string // word: first profile having it
+	active       string
+	strict       bool
 }
 
-var linestr = 0
+/* scan src, name is the file it comes from,
+   messages go to logger, problems to diags
+*/
+func New(name string, src io.Reader, logger *log.Logger, diags *diag.List) *Scanner {
+	s := &Scanner{log: logger, srcfile: src, filename: name, keyUse: map[string]Token{}, diags: diags}
+	s.scanner = bufio.NewScanner(src)
+	s.scanner.Split(s.tokenString)
+	s.profiles = map[string]Profile{"english": english, "indonesian": indonesian}
+	s.profileOrder = []string{"english", "indonesian"}
+	s.UseProfile("", false)
+	return s
+}
 
-func checkStringStyle(quote int) {
-	if usedQuote == 0 {
-		usedQuote = quote
-		linestr = lineno
-	} else if usedQuote != quote {
-		log.Printf("DAP.s %v:%v -- Don't mix string styles, [\"] vs. ['] (see line %v)", lineno, colno, linestr)
-		errcount++
+func (s *Scanner) checkCommentStyle(style int) {
+	if s.usedComment == 0 {
+		s.usedComment = style
+		s.linecmt = s.lineno
+	} else if s.usedComment != style {
+		s.diags.Add(s.at(0).Errorf("S001", "Don't mix '%s' vs. '%s' (see line %v)", comments[s.usedComment], comments[style], s.linecmt))
 	}
 }
 
-var lineasg = 0
+func (s *Scanner) checkStringStyle(quote rune) {
+	if s.usedQuote == 0 {
+		s.usedQuote = int(quote)
+		s.linestr = s.lineno
+	} else if s.usedQuote != int(quote) {
+		s.diags.Add(s.at(1).Errorf("S002", "Don't mix string styles, [%c] vs. [%c] (see line %v)", s.usedQuote, quote, s.linestr))
+	}
+}
 
-func checkAssgStyle(assg int) {
-	if usedAssg == 0 {
-		usedAssg = assg
-		lineasg = lineno
-	} else if usedAssg != assg {
-		log.Printf("DAP.s %v:%v -- Don't mix assignment styles, %s vs. %s (see line %v)", lineno, colno, assgs[usedAssg], assgs[assg], lineasg)
-		errcount++
+func (s *Scanner) checkAssgStyle(assg int) {
+	if s.usedAssg == 0 {
+		s.usedAssg = assg
+		s.lineasg = s.lineno
+	} else if s.usedAssg != assg {
+		d := s.at(utf8.RuneCountInString(strings.Trim(assgs[assg], "'"))).Errorf("S003", "Don't mix assignment styles, %s vs. %s (see line %v)", assgs[s.usedAssg], assgs[assg], s.lineasg)
+		s.diags.Add(d.WithFix(strings.Trim(assgs[s.usedAssg], "'"), "use %s", assgs[s.usedAssg]))
 	}
 }
 
+// where the scanner is, length characters from there
+func (s *Scanner) at(length int) diag.Diagnostic {
+	return diag.At(s.filename, s.lineno, s.colno, length)
+}
+
 func isEOL(data byte) bool {
 	return data == '\r' || data == '\n'
 }
@@ -203,27 +168,28 @@ func isWhitespace(data byte) bool {
 	return data == ' ' || data == '\t'
 }
 
-func isLetter(data byte) bool {
-	return data == '_' || (data >= '@' && data <= 'Z') || (data >= 'a' && data <= 'z')
+func isLetter(r rune) bool {
+	return r == '_' || (r >= '@' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= utf8.RuneSelf && unicode.IsLetter(r))
 }
 
 func isDigit(data byte) bool {
 	return data >= '0' && data <= '9'
 }
 
-func isLetterDigit(data byte) bool {
-	return (data == '_') || (data >= '0' && data <= '9') || (data >= '@' && data <= 'Z') || (data >= 'a' && data <= 'z')
+func isLetterDigit(r rune) bool {
+	return (r >= '0' && r <= '9') || isLetter(r)
 }
 
 func isDot(data []byte) bool {
 	return data[0] == '.' && (len(data) == 1 || data[1] != '.')
 }
 
-func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error) {
+func (s *Scanner) tokenString(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	advance = 0
 	token = nil
 	err = nil
-	colno = lastcol
+	s.colno = s.lastcol
+	s.tokStart, s.closed = -1, -1
 
 	// reaching end of file
 	if atEOF {
@@ -235,20 +201,27 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 	len := len(data)
 
 	// comment area, skipping characters
-	if comment > 0 {
-		switch comment {
+	if s.comment > 0 {
+		if s.atComment {
+			s.atComment = false
+			eol := 0
+			for ; eol < len && !isEOL(data[eol]); eol++ {
+			}
+			s.pragma(data[:eol])
+		}
+		switch s.comment {
 		case CURLY_COMMENT:
 			for ; skip < len && !isEOL(data[skip]) && data[skip] != '}'; skip++ {
 			}
 			if skip < len && data[skip] == '}' {
 				skip++
-				comment = 0
+				s.comment = 0
 			}
 
 		case SLASH_COMMENT:
 			for ; skip < len && !isEOL(data[skip]); skip++ {
 			}
-			comment = 0
+			s.comment = 0
 
 		case STAR1_COMMENT, STAR2_COMMENT:
 			endstarcomment := false
@@ -256,12 +229,15 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 				if data[skip] == '*' {
 					if skip+1 < len && (data[skip+1] == '/' || data[skip+1] == ')') {
 						endstarcomment = true
-						comment = 0
+						s.comment = 0
 						skip++
 					}
 				}
 			}
 		}
+		if s.comment == 0 {
+			s.closed = skip
+		}
 	}
 
 	// after skipping whitespaces, collect a token
@@ -269,9 +245,9 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 	}
 
 	// newline was found or file started, count spaces
-	if (skip < len && isEOL(data[skip])) || lineno == 0 {
-		colno = 0
-		lineno++
+	if (skip < len && isEOL(data[skip])) || s.lineno == 0 {
+		s.colno = 0
+		s.lineno++
 		for ; skip < len && data[skip] == '\r'; skip++ {
 		}
 		if skip < len && data[skip] == '\n' {
@@ -287,19 +263,24 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 		}
 		advance = skip
 		token = []byte("_LINE_")
-		lastcol = spaces
+		s.lastcol = spaces
 		return
 	}
 
 	if skip >= len {
-		lastcol = skip
+		s.lastcol = utf8.RuneCount(data[:skip])
 		return
 	}
 	tstart := skip
-	colno += tstart
+	s.tokStart = tstart
+	s.colno += utf8.RuneCount(data[:tstart]) // characters, not bytes
+	r, size := utf8.DecodeRune(data[skip:])
 	switch {
-	case isLetter(data[skip]):
-		for skip++; skip < len && isLetterDigit(data[skip]); skip++ {
+	case isLetter(r):
+		for skip += size; skip < len; skip += size {
+			if r, size = utf8.DecodeRune(data[skip:]); !isLetterDigit(r) {
+				break
+			}
 		}
 		advance = skip
 		token = data[tstart:skip]
@@ -310,7 +291,7 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 		if skip < len && (isDigit(data[skip]) || data[skip] == '.') {
 			for ; skip < len && isDigit(data[skip]); skip++ {
 			}
-			if !realnum && data[skip] == '.' {
+			if !realnum && skip < len && data[skip] == '.' {
 				for skip++; skip < len && isDigit(data[skip]); skip++ {
 				}
 			}
@@ -319,10 +300,11 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 		token = data[tstart:skip]
 
 	case data[skip] == '{': // { or (* or // or /*
-		comment = CURLY_COMMENT
+		s.comment = CURLY_COMMENT
 		advance = skip
 		token = []byte("_COMMENT_")
-		checkCommentStyle(comment)
+		s.atComment = true
+		s.checkCommentStyle(s.comment)
 
 	case data[skip] == '/': // / or // or /*
 		skip++
@@ -331,24 +313,27 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 
 		if skip >= len {
 		} else if data[skip] == '/' {
-			comment = SLASH_COMMENT
+			s.comment = SLASH_COMMENT
 			advance = skip + 1
 			token = []byte("_COMMENT_")
-			checkCommentStyle(comment)
+			s.atComment = true
+			s.checkCommentStyle(s.comment)
 		} else if data[skip] == '*' {
-			comment = STAR2_COMMENT
+			s.comment = STAR2_COMMENT
 			advance = skip + 1
 			token = []byte("_COMMENT_")
-			checkCommentStyle(comment)
+			s.atComment = true
+			s.checkCommentStyle(s.comment)
 		}
 
 	case data[skip] == '(': // ( (*
 		skip++
 		if skip < len && data[skip] == '*' {
-			comment = STAR1_COMMENT
+			s.comment = STAR1_COMMENT
 			advance = skip + 1
 			token = COMMENT
-			checkCommentStyle(comment)
+			s.atComment = true
+			s.checkCommentStyle(s.comment)
 		} else {
 			advance = skip
 			token = data[skip-1 : skip]
@@ -362,7 +347,7 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 		}
 		advance = skip
 		token = data[tstart : skip-1]
-		checkStringStyle('"')
+		s.checkStringStyle('"')
 
 	case data[skip] == '\'': // "string '"
 		for skip++; skip < len && data[skip] != '\''; skip++ {
@@ -372,24 +357,37 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 		}
 		advance = skip
 		token = data[tstart : skip-1]
-		checkStringStyle('\'')
+		s.checkStringStyle('\'')
+
+	case r == '“', r == '‘': // “string” ‘string’, as if "string" 'string'
+		closing, quote := '”', byte('"')
+		if r == '‘' {
+			closing, quote = '’', '\''
+		}
+		for skip += size; skip < len; skip += size {
+			var c rune
+			if c, size = utf8.DecodeRune(data[skip:]); c == closing {
+				break
+			}
+		}
+		token = append([]byte{quote}, data[tstart+utf8.RuneLen(r):skip]...)
+		if skip < len {
+			skip += size
+		}
+		advance = skip
+		s.checkStringStyle(r)
+
+	case r == '←': // ← assignment as in textbooks
+		s.checkAssgStyle(UNI_ASSG)
+		skip += size
+		advance = skip
+		token = data[tstart:skip]
 
-		/*
-			case data[skip] == '“': // "string “"
-				for skip++; skip < len && data[skip] != '"'; skip++ {
-				}
-				if skip < len {
-					skip++
-				}
-				advance = skip
-				token = data[tstart : skip-1]
-				checkStringStyle('“')
-		*/
 	case data[skip] == '<': // <- <= <> <
 		skip++
 		if skip >= len {
 		} else if data[skip] == '-' { // context sensitive, assgn struct only
-			checkAssgStyle(ARROW_ASSG)
+			s.checkAssgStyle(ARROW_ASSG)
 			skip++
 		} else if data[skip] == '=' || data[skip] == '>' {
 			skip++
@@ -410,7 +408,7 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 		skip++
 		if skip >= len {
 		} else if data[skip] == '=' {
-			checkAssgStyle(COLON_ASSG)
+			s.checkAssgStyle(COLON_ASSG)
 			skip++
 		}
 		advance = skip
@@ -476,75 +474,66 @@ func tokenString(data []byte, atEOF bool) (advance int, token []byte, err error)
 		advance = skip
 		token = data[tstart:skip]
 
-	default: // just one character token: % ) ^ , + - [ ]
-		skip++
+	default: // just one character token: % ) ^ , + - [ ] ≤ ≥ ≠
+		skip += size
 		advance = skip
 		token = data[tstart:skip]
 	}
-	lastcol += skip
+	s.lastcol += utf8.RuneCount(data[:skip])
 	return
 }
 
-var scanner *bufio.Scanner
-
 type Token struct {
 	Typ, Val string
 	Grp      string
+	File     string
 	Lno, Cno int
 	First    bool
+	sc       *Scanner
+	prevLno  int // where the token before ends
+	prevEnd  int
 }
 
-var keyUse = map[string]Token{}
-
-func checkKeyUse(t Token) {
-	if keyUse[t.Typ].Val == "" {
-		keyUse[t.Typ] = Token{Val: t.Val, Lno: t.Lno, Cno: t.Cno}
-	} else if keyUse[t.Typ].Val != t.Val {
-		log.Printf("DAP.s %v:%v -- Inconsistence keywords: %v vs. %v (see line %v)", lineno, colno, keyUse[t.Typ].Val, t.Val, keyUse[t.Typ].Lno)
-		// errcount++
+func (s *Scanner) checkKeyUse(t Token) {
+	if s.keyUse[t.Typ].Val == "" {
+		s.keyUse[t.Typ] = Token{Val: t.Val, Lno: t.Lno, Cno: t.Cno}
+	} else if s.keyUse[t.Typ].Val != t.Val {
+		first := s.keyUse[t.Typ]
+		d := diag.At(s.filename, t.Lno, t.Cno, utf8.RuneCountInString(t.Val)).Warnf("S006", "Inconsistence keywords: %v vs. %v (see line %v)", first.Val, t.Val, first.Lno)
+		s.diags.Add(d.WithFix(first.Val, "use '%v'", first.Val))
 	}
 }
 
-func NewToken(dapSrcFile string) *Token {
-	file, err := os.Open(dapSrcFile)
-	if err != nil {
-		panic(err)
-	}
-	// defer file.Close()
-	scanner = bufio.NewScanner(file)
-	scanner.Split(tokenString)
+// the token read from the scanner
+func (s *Scanner) Token() *Token {
 	t := new(Token)
 	t.Val = "VALUE"
 	t.Typ = "TYPE"
-	t.Lno = 9999
-	t.Cno = 8888
+	t.Lno = 1 // the start of the source, until a token is read
+	t.Cno = 0
+	t.sc = s
 	return t
 }
 
-var pushback = false
-
 func (t Token) PushBack() {
-	pushback = true
+	t.sc.pushback = true
 }
 
-/* don't return $LINE, but keep its last value
- */
-var lastLine Token
-
 func (t *Token) Next() string {
-	if pushback {
-		pushback = false
+	s := t.sc
+	if s.pushback {
+		s.pushback = false
 	} else {
-		avail := scanner.Scan()
+		avail := s.scanner.Scan()
 		newline := false
 
-		for avail && (scanner.Text() == "_LINE_" || scanner.Text() == "_COMMENT_") {
-			if scanner.Text() == "_LINE_" {
-				lastLine.Lno = lineno
-				lastLine.Cno = lastcol
+		for avail && (s.scanner.Text() == "_LINE_" || s.scanner.Text() == "_COMMENT_") {
+			if s.scanner.Text() == "_LINE_" {
+				s.lastLine.Lno = s.lineno
+				s.lastLine.Cno = s.lastcol
 				newline = true
 			}
-			avail = scanner.Scan()
+			avail = s.scanner.Scan()
 		}
 		/*
 			for avail  && scanner.Text() == "_LINE_" {
@@ -554,19 +543,23 @@ func (t *Token) Next() string {
 				avail = scanner.Scan()
 			}
 		*/
+		if t.Val != "END OF FILE" { // the end stays after the last token
+			t.prevLno, t.prevEnd = t.Lno, t.Cno+utf8.RuneCountInString(t.Val)
+		}
 		if avail {
-			t.Val = scanner.Text()
-			t.Typ = symbols[t.Val]
-			t.Lno = lineno
-			t.Cno = colno
+			t.Val = s.scanner.Text()
+			t.Typ = s.symbol(t.Val, true)
+			t.File = s.filename
+			t.Lno = s.lineno
+			t.Cno = s.colno
 			t.First = newline
 			// log.Print("tok ", t.Val, t.Typ)
 			if t.Typ != "" {
-				checkKeyUse(*t)
+				s.checkKeyUse(*t)
 			} else if t.Val == "" {
 				t.Typ = "$ENDPROG"
 				t.Val = "EMPTY PROGRAM"
-			} else if isLetter(t.Val[0]) {
+			} else if r, _ := utf8.DecodeRuneInString(t.Val); isLetter(r) {
 				t.Typ = "$NAME"
 			} else if isDigit(t.Val[0]) || t.Val[0] == '.' || t.Val[0] == '-' {
 				t.Typ = "$NUMBER"
@@ -577,6 +570,11 @@ func (t *Token) Next() string {
 		} else {
 			t.Typ = "$ENDPROG"
 			t.Val = "END OF FILE"
+			t.File = s.filename
+			t.Lno, t.Cno = s.lineno, s.lastcol // where the source ends
+			if t.Lno == 0 {                     // nothing was read
+				t.Lno = 1
+			}
 			t.First = true
 		}
 	}
@@ -589,36 +587,116 @@ func (t *Token) Peek() string {
 }
 
 func (t *Token) IsAvail() bool {
-	avail := scanner.Scan()
+	s := t.sc
+	avail := s.scanner.Scan()
 	if avail {
-		t.Val = scanner.Text()
-		t.Typ = symbols[t.Val]
-		t.Lno = lineno
+		t.Val = s.scanner.Text()
+		t.Typ = s.symbol(t.Val, false)
+		t.Lno = s.lineno
 		if t.Typ == "$LINE" {
-			t.Cno = lastcol
+			t.Cno = s.lastcol
 		} else {
-			t.Cno = colno
+			t.Cno = s.colno
 		}
 	}
 	return avail
 }
 
+// the end of the source, no token is after it
+func (t Token) AtEnd() bool {
+	return t.Typ == "$ENDPROG" && (t.Val == "END OF FILE" || t.Val == "EMPTY PROGRAM")
+}
+
 func (t Token) Text() string {
 	return t.Val
 }
 
 func (t Token) Err() error {
-	return scanner.Err()
+	return t.sc.scanner.Err()
 }
 
-func (t Token) ErrCount() int {
-	return errcount
+// where the token before this one ends
+func (t Token) After() (int, int) {
+	return t.prevLno, t.prevEnd
 }
 
 func (t Token) GetLineCol() (int, int) {
-	return lastLine.Lno, lastLine.Cno
+	return t.sc.lastLine.Lno, t.sc.lastLine.Cno
 }
 
 func (t Token) GetLine() int {
-	return lastLine.Lno
+	return t.sc.lastLine.Lno
+}
+
+func (t Token) GetFile() string {
+	return t.sc.filename
+}
+
+/* scanner state of the including file, while a unit is being scanned
+ */
+type unitstate struct {
+	scanner  *bufio.Scanner
+	srcfile  io.Reader
+	filename string
+	lastcol  int
+	colno    int
+	lineno   int
+	comment  int
+	pushback bool
+	lastLine Token
+	token    Token
+}
+
+/* find name.dap along the search path
+ */
+func (s *Scanner) FindUnit(name string) (string, bool) {
+	for _, dir := range s.SearchPath {
+		fname := filepath.Join(dir, name+".dap")
+		if info, err := os.Stat(fname); err == nil && !info.IsDir() {
+			return fname, true
+		}
+	}
+	return "", false
+}
+
+/* continue scanning from fname, until Leave
+ */
+func (t *Token) Enter(fname string) error {
+	s := t.sc
+	file, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	s.including = append(s.including, unitstate{s.scanner, s.srcfile, s.filename, s.lastcol, s.colno, s.lineno, s.comment, s.pushback, s.lastLine, *t})
+	s.srcfile = file
+	s.filename = fname
+	s.scanner = bufio.NewScanner(file)
+	s.scanner.Split(s.tokenString)
+	s.lastcol = 0
+	s.colno = 0
+	s.lineno = 0
+	s.comment = 0
+	s.atComment = false
+	s.pushback = false
+	s.lastLine = Token{}
+	return nil
+}
+
+/* back to the including file, as it was before Enter
+ */
+func (t *Token) Leave() {
+	s := t.sc
+	n := len(s.including) - 1
+	if n < 0 {
+		return
+	}
+	if f, ok := s.srcfile.(io.Closer); ok {
+		f.Close()
+	}
+	u := s.including[n]
+	s.including = s.including[:n]
+	s.scanner, s.srcfile, s.filename = u.scanner, u.srcfile, u.filename
+	s.lastcol, s.colno, s.lineno, s.comment = u.lastcol, u.colno, u.lineno, u.comment
+	s.pushback, s.lastLine = u.pushback, u.lastLine
+	*t = u.token
 }
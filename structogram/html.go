@@ -0,0 +1,84 @@
+package structogram
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+)
+
+/* the diagram as a page of nested boxes, a choice is a table,
+   the first and the last label draw the sides of its triangle
+*/
+
+const style = `body{font-family:monospace;font-size:12px}
+.ns{display:inline-block}
+.ns div,.ns table{border:1px solid #000;margin:-1px 0 0 0}
+.ns table{border-collapse:collapse;width:100%}
+.ns td{border:1px solid #000;padding:0;vertical-align:top}
+.s,.head,.cond,.lb{padding:4px 8px;white-space:pre}
+.body{margin-left:24px !important}
+.cond{text-align:center;border-bottom:none !important}
+.lb{font-size:10px;border-top:none !important}
+.t{background:linear-gradient(to top right,transparent calc(50% - 1px),#000,transparent calc(50% + 1px))}
+.f{background:linear-gradient(to top left,transparent calc(50% - 1px),#000,transparent calc(50% + 1px));text-align:right}
+`
+
+func (d *Diagram) WriteHTML(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%v</title>\n<style>\n%v</style>\n</head>\n<body>\n",
+		html.EscapeString(d.Name), style)
+	fmt.Fprintf(&buf, "<p>%v</p>\n<div class=\"ns\">\n", html.EscapeString(d.title))
+	writeSeq(&buf, d.body)
+	buf.WriteString("</div>\n</body>\n</html>\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeSeq(buf *bytes.Buffer, list []element) {
+	if len(list) == 0 {
+		buf.WriteString("<div class=\"s\"> </div>\n")
+	}
+	for _, e := range list {
+		writeElement(buf, e)
+	}
+}
+
+func writeElement(buf *bytes.Buffer, e element) {
+	switch e := e.(type) {
+	case *simple:
+		fmt.Fprintf(buf, "<div class=\"s\">%v</div>\n", html.EscapeString(e.text))
+	case *loop:
+		head := fmt.Sprintf("<div class=\"head\">%v</div>\n", html.EscapeString(e.head))
+		buf.WriteString("<div class=\"loop\">\n")
+		if !e.repeat {
+			buf.WriteString(head)
+		}
+		buf.WriteString("<div class=\"body\">\n")
+		writeSeq(buf, e.body)
+		buf.WriteString("</div>\n")
+		if e.repeat {
+			buf.WriteString(head)
+		}
+		buf.WriteString("</div>\n")
+	case *choice:
+		n := len(e.columns)
+		fmt.Fprintf(buf, "<table class=\"choice\">\n<tr><td class=\"cond\" colspan=\"%v\">%v</td></tr>\n<tr>", n, html.EscapeString(e.cond))
+		for i, col := range e.columns {
+			class := "lb"
+			if i == 0 {
+				class += " t"
+			} else if i == n-1 {
+				class += " f"
+			}
+			fmt.Fprintf(buf, "<td class=\"%v\">%v</td>", class, html.EscapeString(col.label))
+		}
+		buf.WriteString("</tr>\n<tr>\n")
+		for _, col := range e.columns {
+			buf.WriteString("<td>\n")
+			writeSeq(buf, col.body)
+			buf.WriteString("</td>\n")
+		}
+		buf.WriteString("</tr>\n</table>\n")
+	}
+}
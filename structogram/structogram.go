@@ -0,0 +1,95 @@
+package structogram
+
+import "dap/ast"
+
+/* Nassi-Shneiderman diagram of the algorithm of a program
+
+   DAP has no jumps, each statement is a box, and the boxes of a block
+   are stacked. An if has a column for each branch, elif is an if within
+   the false column, a case has a column for each label and otherwise.
+   A while has its condition above its body, a repeat below it.
+*/
+
+// an element is a *simple, *choice, or *loop
+type element interface{}
+
+type simple struct {
+	text string
+}
+
+type column struct {
+	label string
+	body  []element
+}
+
+// if and case, cond is the condition or the case selector
+type choice struct {
+	cond    string
+	columns []column
+}
+
+type loop struct {
+	head   string
+	body   []element
+	repeat bool // head below the body
+}
+
+type Diagram struct {
+	Name  string
+	title string
+	body  []element
+}
+
+// the diagram of prog, spell gives how the profile writes a token type
+func New(prog *ast.Program, spell func(typ string) string) *Diagram {
+	b := builder{spell}
+	return &Diagram{Name: prog.Name, title: spell("$PROGRAM") + " " + prog.Name, body: b.stmts(prog.Body)}
+}
+
+type builder struct {
+	spell func(string) string
+}
+
+func (b builder) expr(x ast.Expr) string {
+	return ast.ExprString(x, b.spell)
+}
+
+func (b builder) stmts(list []ast.Stmt) []element {
+	elems := []element{}
+	for _, s := range list {
+		elems = append(elems, b.stmt(s))
+	}
+	return elems
+}
+
+func (b builder) stmt(s ast.Stmt) element {
+	switch s := s.(type) {
+	case *ast.While:
+		return &loop{head: b.spell("$WHILE") + " " + b.expr(s.Cond), body: b.stmts(s.Body)}
+	case *ast.Repeat:
+		return &loop{head: b.spell("$UNTIL") + " " + b.expr(s.Cond), body: b.stmts(s.Body), repeat: true}
+	case *ast.If:
+		top := &choice{cond: b.expr(s.Cond), columns: []column{{b.spell("$TRUE"), b.stmts(s.Then)}, {b.spell("$FALSE"), []element{}}}}
+		last := top
+		for _, e := range s.Elifs {
+			next := &choice{cond: b.expr(e.Cond), columns: []column{{b.spell("$TRUE"), b.stmts(e.Body)}, {b.spell("$FALSE"), []element{}}}}
+			last.columns[1].body = []element{next}
+			last = next
+		}
+		if s.Else != nil {
+			last.columns[1].body = b.stmts(s.Else.Body)
+		}
+		return top
+	case *ast.Case:
+		c := &choice{cond: b.expr(s.X)}
+		for _, l := range s.Labels {
+			c.columns = append(c.columns, column{b.expr(l.X), b.stmts(l.Body)})
+		}
+		if s.Default != nil {
+			c.columns = append(c.columns, column{b.spell("$DEFAULT"), b.stmts(s.Default.Body)})
+		}
+		return c
+	}
+	return &simple{ast.StmtString(s, b.spell)}
+}
+
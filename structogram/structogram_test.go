@@ -0,0 +1,53 @@
+package structogram_test
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/parser"
+	"dap/structogram"
+)
+
+var update = flag.Bool("update", false, "write the .svg and .html files of testdata/structogram")
+
+// the diagram of each program of testdata/structogram, as its .svg and its .html draw it
+func TestDiagrams(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", "structogram", "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/structogram: %v", err)
+	}
+	for _, f := range files {
+		compiler := parser.Compiler{Log: ioutil.Discard}
+		prog, err := compiler.CompileFile(f)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		diagram := structogram.New(prog.Tree, prog.Spelling)
+		for ext, write := range map[string]func(w io.Writer) error{".svg": diagram.WriteSVG, ".html": diagram.WriteHTML} {
+			var got bytes.Buffer
+			if err := write(&got); err != nil {
+				t.Fatal(err)
+			}
+			golden := strings.TrimSuffix(f, ".dap") + ext
+			if *update {
+				if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Errorf("%v: %v", f, err)
+				continue
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("%v: the diagram differs from %v\nwant:\n%s\ngot:\n%s", f, filepath.Base(golden), want, got.Bytes())
+			}
+		}
+	}
+}
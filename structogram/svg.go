@@ -0,0 +1,188 @@
+package structogram
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+/* the diagram as SVG, each element is as wide as the widest
+   of its block, the columns of a choice share the width in proportion
+   to what they need, the last element of a shorter column grows
+   to the bottom of the choice
+*/
+
+const (
+	charW  = 7.2 // of the 12px monospace font
+	pad    = 8
+	rowH   = 26
+	indent = 24 // of the body of a loop
+)
+
+type svgWriter struct {
+	buf bytes.Buffer
+}
+
+func (d *Diagram) WriteSVG(w io.Writer) error {
+	s := &svgWriter{}
+	const margin = 16
+	width := math.Max(seqWidth(d.body), textWidth(d.title))
+	height := rowH + seqHeight(d.body)
+	fmt.Fprintf(&s.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f">`+"\n",
+		width+2*margin, height+2*margin, width+2*margin, height+2*margin)
+	s.buf.WriteString(`<title>` + html.EscapeString(d.Name) + "</title>\n")
+	s.buf.WriteString(`<style>text{font-family:monospace;font-size:12px} .lb{font-size:10px} rect{stroke:#000;fill:#fff} line{stroke:#000}</style>` + "\n")
+	s.text(margin, margin+rowH/2, "start", "", d.title)
+	s.seq(d.body, margin, margin+rowH, width, height-rowH)
+	s.buf.WriteString("</svg>\n")
+	_, err := w.Write(s.buf.Bytes())
+	return err
+}
+
+func textWidth(text string) float64 {
+	return float64(utf8.RuneCountInString(text))*charW + 2*pad
+}
+
+func width(e element) float64 {
+	switch e := e.(type) {
+	case *simple:
+		return textWidth(e.text)
+	case *choice:
+		w := 0.0
+		for _, c := range e.columns {
+			w += columnWidth(c)
+		}
+		return math.Max(w, textWidth(e.cond)+2*rowH)
+	case *loop:
+		return math.Max(textWidth(e.head), indent+seqWidth(e.body))
+	}
+	return 0
+}
+
+func columnWidth(c column) float64 {
+	return math.Max(seqWidth(c.body), textWidth(c.label))
+}
+
+func seqWidth(list []element) float64 {
+	w := float64(2 * pad)
+	for _, e := range list {
+		w = math.Max(w, width(e))
+	}
+	return w
+}
+
+func height(e element) float64 {
+	switch e := e.(type) {
+	case *choice:
+		h := 0.0
+		for _, c := range e.columns {
+			h = math.Max(h, seqHeight(c.body))
+		}
+		return 2*rowH + h
+	case *loop:
+		return rowH + seqHeight(e.body)
+	}
+	return rowH
+}
+
+// an empty block is an empty box
+func seqHeight(list []element) float64 {
+	if len(list) == 0 {
+		return rowH
+	}
+	h := 0.0
+	for _, e := range list {
+		h += height(e)
+	}
+	return h
+}
+
+func (s *svgWriter) rect(x, y, w, h float64) {
+	fmt.Fprintf(&s.buf, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\"/>\n", x, y, w, h)
+}
+
+func (s *svgWriter) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&s.buf, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\"/>\n", x1, y1, x2, y2)
+}
+
+func (s *svgWriter) text(x, y float64, anchor, class, text string) {
+	if class != "" {
+		class = ` class="` + class + `"`
+	}
+	fmt.Fprintf(&s.buf, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"%v\" dominant-baseline=\"middle\"%v>%v</text>\n",
+		x, y, anchor, class, html.EscapeString(text))
+}
+
+// list in the box x, y, w, h, its last element takes what is left of h
+func (s *svgWriter) seq(list []element, x, y, w, h float64) {
+	if len(list) == 0 {
+		s.rect(x, y, w, h)
+		return
+	}
+	for i, e := range list {
+		eh := height(e)
+		if i == len(list)-1 {
+			eh = h
+		}
+		s.element(e, x, y, w, eh)
+		y, h = y+eh, h-eh
+	}
+}
+
+func (s *svgWriter) element(e element, x, y, w, h float64) {
+	switch e := e.(type) {
+	case *simple:
+		s.rect(x, y, w, h)
+		s.text(x+pad, y+rowH/2, "start", "", e.text)
+	case *loop:
+		s.rect(x, y, w, h)
+		if e.repeat {
+			s.seq(e.body, x+indent, y, w-indent, h-rowH)
+			s.text(x+pad, y+h-rowH/2, "start", "", e.head)
+		} else {
+			s.text(x+pad, y+rowH/2, "start", "", e.head)
+			s.seq(e.body, x+indent, y+rowH, w-indent, h-rowH)
+		}
+	case *choice:
+		s.choice(e, x, y, w, h)
+	}
+}
+
+/* the condition is in a triangle, between the first column
+   and the last one
+*/
+func (s *svgWriter) choice(c *choice, x, y, w, h float64) {
+	s.rect(x, y, w, h)
+	need := 0.0
+	for _, col := range c.columns {
+		need += columnWidth(col)
+	}
+	widths := []float64{}
+	for _, col := range c.columns {
+		widths = append(widths, columnWidth(col)*w/need)
+	}
+	split := x + w/2
+	if n := len(widths); n > 0 {
+		split = x + w - widths[n-1]
+	}
+	s.line(x, y, split, y+2*rowH)
+	s.line(x+w, y, split, y+2*rowH)
+	tw := textWidth(c.cond)
+	s.text(math.Min(math.Max(split, x+tw/2), x+w-tw/2), y+rowH/2, "middle", "", c.cond)
+	cx := x
+	for i, col := range c.columns {
+		switch {
+		case i == 0:
+			s.text(cx+pad, y+rowH*3/2, "start", "lb", col.label)
+		case i == len(c.columns)-1:
+			s.text(cx+widths[i]-pad, y+rowH*3/2, "end", "lb", col.label)
+		default:
+			s.text(cx+widths[i]/2, y+rowH*3/2, "middle", "lb", col.label)
+		}
+		s.seq(col.body, cx, y+2*rowH, widths[i], h-2*rowH)
+		cx += widths[i]
+	}
+}
@@ -0,0 +1,317 @@
+package transpile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"dap/ast"
+	em "dap/emulator"
+)
+
+/* C99, the declarations are global, constants are macros, the algorithm
+   is main. C computes a char or a short as an int, an arithmetic result
+   is cast back to the word, and C leaves an overflow undefined, compile
+   with -fwrapv to have it wrap around as on the machine. A character is
+   a byte, one beyond ASCII is ?. A number or a boolean read also takes
+   the character after it, as the emulator does.
+*/
+
+var cNaming = naming{
+	reserved: set(
+		"auto", "break", "case", "char", "const", "continue", "default", "do", "double", "else", "enum",
+		"extern", "float", "for", "goto", "if", "inline", "int", "long", "register", "restrict", "return",
+		"short", "signed", "sizeof", "static", "struct", "switch", "typedef", "union", "unsigned", "void",
+		"volatile", "while", "bool", "true", "false", "main", "printf", "scanf", "putchar", "strcmp",
+		"EOF", "NULL", "stdin", "stdout", "char_line", "write_char", "write_number", "write_bool", "read_bool"),
+	valid: isASCII,
+}
+
+// type, scanf and printf formats of an integer of the word size
+var cInts = map[int][3]string{
+	8:  {"signed char", "%hhd", "%d"},
+	16: {"short", "%hd", "%d"},
+	32: {"int", "%d", "%d"},
+	64: {"long long", "%lld", "%lld"},
+}
+
+type cWriter struct {
+	syntax
+	source
+	names map[string]string
+	ints  [3]string
+	need  needs
+}
+
+// prog as a C program, word is the size of an integer in bits
+func C(prog *ast.Program, word int) ([]byte, error) {
+	list := declarations(prog)
+	c := &cWriter{source: source{tab: "    "}, names: cNaming.names(prog, list), ints: cInts[word], need: needed(prog)}
+	c.syntax = syntax{
+		ops: map[string]binop{
+			"$MULT": {"*", 10}, "$DIV": {"/", 10}, "$MOD": {"%", 10},
+			"$PLUS": {"+", 9}, "$MINUS": {"-", 9},
+			"$LT": {"<", 7}, "$LEQ": {"<=", 7}, "$GT": {">", 7}, "$GEQ": {">=", 7},
+			"$EQ": {"==", 6}, "$NEQ": {"!=", 6},
+			"$AND": {"&&", 5},
+			"$OR": {"||", 4},
+		},
+		neg: "-", not: "!", true: "true", false: "false",
+		char:  cChar,
+		name:  c.name,
+		group: func(op, inner string) bool { return op == "$OR" && inner == "$AND" }, // as -Wall asks
+	}
+	if word < 32 {
+		c.cast = func(inner string) string { return "(" + c.ints[0] + ")(" + inner + ")" }
+	}
+
+	c.line("/* Program %v, from %v, with the source line of each statement */", prog.Name, filepath.Base(prog.Pos.File))
+	c.line("#include <stdbool.h>")
+	c.line("#include <stdio.h>")
+	if c.need.readBool {
+		c.line("#include <string.h>")
+	}
+	c.line("")
+	computed := []decl{}
+	for _, d := range list {
+		if k, ok := d.Decl.(*ast.Const); ok && k.Sym.Val == em.EMPTY {
+			computed = append(computed, d)
+		}
+		c.decl(d)
+	}
+	c.helpers()
+	c.line("")
+	c.line("int main(void) // line %v", prog.Code.Line)
+	c.line("{")
+	c.block(append(assignments(computed), prog.Body...))
+	c.level++
+	c.line("return 0;")
+	c.level--
+	c.line("}")
+	return c.Bytes(), nil
+}
+
+func cChar(r rune) string {
+	switch {
+	case r == '\'' || r == '\\':
+		return `'\` + string(r) + `'`
+	case r == '\n':
+		return `'\n'`
+	case r == '\t':
+		return `'\t'`
+	case r >= ' ' && r < 127:
+		return "'" + string(r) + "'"
+	}
+	return "'?'"
+}
+
+func (c *cWriter) name(sym ast.Symbol) string {
+	if name, ok := c.names[key(sym.Unit, sym.Name)]; ok {
+		return name
+	}
+	return sym.Name
+}
+
+func (c *cWriter) typ(t string) string {
+	switch t {
+	case "$BOOL":
+		return "bool"
+	case "$CHAR", "$CHARRAY":
+		return "char"
+	}
+	return c.ints[0]
+}
+
+// a constant of a value known to the compiler is a macro, the others are computed first in main
+func (c *cWriter) decl(d decl) {
+	switch x := d.Decl.(type) {
+	case *ast.Const:
+		name := c.names[key(d.unit, x.Name)]
+		if x.Sym.Val == em.EMPTY {
+			c.line("%v %v; // line %v", c.typ(x.X.Type()), name, x.Line)
+		} else if value := c.expr(x.X); strings.Contains(value, " ") {
+			c.line("#define %v (%v) // line %v", name, value, x.Line)
+		} else {
+			c.line("#define %v %v // line %v", name, value, x.Line)
+		}
+	case *ast.Var:
+		if x.Type == "" {
+			return
+		}
+		names := []string{}
+		for _, id := range x.Names {
+			names = append(names, c.names[key(d.unit, id.Name)])
+		}
+		c.line("%v %v; // line %v", c.typ(x.Type), strings.Join(names, ", "), x.Line)
+	}
+}
+
+func (c *cWriter) helpers() {
+	if c.need.writeChar {
+		c.line("")
+		c.line("/* characters follow each other on a line, a number or a boolean after them starts a new line */")
+		c.line("bool char_line;")
+		c.line("")
+		c.line("void write_char(char c)\n{\n    putchar(c);\n    char_line = true;\n}")
+		c.line("")
+		c.line("void write_number(%v n)\n{\n    if (char_line)\n        putchar('\\n');\n    char_line = false;\n    printf(\"%v\\n\", n);\n}",
+			c.ints[0], c.ints[2])
+		if c.need.writeBool {
+			c.line("")
+			c.line("void write_bool(bool b)\n{\n    if (char_line)\n        putchar('\\n');\n    char_line = false;\n    printf(\"%%s\\n\", b ? \"true\" : \"false\");\n}")
+		}
+	}
+	if c.need.readBool {
+		c.line("")
+		c.line("/* true is written 1, t, T, true, TRUE, or True, as the emulator reads it */")
+		c.line("bool read_bool(void)\n{\n    char word[8] = \"\";\n    scanf(\"%%7s%%*c\", word);")
+		c.line("    return strcmp(word, \"1\") == 0 || strcmp(word, \"t\") == 0 || strcmp(word, \"T\") == 0 ||")
+		c.line("           strcmp(word, \"true\") == 0 || strcmp(word, \"TRUE\") == 0 || strcmp(word, \"True\") == 0;\n}")
+	}
+}
+
+var cNegated = map[string]string{"$LT": ">=", "$LEQ": ">", "$GT": "<=", "$GEQ": "<", "$EQ": "!=", "$NEQ": "=="}
+
+// the condition of a do while, that of until negated
+func (c *cWriter) negate(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.Binary:
+		if op, ok := cNegated[x.Op]; ok {
+			return c.operand(x.X, x.Op, c.ops[x.Op].level) + " " + op + " " + c.operand(x.Y, x.Op, c.ops[x.Op].level+1)
+		}
+	case *ast.Unary:
+		if p, ok := x.X.(*ast.Paren); ok && x.Op == "$NOT" {
+			return c.expr(p.X)
+		} else if x.Op == "$NOT" {
+			return c.expr(x.X)
+		}
+	case *ast.Ident, *ast.Paren:
+		return "!" + c.expr(x)
+	}
+	return "!(" + c.expr(x) + ")"
+}
+
+func (c *cWriter) block(list []ast.Stmt) {
+	c.level++
+	for _, s := range list {
+		c.stmt(s)
+	}
+	c.level--
+}
+
+func (c *cWriter) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.Assign:
+		c.line("%v = %v; // line %v", c.name(s.Sym), c.expr(s.X), s.Line)
+
+	case *ast.Input:
+		comment := fmt.Sprintf(" // line %v", s.Line)
+		formats, args := "", []string{}
+		flush := func() {
+			if len(args) > 0 {
+				c.line("scanf(\"%v%%*c\", %v);%v", formats, strings.Join(args, ", "), comment)
+				formats, args, comment = "", nil, ""
+			}
+		}
+		for _, id := range s.Names {
+			switch id.Sym.Typ {
+			case "$CHARRAY":
+				flush()
+				c.line("scanf(\"%%c\", &%v);%v", c.name(id.Sym), comment)
+				comment = ""
+			case "$BOOL":
+				flush()
+				c.line("%v = read_bool();%v", c.name(id.Sym), comment)
+				comment = ""
+			default:
+				formats += c.ints[1]
+				args = append(args, "&"+c.name(id.Sym))
+			}
+		}
+		flush()
+
+	case *ast.Output:
+		comment := fmt.Sprintf(" // line %v", s.Line)
+		for _, x := range s.Exprs {
+			switch {
+			case x.Type() == "$CHARRAY":
+				c.line("write_char(%v);%v", c.expr(x), comment)
+			case x.Type() == "$BOOL" && c.need.writeChar:
+				c.line("write_bool(%v);%v", c.expr(x), comment)
+			case x.Type() == "$BOOL":
+				c.line("printf(\"%%s\\n\", %v ? \"true\" : \"false\");%v", c.expr(x), comment)
+			case c.need.writeChar:
+				c.line("write_number(%v);%v", c.expr(x), comment)
+			case x.Value() != em.EMPTY && c.ints[0] == "long long": // a constant is an int
+				c.line("printf(\"%v\\n\", (long long) %v);%v", c.ints[2], c.operand(x, "", 1<<10), comment)
+			default:
+				c.line("printf(\"%v\\n\", %v);%v", c.ints[2], c.expr(x), comment)
+			}
+			comment = ""
+		}
+
+	case *ast.While:
+		c.line("while (%v) { // line %v", c.expr(s.Cond), s.Line)
+		c.block(s.Body)
+		c.line("}")
+
+	case *ast.Repeat:
+		c.line("do { // line %v", s.Line)
+		c.block(s.Body)
+		c.line("} while (%v); // line %v", c.negate(s.Cond), s.Until.Line)
+
+	case *ast.If:
+		c.line("if (%v) { // line %v", c.expr(s.Cond), s.Line)
+		c.block(s.Then)
+		for _, elif := range s.Elifs {
+			c.line("} else if (%v) { // line %v", c.expr(elif.Cond), elif.Line)
+			c.block(elif.Body)
+		}
+		if s.Else != nil {
+			c.line("} else { // line %v", s.Else.Line)
+			c.block(s.Else.Body)
+		}
+		c.line("}")
+
+	case *ast.Case:
+		if !constLabels(s) {
+			c.caseIf(s)
+			break
+		}
+		taken, dropped := labels(s, c.expr)
+		c.line("switch (%v) { // line %v", c.expr(s.X), s.Line)
+		for _, label := range taken {
+			c.line("case %v: // line %v", c.expr(label.X), label.Line)
+			c.block(label.Body)
+			c.level++
+			c.line("break;")
+			c.level--
+		}
+		if s.Default != nil {
+			c.line("default: // line %v", s.Default.Line)
+			c.block(s.Default.Body)
+		}
+		c.line("}")
+		for _, label := range dropped {
+			c.line("// line %v, %v is taken by an earlier label", label.Line, c.expr(label.X))
+		}
+	}
+}
+
+// a case of labels known only at run time, the first that is equal is taken
+func (c *cWriter) caseIf(s *ast.Case) {
+	x := c.operand(s.X, "$EQ", c.ops["$EQ"].level)
+	for i, label := range s.Labels {
+		y := c.operand(label.X, "$EQ", c.ops["$EQ"].level+1)
+		if i == 0 {
+			c.line("if (%v == %v) { // lines %v, %v", x, y, s.Line, label.Line)
+		} else {
+			c.line("} else if (%v == %v) { // line %v", x, y, label.Line)
+		}
+		c.block(label.Body)
+	}
+	if s.Default != nil {
+		c.line("} else { // line %v", s.Default.Line)
+		c.block(s.Default.Body)
+	}
+	c.line("}")
+}
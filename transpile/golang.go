@@ -0,0 +1,219 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"dap/ast"
+	em "dap/emulator"
+)
+
+/* Go, the declarations are those of the package, the algorithm is main,
+   an integer is the int of the word size
+*/
+
+var goNaming = naming{
+	reserved: set(
+		"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough", "for", "func",
+		"go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select", "struct",
+		"switch", "type", "var",
+		"any", "append", "bool", "byte", "cap", "close", "complex", "complex64", "complex128", "copy", "delete",
+		"error", "false", "float32", "float64", "imag", "int", "int8", "int16", "int32", "int64", "iota", "len",
+		"make", "new", "nil", "panic", "print", "println", "real", "recover", "rune", "string", "true",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"_", "main", "init", "fmt", "charLine", "writeChar", "writeLine"),
+	valid: func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) },
+}
+
+type goWriter struct {
+	syntax
+	buf     bytes.Buffer
+	names   map[string]string
+	intType string
+	chars   bool // characters are written, a number or a boolean may have to start a line
+}
+
+// prog as a Go main package, word is the size of an integer in bits
+func Go(prog *ast.Program, word int) ([]byte, error) {
+	list := declarations(prog)
+	need := needed(prog)
+	g := &goWriter{names: goNaming.names(prog, list), intType: "int" + strconv.Itoa(word), chars: need.writeChar}
+	g.syntax = syntax{
+		ops: map[string]binop{
+			"$MULT": {"*", 5}, "$DIV": {"/", 5}, "$MOD": {"%", 5},
+			"$PLUS": {"+", 4}, "$MINUS": {"-", 4},
+			"$EQ": {"==", 3}, "$NEQ": {"!=", 3}, "$LT": {"<", 3}, "$LEQ": {"<=", 3}, "$GT": {">", 3}, "$GEQ": {">=", 3},
+			"$AND": {"&&", 2},
+			"$OR": {"||", 1},
+		},
+		neg: "-", not: "!", true: "true", false: "false",
+		char: strconv.QuoteRune,
+		name: g.name,
+	}
+
+	fmt.Fprintf(&g.buf, "// Program %v, from %v, with the source line of each statement\n", prog.Name, filepath.Base(prog.Pos.File))
+	g.buf.WriteString("package main\n\n")
+	if need.input || need.output {
+		g.buf.WriteString("import \"fmt\"\n\n")
+	}
+	for _, d := range list {
+		g.decl(d)
+	}
+	fmt.Fprintf(&g.buf, "\nfunc main() { // line %v\n", prog.Code.Line)
+	g.block(prog.Body)
+	g.buf.WriteString("}\n")
+	if g.chars {
+		g.buf.WriteString(goWrite)
+	}
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%v: Go source of %v: %v", prog.Pos.File, prog.Name, err)
+	}
+	return src, nil
+}
+
+const goWrite = `
+// characters follow each other on a line, a number or a boolean after them starts a new line
+var charLine bool
+
+func writeChar(c rune) {
+	fmt.Printf("%c", c)
+	charLine = true
+}
+
+func writeLine(v interface{}) {
+	if charLine {
+		charLine = false
+		fmt.Println()
+	}
+	fmt.Println(v)
+}
+`
+
+func (g *goWriter) typ(t string) string {
+	switch t {
+	case "$BOOL":
+		return "bool"
+	case "$CHAR", "$CHARRAY":
+		return "rune"
+	}
+	return g.intType
+}
+
+func (g *goWriter) name(sym ast.Symbol) string {
+	if name, ok := g.names[key(sym.Unit, sym.Name)]; ok {
+		return name
+	}
+	return sym.Name
+}
+
+// a constant of a value known to the compiler is one in Go too, the others are variables
+func (g *goWriter) decl(d decl) {
+	switch x := d.Decl.(type) {
+	case *ast.Const:
+		kind := "const"
+		if x.Sym.Val == em.EMPTY {
+			kind = "var"
+		}
+		fmt.Fprintf(&g.buf, "%v %v = %v // line %v\n", kind, g.names[key(d.unit, x.Name)], g.expr(x.X), x.Line)
+	case *ast.Var:
+		if x.Type == "" {
+			return
+		}
+		names := []string{}
+		for _, id := range x.Names {
+			names = append(names, g.names[key(d.unit, id.Name)])
+		}
+		fmt.Fprintf(&g.buf, "var %v %v // line %v\n", strings.Join(names, ", "), g.typ(x.Type), x.Line)
+	}
+}
+
+func (g *goWriter) block(list []ast.Stmt) {
+	for _, s := range list {
+		g.stmt(s)
+	}
+}
+
+func (g *goWriter) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.Assign:
+		fmt.Fprintf(&g.buf, "%v = %v // line %v\n", g.name(s.Sym), g.expr(s.X), s.Line)
+
+	case *ast.Input: // a character is the next one, even a space or a new line
+		comment := fmt.Sprintf(" // line %v", s.Line)
+		scan := []string{}
+		flush := func() {
+			if len(scan) > 0 {
+				fmt.Fprintf(&g.buf, "fmt.Scan(%v)%v\n", strings.Join(scan, ", "), comment)
+				scan, comment = nil, ""
+			}
+		}
+		for _, id := range s.Names {
+			if id.Sym.Typ == "$CHARRAY" {
+				flush()
+				fmt.Fprintf(&g.buf, "fmt.Scanf(\"%%c\", &%v)%v\n", g.name(id.Sym), comment)
+				comment = ""
+			} else {
+				scan = append(scan, "&"+g.name(id.Sym))
+			}
+		}
+		flush()
+
+	case *ast.Output:
+		comment := fmt.Sprintf(" // line %v", s.Line)
+		for _, x := range s.Exprs {
+			switch {
+			case x.Type() == "$CHARRAY":
+				fmt.Fprintf(&g.buf, "writeChar(%v)%v\n", g.expr(x), comment)
+			case g.chars:
+				fmt.Fprintf(&g.buf, "writeLine(%v)%v\n", g.expr(x), comment)
+			default:
+				fmt.Fprintf(&g.buf, "fmt.Println(%v)%v\n", g.expr(x), comment)
+			}
+			comment = ""
+		}
+
+	case *ast.While:
+		fmt.Fprintf(&g.buf, "for %v { // line %v\n", g.expr(s.Cond), s.Line)
+		g.block(s.Body)
+		g.buf.WriteString("}\n")
+
+	case *ast.Repeat:
+		fmt.Fprintf(&g.buf, "for { // line %v\n", s.Line)
+		g.block(s.Body)
+		fmt.Fprintf(&g.buf, "if %v { // line %v\nbreak\n}\n}\n", g.expr(s.Cond), s.Until.Line)
+
+	case *ast.If:
+		fmt.Fprintf(&g.buf, "if %v { // line %v\n", g.expr(s.Cond), s.Line)
+		g.block(s.Then)
+		for _, elif := range s.Elifs {
+			fmt.Fprintf(&g.buf, "} else if %v { // line %v\n", g.expr(elif.Cond), elif.Line)
+			g.block(elif.Body)
+		}
+		if s.Else != nil {
+			fmt.Fprintf(&g.buf, "} else { // line %v\n", s.Else.Line)
+			g.block(s.Else.Body)
+		}
+		g.buf.WriteString("}\n")
+
+	case *ast.Case:
+		taken, dropped := labels(s, g.expr)
+		fmt.Fprintf(&g.buf, "switch %v { // line %v\n", g.expr(s.X), s.Line)
+		for _, label := range taken {
+			fmt.Fprintf(&g.buf, "case %v: // line %v\n", g.expr(label.X), label.Line)
+			g.block(label.Body)
+		}
+		if s.Default != nil {
+			fmt.Fprintf(&g.buf, "default: // line %v\n", s.Default.Line)
+			g.block(s.Default.Body)
+		}
+		g.buf.WriteString("}\n")
+		for _, label := range dropped {
+			fmt.Fprintf(&g.buf, "// line %v, %v is taken by an earlier label\n", label.Line, g.expr(label.X))
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package transpile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"dap/ast"
+	em "dap/emulator"
+)
+
+/* Pascal, as Free Pascal and Delphi take it, the declarations are
+   those of the program, its block is the algorithm. div truncates and
+   mod has the sign of the dividend, as on the machine. Pascal computes
+   in an integer wider than a small word, an arithmetic result is cast
+   back to the word, which wraps it around with range checks off, as
+   they are by default. A character is a byte, one beyond ASCII is ?.
+   The character read right after a number is the one that ends it,
+   the emulator skips it.
+*/
+
+var pascalNaming = naming{
+	reserved: set(
+		"and", "array", "begin", "case", "const", "div", "do", "downto", "else", "end", "file", "for",
+		"function", "goto", "if", "in", "label", "mod", "nil", "not", "of", "or", "otherwise", "packed",
+		"procedure", "program", "record", "repeat", "set", "then", "to", "type", "until", "var", "while",
+		"with", "xor", "shl", "shr", "string", "break", "continue", "exit",
+		"boolean", "char", "integer", "shortint", "smallint", "longint", "int64", "true", "false",
+		"read", "readln", "write", "writeln", "eof", "eoln", "chr", "ord",
+		"charline", "writechar", "writenumber", "writebool", "readbool"),
+	valid:      isASCII,
+	ignoreCase: true,
+}
+
+var pascalInts = map[int]string{8: "ShortInt", 16: "SmallInt", 32: "LongInt", 64: "Int64"}
+
+type pascalWriter struct {
+	syntax
+	source
+	names   map[string]string
+	intType string
+	need    needs
+}
+
+// prog as a Pascal program, word is the size of an integer in bits
+func Pascal(prog *ast.Program, word int) ([]byte, error) {
+	list := declarations(prog)
+	naming := pascalNaming
+	naming.reserved = set(strings.ToLower(naming.name(prog.Name)))
+	for w := range pascalNaming.reserved {
+		naming.reserved[w] = true
+	}
+	p := &pascalWriter{source: source{tab: "  "}, names: naming.names(prog, list), intType: pascalInts[word], need: needed(prog)}
+	p.syntax = syntax{
+		ops: map[string]binop{
+			"$MULT": {"*", 3}, "$DIV": {"div", 3}, "$MOD": {"mod", 3}, "$AND": {"and", 3},
+			"$PLUS": {"+", 2}, "$MINUS": {"-", 2}, "$OR": {"or", 2},
+			"$EQ": {"=", 1}, "$NEQ": {"<>", 1}, "$LT": {"<", 1}, "$LEQ": {"<=", 1}, "$GT": {">", 1}, "$GEQ": {">=", 1},
+		},
+		neg: "-", not: "not ", true: "true", false: "false",
+		char:     pascalChar,
+		name:     p.name,
+		negRight: true,
+	}
+	if word < 64 {
+		p.cast = func(inner string) string { return p.intType + "(" + inner + ")" }
+	}
+
+	p.line("{ Program %v, from %v, with the source line of each statement }", prog.Name, filepath.Base(prog.Pos.File))
+	p.line("program %v;", pascalNaming.name(prog.Name))
+	computed := p.decls(list)
+	p.helpers()
+	p.line("")
+	p.line("begin { line %v }", prog.Code.Line)
+	p.block(append(assignments(computed), prog.Body...))
+	p.line("end.")
+	return p.Bytes(), nil
+}
+
+func pascalChar(r rune) string {
+	switch {
+	case r == '\'':
+		return "''''"
+	case r >= ' ' && r < 127:
+		return "'" + string(r) + "'"
+	case r < ' ':
+		return fmt.Sprintf("#%d", r)
+	}
+	return "'?'"
+}
+
+func (p *pascalWriter) name(sym ast.Symbol) string {
+	if name, ok := p.names[key(sym.Unit, sym.Name)]; ok {
+		return name
+	}
+	return sym.Name
+}
+
+func (p *pascalWriter) typ(t string) string {
+	switch t {
+	case "$BOOL":
+		return "boolean"
+	case "$CHAR", "$CHARRAY":
+		return "char"
+	}
+	return p.intType
+}
+
+/* the constants of a value known to the compiler, then the variables,
+   a constant computed from variables is one of them, it is assigned
+   first, it is given back
+*/
+func (p *pascalWriter) decls(list []decl) (computed []decl) {
+	consts := []string{}
+	vars := []string{}
+	for _, d := range list {
+		switch x := d.Decl.(type) {
+		case *ast.Const:
+			name := p.names[key(d.unit, x.Name)]
+			if x.Sym.Val == em.EMPTY {
+				computed = append(computed, d)
+				vars = append(vars, fmt.Sprintf("%v: %v; { line %v }", name, p.typ(x.X.Type()), x.Line))
+			} else {
+				consts = append(consts, fmt.Sprintf("%v = %v; { line %v }", name, p.expr(x.X), x.Line))
+			}
+		case *ast.Var:
+			if x.Type == "" {
+				break
+			}
+			names := []string{}
+			for _, id := range x.Names {
+				names = append(names, p.names[key(d.unit, id.Name)])
+			}
+			vars = append(vars, fmt.Sprintf("%v: %v; { line %v }", strings.Join(names, ", "), p.typ(x.Type), x.Line))
+		}
+	}
+	if p.need.writeChar {
+		vars = append(vars, "charLine: boolean;")
+	}
+	for i, section := range [][]string{consts, vars} {
+		if len(section) == 0 {
+			continue
+		}
+		p.line("")
+		p.line("%v", []string{"const", "var"}[i])
+		p.level++
+		for _, l := range section {
+			p.line("%v", l)
+		}
+		p.level--
+	}
+	return
+}
+
+func (p *pascalWriter) helpers() {
+	if p.need.writeChar {
+		p.line("")
+		p.line("{ characters follow each other on a line, a number or a boolean after them starts a new line }")
+		p.line("procedure writeChar(c: char);\nbegin\n  write(c);\n  charLine := true\nend;")
+		p.line("")
+		p.line("procedure startLine;\nbegin\n  if charLine then\n    writeln;\n  charLine := false\nend;")
+		p.line("")
+		p.line("procedure writeNumber(n: %v);\nbegin\n  startLine;\n  writeln(n)\nend;", p.intType)
+	}
+	if p.need.writeBool {
+		p.line("")
+		p.line("procedure writeBool(b: boolean);\nbegin")
+		if p.need.writeChar {
+			p.line("  startLine;")
+		}
+		p.line("  if b then\n    writeln('true')\n  else\n    writeln('false')\nend;")
+	}
+	if p.need.readBool {
+		p.line("")
+		p.line("{ true is written 1, t, T, true, TRUE, or True, as the emulator reads it }")
+		p.line("function readBool: boolean;\nvar\n  c: char;\n  token: string;\nbegin")
+		p.line("  token := '';\n  c := ' ';\n  while (c in [' ', #9, #10, #13]) and not eof do\n    read(c);")
+		p.line("  while not (c in [' ', #9, #10, #13]) do\n  begin\n    token := token + c;\n    if eof then\n      c := ' '\n    else\n      read(c)\n  end;")
+		p.line("  readBool := (token = '1') or (token = 't') or (token = 'T') or (token = 'true') or (token = 'TRUE') or (token = 'True')\nend;")
+	}
+}
+
+func (p *pascalWriter) block(list []ast.Stmt) {
+	p.level++
+	for _, s := range list {
+		p.stmt(s)
+	}
+	p.level--
+}
+
+func (p *pascalWriter) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.Assign:
+		p.line("%v := %v; { line %v }", p.name(s.Sym), p.expr(s.X), s.Line)
+
+	case *ast.Input:
+		comment := fmt.Sprintf(" { line %v }", s.Line)
+		args := []string{}
+		flush := func() {
+			if len(args) > 0 {
+				p.line("read(%v);%v", strings.Join(args, ", "), comment)
+				args, comment = nil, ""
+			}
+		}
+		for _, id := range s.Names {
+			if id.Sym.Typ == "$BOOL" {
+				flush()
+				p.line("%v := readBool;%v", p.name(id.Sym), comment)
+				comment = ""
+			} else {
+				args = append(args, p.name(id.Sym))
+			}
+		}
+		flush()
+
+	case *ast.Output:
+		comment := fmt.Sprintf(" { line %v }", s.Line)
+		for _, x := range s.Exprs {
+			switch {
+			case x.Type() == "$CHARRAY":
+				p.line("writeChar(%v);%v", p.expr(x), comment)
+			case x.Type() == "$BOOL":
+				p.line("writeBool(%v);%v", p.expr(x), comment)
+			case p.need.writeChar:
+				p.line("writeNumber(%v);%v", p.expr(x), comment)
+			default:
+				p.line("writeln(%v);%v", p.expr(x), comment)
+			}
+			comment = ""
+		}
+
+	case *ast.While:
+		p.line("while %v do { line %v }", p.expr(s.Cond), s.Line)
+		p.compound(s.Body, ";")
+
+	case *ast.Repeat:
+		p.line("repeat { line %v }", s.Line)
+		p.block(s.Body)
+		p.line("until %v; { line %v }", p.expr(s.Cond), s.Until.Line)
+
+	case *ast.If:
+		p.line("if %v then { line %v }", p.expr(s.Cond), s.Line)
+		body := s.Then
+		for _, elif := range s.Elifs {
+			p.compound(body, "")
+			p.line("else if %v then { line %v }", p.expr(elif.Cond), elif.Line)
+			body = elif.Body
+		}
+		if s.Else == nil {
+			p.compound(body, ";")
+			break
+		}
+		p.compound(body, "")
+		p.line("else { line %v }", s.Else.Line)
+		p.compound(s.Else.Body, ";")
+
+	case *ast.Case:
+		if len(s.Labels) == 0 { // only otherwise, it is taken
+			if s.Default != nil {
+				p.line("{ line %v, %v }", s.Line, s.Default.Line)
+				p.level--
+				p.block(s.Default.Body)
+				p.level++
+			}
+			break
+		}
+		if !constLabels(s) {
+			p.caseIf(s)
+			break
+		}
+		taken, dropped := labels(s, p.expr)
+		p.line("case %v of { line %v }", p.expr(s.X), s.Line)
+		p.level++
+		for _, label := range taken {
+			p.line("%v: { line %v }", p.expr(label.X), label.Line)
+			p.compound(label.Body, ";")
+		}
+		for _, label := range dropped {
+			p.line("{ line %v, %v is taken by an earlier label }", label.Line, p.expr(label.X))
+		}
+		p.level--
+		if s.Default != nil {
+			p.line("else { line %v }", s.Default.Line)
+			p.block(s.Default.Body)
+		}
+		p.line("end;")
+	}
+}
+
+// list within begin and end, end is followed by the separator
+func (p *pascalWriter) compound(list []ast.Stmt, separator string) {
+	p.line("begin")
+	p.block(list)
+	p.line("end%v", separator)
+}
+
+// a case of labels known only at run time, the first that is equal is taken
+func (p *pascalWriter) caseIf(s *ast.Case) {
+	x := p.operand(s.X, "$EQ", p.ops["$EQ"].level)
+	for i, label := range s.Labels {
+		y := p.operand(label.X, "$EQ", p.ops["$EQ"].level+1)
+		if i == 0 {
+			p.line("if %v = %v then { lines %v, %v }", x, y, s.Line, label.Line)
+		} else {
+			p.line("else if %v = %v then { line %v }", x, y, label.Line)
+		}
+		if i < len(s.Labels)-1 || s.Default != nil {
+			p.compound(label.Body, "")
+		} else {
+			p.compound(label.Body, ";")
+		}
+	}
+	if s.Default != nil {
+		p.line("else { line %v }", s.Default.Line)
+		p.compound(s.Default.Body, ";")
+	}
+}
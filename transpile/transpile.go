@@ -0,0 +1,357 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"dap/ast"
+	em "dap/emulator"
+)
+
+/* a DAP program as the source of another language, for students
+   moving on, with the source line of each statement in a comment
+
+   The program does what the emulator does. Integers are words of the
+   machine size and wrap around, a character is a single one, a string
+   is its first character, as the machine keeps it. A number or
+   a boolean is written on a line of its own, characters follow each
+   other on a line. What the emulator only checks at run time is left
+   out, variables start as zero, an overflow always wraps around.
+*/
+
+// a declaration with the unit it belongs to, "" for the program
+type decl struct {
+	unit string
+	ast.Decl
+}
+
+/* the declarations of the units used by prog, each before those of
+   the units using it, then those of prog, as the codes evaluate them
+*/
+func declarations(prog *ast.Program) []decl {
+	list := []decl{}
+	done := map[*ast.Unit]bool{}
+	var unit func(u *ast.Unit)
+	unit = func(u *ast.Unit) {
+		if done[u] {
+			return
+		}
+		done[u] = true
+		for _, used := range u.Uses {
+			unit(used)
+		}
+		for _, d := range u.Decls {
+			list = append(list, decl{u.Name, d})
+		}
+	}
+	for _, u := range prog.Uses {
+		unit(u)
+	}
+	for _, d := range prog.Decls {
+		list = append(list, decl{"", d})
+	}
+	return list
+}
+
+func key(unit, name string) string {
+	return unit + ":" + name
+}
+
+// how a language takes names
+type naming struct {
+	reserved   map[string]bool // in lower case if the case is ignored
+	valid      func(r rune) bool
+	ignoreCase bool
+}
+
+func (n naming) fold(name string) string {
+	if n.ignoreCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// name as the language takes it, a letter it does not is _
+func (n naming) name(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if !n.valid(r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if n.reserved[n.fold(name)] {
+		name += "_"
+	}
+	return name
+}
+
+/* the name of each declared symbol in the target language, by unit:name,
+   a name declared in several units is qualified by its unit, one that is
+   reserved, or has a letter the target does not take, is changed
+*/
+func (n naming) names(prog *ast.Program, list []decl) map[string]string {
+	type declared struct{ unit, name string }
+	all := []declared{}
+	count := map[string]int{}
+	for _, d := range list {
+		switch x := d.Decl.(type) {
+		case *ast.Const:
+			all = append(all, declared{d.unit, x.Name})
+		case *ast.Var:
+			for _, id := range x.Names {
+				all = append(all, declared{d.unit, id.Name})
+			}
+		}
+	}
+	for _, d := range all {
+		count[n.fold(d.name)]++
+	}
+	renamed := map[string]string{}
+	for _, d := range all {
+		name := d.name
+		if count[n.fold(name)] > 1 {
+			unit := d.unit
+			if unit == "" {
+				unit = prog.Name
+			}
+			name = unit + "_" + name
+		}
+		renamed[key(d.unit, d.name)] = n.name(name)
+	}
+	return renamed
+}
+
+func set(words ...string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range words {
+		m[w] = true
+	}
+	return m
+}
+
+func isASCII(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+type binop struct {
+	op    string
+	level int // the higher, the tighter it binds
+}
+
+// how a language writes expressions
+type syntax struct {
+	ops         map[string]binop // by token type
+	neg, not    string           // they bind tightest
+	true, false string
+	char        func(r rune) string
+	name        func(sym ast.Symbol) string
+	negRight    bool                       // a negative right operand is within parentheses
+	group       func(op, inner string) bool // an operand within parentheses, though it binds tighter
+	cast        func(inner string) string   // an arithmetic result in the word, when the language computes in a wider one
+}
+
+var arithmetic = set("$PLUS", "$MINUS", "$MULT", "$DIV", "$MOD")
+
+// x is an arithmetic result the language casts to the word, one the compiler folded fits in it
+func (s *syntax) casts(x ast.Expr) bool {
+	if s.cast == nil || x.Value() != em.EMPTY {
+		return false
+	}
+	switch x := x.(type) {
+	case *ast.Unary:
+		return x.Op == "$MINUS"
+	case *ast.Binary:
+		return arithmetic[x.Op]
+	}
+	return false
+}
+
+func (s *syntax) expr(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return s.name(x.Sym)
+	case *ast.Literal:
+		switch x.Kind {
+		case "$TRUE":
+			return s.true
+		case "$FALSE":
+			return s.false
+		case "$CHARRAY": // its first character, as the codes have it
+			r, _ := utf8.DecodeRuneInString(x.Val)
+			return s.char(r)
+		}
+		if n, err := strconv.Atoi(x.Text); err == nil { // 010 is not octal
+			return strconv.Itoa(n)
+		}
+		return x.Text
+	case *ast.Paren:
+		return "(" + s.expr(x.X) + ")"
+	case *ast.Unary:
+		if wrapped(x) {
+			return x.Value()
+		}
+		op := s.neg
+		if x.Op == "$NOT" {
+			op = s.not
+		}
+		operand := s.operand(x.X, x.Op, 1<<10)
+		if strings.HasPrefix(operand, "-") {
+			operand = "(" + operand + ")"
+		}
+		if s.casts(x) {
+			return s.cast(op + operand)
+		}
+		return op + operand
+	case *ast.Binary:
+		if wrapped(x) {
+			return x.Value()
+		}
+		op := s.ops[x.Op]
+		right := s.operand(x.Y, x.Op, op.level+1)
+		if s.negRight && strings.HasPrefix(right, "-") {
+			right = "(" + right + ")"
+		}
+		text := s.operand(x.X, x.Op, op.level) + " " + op.op + " " + right
+		if s.casts(x) {
+			return s.cast(text)
+		}
+		return text
+	}
+	return ""
+}
+
+// x within parentheses if its operator ranks below level
+func (s *syntax) operand(x ast.Expr, op string, level int) string {
+	if b, ok := x.(*ast.Binary); ok && !wrapped(b) && !s.casts(b) {
+		if s.ops[b.Op].level < level || (s.group != nil && s.group(op, b.Op)) {
+			return "(" + s.expr(x) + ")"
+		}
+	}
+	return s.expr(x)
+}
+
+/* a constant beyond the word is not taken, one the compiler folded
+   and found to wrap around is written as its value
+*/
+func wrapped(x ast.Expr) bool {
+	if x.Type() != "$NUMBER" || x.Value() == em.EMPTY {
+		return false
+	}
+	val := func(e ast.Expr) *big.Int {
+		n, _ := new(big.Int).SetString(e.Value(), 10)
+		return n
+	}
+	exact := new(big.Int)
+	switch x := x.(type) {
+	case *ast.Unary:
+		a := val(x.X)
+		if a == nil {
+			return false
+		}
+		exact.Neg(a)
+	case *ast.Binary:
+		a, b := val(x.X), val(x.Y)
+		if a == nil || b == nil || b.Sign() == 0 {
+			return false
+		}
+		switch x.Op {
+		case "$PLUS":
+			exact.Add(a, b)
+		case "$MINUS":
+			exact.Sub(a, b)
+		case "$MULT":
+			exact.Mul(a, b)
+		case "$DIV":
+			exact.Quo(a, b)
+		case "$MOD":
+			exact.Rem(a, b)
+		}
+	default:
+		return false
+	}
+	folded := val(x)
+	return folded != nil && exact.Cmp(folded) != 0
+}
+
+// constants computed from variables, as the assignments that come first
+func assignments(computed []decl) []ast.Stmt {
+	list := []ast.Stmt{}
+	for _, d := range computed {
+		k := d.Decl.(*ast.Const)
+		list = append(list, &ast.Assign{Pos: k.Pos, Name: k.Name, Sym: k.Sym, X: k.X})
+	}
+	return list
+}
+
+/* the labels of a case, the first of a value is taken, the others
+   are left out, a language has a label once
+*/
+func labels(s *ast.Case, expr func(ast.Expr) string) (taken []*ast.CaseLabel, dropped []*ast.CaseLabel) {
+	seen := map[string]bool{}
+	for _, label := range s.Labels {
+		if value := expr(label.X); label.X.Value() != em.EMPTY && seen[value] {
+			dropped = append(dropped, label)
+		} else {
+			seen[value] = true
+			taken = append(taken, label)
+		}
+	}
+	return
+}
+
+// a case is a switch only if its labels are constants
+func constLabels(s *ast.Case) bool {
+	for _, label := range s.Labels {
+		if label.X.Value() == em.EMPTY {
+			return false
+		}
+	}
+	return true
+}
+
+// source indented by blocks
+type source struct {
+	bytes.Buffer
+	tab   string
+	level int
+}
+
+func (s *source) line(format string, args ...interface{}) {
+	s.WriteString(strings.Repeat(s.tab, s.level))
+	fmt.Fprintf(s, format, args...)
+	s.WriteString("\n")
+}
+
+// what the algorithm reads and writes, for the helpers a language needs
+type needs struct {
+	input, output bool
+	readBool      bool
+	writeChar     bool // a number or a boolean may then have to start a line
+	writeBool     bool
+}
+
+func needed(prog *ast.Program) needs {
+	var n needs
+	for _, s := range prog.Body {
+		ast.Inspect(s, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.Input:
+				n.input = true
+				for _, id := range node.Names {
+					n.readBool = n.readBool || id.Sym.Typ == "$BOOL"
+				}
+			case *ast.Output:
+				n.output = true
+				for _, x := range node.Exprs {
+					n.writeChar = n.writeChar || x.Type() == "$CHARRAY"
+					n.writeBool = n.writeBool || x.Type() == "$BOOL"
+				}
+			}
+			return true
+		})
+	}
+	return n
+}
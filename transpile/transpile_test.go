@@ -0,0 +1,135 @@
+package transpile_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/ast"
+	"dap/parser"
+	"dap/transpile"
+)
+
+var folder = filepath.Join("..", "testdata", "transpile")
+
+/* what the emulator writes for the program f of testdata/transpile,
+   from its .in, the tree of the program is for the transpilers
+*/
+func emulate(t *testing.T, f string, word int) (*ast.Program, string) {
+	compiler := parser.Compiler{SearchPath: []string{filepath.Join(folder, "lib")}, WordSize: word, Log: ioutil.Discard}
+	prog, err := compiler.CompileFile(f)
+	if err != nil {
+		t.Fatalf("%v: %v", f, err)
+	}
+	in, err := os.Open(strings.TrimSuffix(f, ".dap") + ".in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = in, out
+	prog.Machine.Emulate(1000000)
+	os.Stdin, os.Stdout = stdin, stdout
+	text, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog.Tree, string(text)
+}
+
+/* each program of testdata/transpile, in a word of 64 and of 8 bits,
+   transpiled, built in dir by build into the command it returns, runs
+   as the emulator runs it
+*/
+func runAll(t *testing.T, ext string, translate func(*ast.Program, int) ([]byte, error), build func(dir, src string) ([]string, error)) {
+	files, err := filepath.Glob(filepath.Join(folder, "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/transpile: %v", err)
+	}
+	for _, f := range files {
+		for _, word := range []int{64, 8} {
+			f, word := f, word
+			t.Run(fmt.Sprintf("%v/%v", filepath.Base(f), word), func(t *testing.T) {
+				tree, want := emulate(t, f, word)
+				src, err := translate(tree, word)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dir := t.TempDir()
+				file := filepath.Join(dir, "prog"+ext)
+				if err := ioutil.WriteFile(file, src, 0644); err != nil {
+					t.Fatal(err)
+				}
+				command, err := build(dir, file)
+				if err != nil {
+					t.Fatalf("%v\n%s", err, src)
+				}
+				in, err := ioutil.ReadFile(strings.TrimSuffix(f, ".dap") + ".in")
+				if err != nil {
+					t.Fatal(err)
+				}
+				cmd := exec.Command(command[0], command[1:]...)
+				cmd.Dir, cmd.Stdin = dir, bytes.NewReader(in)
+				got, err := cmd.Output()
+				if err != nil {
+					t.Fatalf("%v\n%s", err, src)
+				}
+				if string(got) != want {
+					t.Errorf("the output differs from the emulator's\nwant:\n%v\ngot:\n%s", want, got)
+				}
+			})
+		}
+	}
+}
+
+// a compiler run in dir, its messages are the error when it fails
+func compile(dir string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %v\n%s", name, err, out)
+	}
+	return nil
+}
+
+func TestGo(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go toolchain")
+	}
+	runAll(t, ".go", transpile.Go, func(dir, src string) ([]string, error) {
+		bin := filepath.Join(dir, "prog")
+		return []string{bin}, compile(dir, gobin, "build", "-o", bin, src)
+	})
+}
+
+func TestC(t *testing.T) {
+	cc, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("no gcc")
+	}
+	runAll(t, ".c", transpile.C, func(dir, src string) ([]string, error) {
+		bin := filepath.Join(dir, "prog")
+		return []string{bin}, compile(dir, cc, "-std=c99", "-Wall", "-Werror", "-fwrapv", "-o", bin, src)
+	})
+}
+
+func TestPascal(t *testing.T) {
+	fpc, err := exec.LookPath("fpc")
+	if err != nil {
+		t.Skip("no fpc")
+	}
+	runAll(t, ".pas", transpile.Pascal, func(dir, src string) ([]string, error) {
+		bin := filepath.Join(dir, "prog")
+		return []string{bin}, compile(dir, fpc, "-Mdelphi", "-o"+bin, src)
+	})
+}
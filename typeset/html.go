@@ -0,0 +1,61 @@
+package typeset
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+/* the source as a page, each line numbered, each piece in a span of
+   its kind, names and spaces are plain text
+*/
+
+const style = `body{font-family:sans-serif}
+pre.dap{font-family:monospace;font-size:14px;line-height:1.4;background:#f8f8f8;border:1px solid #ddd;padding:8px 12px}
+.ln{color:#999;user-select:none}
+.keyword{color:#00008b;font-weight:bold}
+.number{color:#098658}
+.string{color:#a31515}
+.comment{color:#008000;font-style:italic}
+.operator{color:#555}
+`
+
+var classes = map[string]bool{"keyword": true, "number": true, "string": true, "comment": true, "operator": true}
+
+func (d *Document) WriteHTML(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%v</title>\n<style>\n%v</style>\n</head>\n<body>\n",
+		html.EscapeString(d.Name), style)
+	fmt.Fprintf(&buf, "<p>%v</p>\n<pre class=\"dap\">", html.EscapeString(filepath.Base(d.file)))
+	no := 1
+	number := func() {
+		fmt.Fprintf(&buf, "<span class=\"ln\">%3d  </span>", no)
+		no++
+	}
+	number()
+	for i, p := range d.pieces {
+		// a piece over several lines has each of them numbered, a span does not cross a line
+		for j, text := range strings.Split(p.Text, "\n") {
+			if j > 0 {
+				buf.WriteString("\n")
+				if i < len(d.pieces)-1 || text != "" {
+					number()
+				}
+			}
+			if text == "" {
+				continue
+			}
+			if classes[p.Kind] {
+				fmt.Fprintf(&buf, "<span class=\"%v\">%v</span>", p.Kind, html.EscapeString(text))
+			} else {
+				buf.WriteString(html.EscapeString(text))
+			}
+		}
+	}
+	buf.WriteString("</pre>\n</body>\n</html>\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,449 @@
+package typeset
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"dap/ast"
+)
+
+/* LaTeX of the program, a document of its own, the algorithm
+   environment within it is what goes into slides, the preamble
+   names the keywords as the profile spells them
+
+   algorithm2e takes a block within braces, a statement ends with \;,
+   algpseudocode has a command that ends each block, and no case,
+   the preamble defines one.
+*/
+
+var Styles = []string{"algorithm2e", "algpseudocode"}
+
+type texWriter struct {
+	bytes.Buffer
+	d      *Document
+	notes  *comments
+	pseudo bool // algpseudocode, else algorithm2e
+	level  int
+}
+
+func (d *Document) WriteLaTeX(w io.Writer, style string) error {
+	if style != Styles[0] && style != Styles[1] {
+		return fmt.Errorf("LaTeX is typeset with either %v, not %v", strings.Join(Styles, " or "), style)
+	}
+	t := &texWriter{d: d, notes: d.comments(), pseudo: style == "algpseudocode"}
+	t.line("%% Program %v, from %v, typeset with %v", d.Name, filepath.Base(d.file), style)
+	t.line(`\documentclass{article}`)
+	t.line(`\usepackage[utf8]{inputenc}`)
+	if t.pseudo {
+		t.algpseudocode()
+	} else {
+		t.algorithm2e()
+	}
+	t.line("")
+	t.line(`\begin{document}`)
+	t.line("")
+	t.line(`\begin{algorithm}`)
+	t.line(`\caption{%v %v}`, t.word("$PROGRAM"), escape(d.Name))
+	if t.pseudo {
+		t.line(`\begin{algorithmic}[1]`)
+	}
+	t.decls(d.prog)
+	t.level-- // the algorithm is at the level of the dictionary
+	t.block(0, d.prog.Body, math.MaxInt32)
+	t.level++
+	if t.pseudo {
+		t.line(`\end{algorithmic}`)
+	}
+	t.line(`\end{algorithm}`)
+	t.line("")
+	t.line(`\end{document}`)
+	_, err := w.Write(t.Bytes())
+	return err
+}
+
+func (t *texWriter) algorithm2e() {
+	t.line(`\usepackage[ruled,linesnumbered]{algorithm2e}`)
+	t.line(`\SetKwInput{KwUses}{%v}`, t.word("$USES"))
+	t.line(`\SetKwInput{KwDict}{%v}`, t.word("$DICT"))
+	t.line(`\SetKw{KwInput}{%v}`, t.word("$INPUT"))
+	t.line(`\SetKw{KwOutput}{%v}`, t.word("$OUTPUT"))
+	t.line(`\SetKwFor{While}{%v}{%v}{%v}`, t.word("$WHILE"), t.word("$DO"), t.word("$ENDWHILE"))
+	t.line(`\SetKwRepeat{Repeat}{%v}{%v}`, t.word("$REPEAT"), t.word("$UNTIL"))
+	t.line(`\SetKwIF{If}{ElseIf}{Else}{%v}{%v}{%v}{%v}{%v}`,
+		t.word("$IF"), t.word("$THEN"), t.word("$ELIF"), t.word("$ELSE"), t.word("$ENDIF"))
+	t.line(`\SetKwSwitch{Switch}{Case}{Other}{%v}{}{}{%v:}{}{%v}`, t.word("$CASE"), t.word("$DEFAULT"), t.word("$ENDCASE"))
+}
+
+func (t *texWriter) algpseudocode() {
+	t.line(`\usepackage{algorithm}`)
+	t.line(`\usepackage{algpseudocode}`)
+	for _, kw := range []struct{ name, typ string }{
+		{"while", "$WHILE"}, {"do", "$DO"}, {"repeat", "$REPEAT"}, {"until", "$UNTIL"},
+		{"if", "$IF"}, {"then", "$THEN"}, {"else", "$ELSE"},
+	} {
+		t.line(`\algrenewcommand\algorithmic%v{\textbf{%v}}`, kw.name, t.word(kw.typ))
+	}
+	t.line(`\algrenewtext{ElsIf}[1]{\textbf{%v} #1 \algorithmicthen}`, t.word("$ELIF"))
+	t.line(`\algrenewtext{EndWhile}{\textbf{%v}}`, t.word("$ENDWHILE"))
+	t.line(`\algrenewtext{EndIf}{\textbf{%v}}`, t.word("$ENDIF"))
+	t.line(`\algdef{SE}[CASE]{Case}{EndCase}[1]{\textbf{%v} #1 \textbf{%v}}{\textbf{%v}}`, t.word("$CASE"), t.word("$OF"), t.word("$ENDCASE"))
+	t.line(`\algdef{SE}[LABEL]{Label}{EndLabel}[1]{#1:}{}`)
+	t.line(`\algtext*{EndLabel}`)
+	t.line(`\algdef{SE}[OTHERWISE]{Otherwise}{EndOtherwise}{\textbf{%v}:}{}`, t.word("$DEFAULT"))
+	t.line(`\algtext*{EndOtherwise}`)
+}
+
+func (t *texWriter) line(format string, args ...interface{}) {
+	t.WriteString(strings.Repeat("  ", t.level))
+	fmt.Fprintf(t, format, args...)
+	t.WriteString("\n")
+}
+
+// a keyword as the profile spells it
+func (t *texWriter) word(typ string) string {
+	return escape(t.d.spell(typ))
+}
+
+// a keyword within a line
+func (t *texWriter) keyword(typ string) string {
+	if t.pseudo {
+		return `\textbf{` + t.word(typ) + "}"
+	}
+	return `\KwSty{` + t.word(typ) + "}"
+}
+
+// the uses and the declarations of the program, under the keyword of the dictionary
+func (t *texWriter) decls(prog *ast.Program) {
+	if len(prog.Uses) > 0 {
+		names := []string{}
+		for _, u := range prog.Uses {
+			names = append(names, escape(u.Name))
+		}
+		if t.pseudo {
+			t.line(`\Statex %v %v`, t.keyword("$USES"), strings.Join(names, ", "))
+		} else {
+			t.line(`\KwUses{%v}`, strings.Join(names, ", "))
+		}
+	}
+	if t.pseudo && len(prog.Decls) > 0 {
+		t.line(`\Statex %v`, t.keyword("$DICT"))
+	}
+	for _, d := range prog.Decls {
+		text := ""
+		switch x := d.(type) {
+		case *ast.Const:
+			text = fmt.Sprintf("%v $%v = %v$", t.keyword("$CONST"), name(x.Name), t.expr(x.X))
+		case *ast.Var:
+			names := []string{}
+			for _, id := range x.Names {
+				names = append(names, name(id.Name))
+			}
+			text = "$" + strings.Join(names, ", ") + "$"
+			if x.Type != "" {
+				text += " : " + t.keyword(x.Type)
+			}
+		}
+		t.comments(t.notes.before(d.Position().Line + 1))
+		if t.pseudo {
+			t.line(`\Statex \hspace{\algorithmicindent}%v`, text)
+		} else {
+			t.line(`\KwDict{%v}`, text)
+		}
+	}
+	t.comments(t.notes.before(prog.Code.Line + 1))
+	if t.pseudo {
+		t.line(`\Statex %v`, t.keyword("$CODE"))
+	} else {
+		t.line(`\BlankLine`)
+	}
+}
+
+// comments on lines of their own
+func (t *texWriter) comments(list []string) {
+	for _, c := range list {
+		if t.pseudo {
+			t.line(`\Statex \(\triangleright\) %v`, escape(c))
+		} else {
+			t.line(`\tcp{%v}`, escape(c))
+		}
+	}
+}
+
+/* the statements of a block, the comments on the line of its head
+   come first, those after its statements up to end last
+*/
+func (t *texWriter) block(head int, list []ast.Stmt, end int) {
+	t.level++
+	if c := t.notes.on(head); c != "" {
+		t.comments([]string{c})
+	}
+	for _, s := range list {
+		t.comments(t.notes.before(s.Position().Line))
+		t.stmt(s)
+	}
+	t.comments(t.notes.before(end))
+	t.level--
+}
+
+// a statement of a single line, with the comment on it
+func (t *texWriter) simple(text string, line int) {
+	c := t.notes.on(line)
+	switch {
+	case t.pseudo && c != "":
+		t.line(`\State %v \Comment{%v}`, text, escape(c))
+	case t.pseudo:
+		t.line(`\State %v`, text)
+	case c != "":
+		t.line(`%v\tcp*{%v}`, text, escape(c))
+	default:
+		t.line(`%v\;`, text)
+	}
+}
+
+func (t *texWriter) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.Assign:
+		t.simple(fmt.Sprintf(`$%v \gets %v$`, name(s.Name), t.expr(s.X)), s.Line)
+
+	case *ast.Input:
+		names := []string{}
+		for _, id := range s.Names {
+			names = append(names, name(id.Name))
+		}
+		t.simple(t.io("$INPUT", names), s.Line)
+
+	case *ast.Output:
+		exprs := []string{}
+		for _, x := range s.Exprs {
+			exprs = append(exprs, t.expr(x))
+		}
+		t.simple(t.io("$OUTPUT", exprs), s.Line)
+
+	case *ast.While:
+		end := math.MaxInt32
+		if s.EndWhile != nil {
+			end = s.EndWhile.Line + 1
+		}
+		if t.pseudo {
+			t.line(`\While{$%v$}`, t.expr(s.Cond))
+			t.block(s.Line, s.Body, end)
+			t.line(`\EndWhile`)
+		} else {
+			t.line(`\While{$%v$}{`, t.expr(s.Cond))
+			t.block(s.Line, s.Body, end)
+			t.line("}")
+		}
+
+	case *ast.Repeat:
+		if t.pseudo {
+			t.line(`\Repeat`)
+			t.block(s.Line, s.Body, s.Until.Line+1)
+			t.line(`\Until{$%v$}`, t.expr(s.Cond))
+		} else {
+			t.line(`\Repeat{$%v$}{`, t.expr(s.Cond))
+			t.block(s.Line, s.Body, s.Until.Line+1)
+			t.line("}")
+		}
+
+	case *ast.If:
+		t.ifStmt(s)
+
+	case *ast.Case:
+		t.caseStmt(s)
+	}
+}
+
+// input or output of the items
+func (t *texWriter) io(typ string, items []string) string {
+	if t.pseudo {
+		return fmt.Sprintf("%v $%v$", t.keyword(typ), strings.Join(items, ", "))
+	}
+	macro := `\KwInput`
+	if typ == "$OUTPUT" {
+		macro = `\KwOutput`
+	}
+	return fmt.Sprintf("%v{$%v$}", macro, strings.Join(items, ", "))
+}
+
+/* an if, in algorithm2e an if and its else is \eIf, an if followed
+   by more branches is \uIf, the last is \ElseIf or \Else
+*/
+func (t *texWriter) ifStmt(s *ast.If) {
+	type branch struct {
+		cond ast.Expr
+		line int
+		body []ast.Stmt
+	}
+	branches := []branch{{s.Cond, s.Line, s.Then}}
+	for _, elif := range s.Elifs {
+		branches = append(branches, branch{elif.Cond, elif.Line, elif.Body})
+	}
+	if s.Else != nil {
+		branches = append(branches, branch{nil, s.Else.Line, s.Else.Body})
+	}
+	end := s.End.Line + 1
+	if s.EndIf != nil {
+		end = s.EndIf.Line + 1
+	}
+	for i, b := range branches {
+		next := end
+		if i < len(branches)-1 {
+			next = branches[i+1].line
+		}
+		if t.pseudo {
+			switch {
+			case i == 0:
+				t.line(`\If{$%v$}`, t.expr(b.cond))
+			case b.cond == nil:
+				t.line(`\Else`)
+			default:
+				t.line(`\ElsIf{$%v$}`, t.expr(b.cond))
+			}
+			t.block(b.line, b.body, next)
+			continue
+		}
+		last := i == len(branches)-1
+		switch {
+		case i == 0 && len(branches) == 1:
+			t.line(`\If{$%v$}{`, t.expr(b.cond))
+		case i == 0 && len(branches) == 2 && s.Else != nil:
+			t.line(`\eIf{$%v$}{`, t.expr(b.cond))
+		case i == 0:
+			t.line(`\uIf{$%v$}{`, t.expr(b.cond))
+		case b.cond == nil && len(branches) == 2:
+			t.line("}{")
+		case b.cond == nil:
+			t.line(`\Else{`)
+		case last:
+			t.line(`\ElseIf{$%v$}{`, t.expr(b.cond))
+		default:
+			t.line(`\uElseIf{$%v$}{`, t.expr(b.cond))
+		}
+		t.block(b.line, b.body, next)
+		if last || len(branches) != 2 || s.Else == nil {
+			t.line("}")
+		}
+	}
+	if t.pseudo {
+		t.line(`\EndIf`)
+	}
+}
+
+func (t *texWriter) caseStmt(s *ast.Case) {
+	end := math.MaxInt32
+	if s.EndCase != nil {
+		end = s.EndCase.Line + 1
+	}
+	if t.pseudo {
+		t.line(`\Case{$%v$}`, t.expr(s.X))
+	} else {
+		t.line(`\Switch{$%v$ %v}{`, t.expr(s.X), t.keyword("$OF"))
+	}
+	t.level++
+	if c := t.notes.on(s.Line); c != "" {
+		t.comments([]string{c})
+	}
+	for i, label := range s.Labels {
+		next := end
+		if i < len(s.Labels)-1 {
+			next = s.Labels[i+1].Line
+		} else if s.Default != nil {
+			next = s.Default.Line
+		}
+		t.comments(t.notes.before(label.Line))
+		switch {
+		case t.pseudo:
+			t.line(`\Label{$%v$}`, t.expr(label.X))
+		case i < len(s.Labels)-1 || s.Default != nil:
+			t.line(`\uCase{$%v$:}{`, t.expr(label.X))
+		default:
+			t.line(`\Case{$%v$:}{`, t.expr(label.X))
+		}
+		t.block(label.Line, label.Body, next)
+		if t.pseudo {
+			t.line(`\EndLabel`)
+		} else {
+			t.line("}")
+		}
+	}
+	if s.Default != nil {
+		t.comments(t.notes.before(s.Default.Line))
+		if t.pseudo {
+			t.line(`\Otherwise`)
+		} else {
+			t.line(`\Other{`)
+		}
+		t.block(s.Default.Line, s.Default.Body, end)
+		if t.pseudo {
+			t.line(`\EndOtherwise`)
+		} else {
+			t.line("}")
+		}
+	}
+	t.level--
+	if t.pseudo {
+		t.line(`\EndCase`)
+	} else {
+		t.line("}")
+	}
+}
+
+// relations, and operators that are words, as mathematics writes them
+var mathOps = map[string]string{
+	"$MULT": `\times`, "$EQ": "=", "$NEQ": `\neq`, "$LEQ": `\leq`, "$GEQ": `\geq`, "$LT": "<", "$GT": ">",
+	"$PLUS": "+", "$MINUS": "-",
+}
+
+// x in math mode
+func (t *texWriter) expr(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return name(x.Name)
+	case *ast.Literal:
+		switch x.Kind {
+		case "$TRUE", "$FALSE":
+			return `\textbf{` + t.word(x.Kind) + "}"
+		case "$CHARRAY":
+			text := x.Text
+			if text != "" { // the closing quote is not kept
+				text += text[:1]
+			}
+			return `\texttt{` + escape(text) + "}"
+		}
+		return x.Text
+	case *ast.Paren:
+		return "(" + t.expr(x.X) + ")"
+	case *ast.Unary:
+		if x.Op == "$NOT" {
+			return `\textbf{` + t.word("$NOT") + `}\ ` + t.expr(x.X)
+		}
+		return "-" + t.expr(x.X)
+	case *ast.Binary:
+		op, ok := mathOps[x.Op]
+		if !ok {
+			op = `\mathbin{\textbf{` + t.word(x.Op) + "}}"
+		}
+		return t.expr(x.X) + " " + op + " " + t.expr(x.Y)
+	}
+	return ""
+}
+
+// a name in math mode, a long one in italics as a word
+func name(s string) string {
+	if len(s) == 1 {
+		return s
+	}
+	return `\mathit{` + escape(s) + "}"
+}
+
+var escapes = strings.NewReplacer(
+	`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "$", `\$`, "&", `\&`, "#", `\#`, "%", `\%`, "_", `\_`,
+	"^", `\^{}`, "~", `\~{}`)
+
+// text with the characters LaTeX takes as commands escaped
+func escape(s string) string {
+	return escapes.Replace(s)
+}
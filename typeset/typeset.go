@@ -0,0 +1,82 @@
+package typeset
+
+import (
+	"sort"
+	"strings"
+	"dap/ast"
+	"dap/scanner"
+)
+
+/* a DAP program for slides, exams, and web pages
+
+   LaTeX is typeset from the syntax tree, in the algorithm2e or the
+   algpseudocode package, with the keywords of the program's profile.
+   A comment on the line of a statement follows it, the others stand on
+   a line of their own, pragmas are left out. HTML is the source as it
+   is written, its keywords, names, numbers, strings, and comments
+   highlighted.
+*/
+
+type Document struct {
+	Name   string
+	file   string
+	prog   *ast.Program
+	spell  func(string) string
+	pieces []scanner.Piece
+}
+
+// the document of prog, compiled from src, spell gives how the profile writes a token type
+func New(prog *ast.Program, src []byte, spell func(typ string) string) (*Document, error) {
+	pieces, err := scanner.Pieces(prog.Pos.File, src)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{Name: prog.Name, file: prog.Pos.File, prog: prog, spell: spell, pieces: pieces}, nil
+}
+
+// comments by the line they start on, not yet written
+type comments struct {
+	text  map[int][]string
+	lines []int // sorted
+}
+
+func (d *Document) comments() *comments {
+	c := &comments{text: map[int][]string{}}
+	for _, p := range d.pieces {
+		if p.Kind != "comment" {
+			continue
+		}
+		if _, _, ok := scanner.ParsePragma(p.Body); ok {
+			continue
+		}
+		text := strings.Join(strings.Fields(p.Body), " ")
+		if text == "" {
+			continue
+		}
+		if len(c.text[p.Line]) == 0 {
+			c.lines = append(c.lines, p.Line)
+		}
+		c.text[p.Line] = append(c.text[p.Line], text)
+	}
+	sort.Ints(c.lines)
+	return c
+}
+
+// the comments before line, each on its own
+func (c *comments) before(line int) []string {
+	list := []string{}
+	for len(c.lines) > 0 && c.lines[0] < line {
+		list = append(list, c.text[c.lines[0]]...)
+		c.lines = c.lines[1:]
+	}
+	return list
+}
+
+// the comments on line, as one
+func (c *comments) on(line int) string {
+	if len(c.lines) == 0 || c.lines[0] != line {
+		return ""
+	}
+	c.lines = c.lines[1:]
+	return strings.Join(c.text[line], "; ")
+}
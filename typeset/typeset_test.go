@@ -0,0 +1,68 @@
+package typeset_test
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/parser"
+	"dap/typeset"
+)
+
+var update = flag.Bool("update", false, "write the .tex and .html files of testdata/typeset")
+
+/* each program of testdata/typeset as its .algorithm2e.tex and its
+   .algpseudocode.tex typeset it in LaTeX, and its .html highlights it
+*/
+func TestTypeset(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "testdata", "typeset", "*.dap"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/typeset: %v", err)
+	}
+	for _, f := range files {
+		compiler := parser.Compiler{Log: ioutil.Discard}
+		prog, err := compiler.CompileFile(f)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := typeset.New(prog.Tree, src, prog.Spelling)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		writers := map[string]func(w io.Writer) error{".html": doc.WriteHTML}
+		for _, style := range typeset.Styles {
+			style := style
+			writers["."+style+".tex"] = func(w io.Writer) error { return doc.WriteLaTeX(w, style) }
+		}
+		for ext, write := range writers {
+			var got bytes.Buffer
+			if err := write(&got); err != nil {
+				t.Fatal(err)
+			}
+			golden := strings.TrimSuffix(f, ".dap") + ext
+			if *update {
+				if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Errorf("%v: %v", f, err)
+				continue
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("%v: the document differs from %v\nwant:\n%s\ngot:\n%s", f, filepath.Base(golden), want, got.Bytes())
+			}
+		}
+	}
+}
@@ -23,7 +23,7 @@ func indexHandler(asset string) http.Handler {
 			log.Fatal(err)
 		}
 		fhtml.Close()
-		fmt.Fprintf(w, string(html))
+		w.Write(html)
 	})
 }
 
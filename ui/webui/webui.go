@@ -49,6 +49,8 @@ var (
 	trace         []tagValue
 	symbols       map[string]nameattr
 	line2off      map[int]string
+	sources       []string // main program, then the used units
+	lastfile      = 0
 	linecount     = 0
 	// line2off      map[int]assgattr
 	d            = dom.GetWindow().Document()
@@ -80,14 +82,16 @@ func doAnimation() {
 				}
 				linesPrg := strings.FieldsFunc(v.V.(string), f)
 			*/
-			linesPrg := strings.Split(v.V.(string), "\n")
-			srcPrg := ""
-			for i, l := range linesPrg {
-				srcPrg += "<pre style='margin:0px;' id=L:" + strconv.Itoa(i+1) + "> " + l + "</pre>"
-			}
-			area_program.SetInnerHTML(srcPrg)
+			sources = []string{v.V.(string)}
+			lastfile = 0
+			showSource(lastfile)
 			// log.Print(srcPrg)
 
+		case 'U': // sources of the used units, shown when executed
+			for _, usrc := range v.V.([]interface{}) {
+				sources = append(sources, usrc.(map[string]interface{})["Source"].(string))
+			}
+
 		case 'D': // symbol table, store internally
 			// along with 'A' to aid the 'V' memory area
 			// log.Print(v.V)
@@ -165,6 +169,13 @@ func doAnimation() {
 				// msg: from trace, and button selection
 				msg_field.Value = "Error: " + v.V.(string)
 
+			case 'F': // switch program area to another source file
+				if file := int(v.V.(float64)); file != lastfile && file < len(sources) {
+					lastfile = file
+					showSource(lastfile)
+					lastsrc = d.GetElementByID("L:1").(*dom.HTMLPreElement)
+				}
+
 			case 'L': // animate source program in program area
 				//  keep the linenum in case variable changes
 				if traceEachLine {
@@ -285,6 +296,15 @@ func doAnimation() {
 	}
 }
 
+func showSource(file int) {
+	linesPrg := strings.Split(sources[file], "\n")
+	srcPrg := ""
+	for i, l := range linesPrg {
+		srcPrg += "<pre style='margin:0px;' id=L:" + strconv.Itoa(i+1) + "> " + l + "</pre>"
+	}
+	area_program.SetInnerHTML(srcPrg)
+}
+
 func callServer() {
 	for runAnimation { // until 'X'
 		cmd := <-chCmd
@@ -0,0 +1,186 @@
+package xref
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"dap/ast"
+	em "dap/emulator"
+)
+
+/* cross reference of the names of a program, for each variable and
+   constant, where it is declared, where it is assigned, by an
+   assignment or an input, and where it is read
+
+   The names of the used units come first, as they are loaded. A place
+   in another file than the program's has its file.
+*/
+
+type Ref struct {
+	File string `json:"file,omitempty"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+type Entry struct {
+	Name     string `json:"name"`
+	Unit     string `json:"unit,omitempty"`
+	Kind     string `json:"kind"` // variable or constant
+	Type     string `json:"type"` // as the profile spells it
+	Value    string `json:"value,omitempty"`
+	Offset   int    `json:"offset,omitempty"` // of a variable, on the stack
+	Declared Ref    `json:"declared"`
+	Assigned []Ref  `json:"assigned"`
+	Read     []Ref  `json:"read"`
+}
+
+type Table struct {
+	Program string   `json:"program"`
+	File    string   `json:"file"`
+	Entries []*Entry `json:"entries"`
+
+	byKey map[string]*Entry // unit:name
+}
+
+// the cross reference of prog, spell gives how the profile writes a token type
+func New(prog *ast.Program, spell func(typ string) string) *Table {
+	t := &Table{Program: prog.Name, File: prog.Pos.File, Entries: []*Entry{}, byKey: map[string]*Entry{}}
+	for _, u := range prog.Units {
+		t.decls(u.Name, u.Decls, spell)
+	}
+	t.decls("", prog.Decls, spell)
+	for _, u := range prog.Units {
+		for _, d := range u.Decls {
+			ast.Inspect(d, t.use)
+		}
+	}
+	for _, d := range prog.Decls {
+		ast.Inspect(d, t.use)
+	}
+	for _, s := range prog.Body {
+		ast.Inspect(s, t.use)
+	}
+	return t
+}
+
+func key(sym ast.Symbol) string {
+	return sym.Unit + ":" + sym.Name
+}
+
+func (t *Table) ref(pos ast.Pos) Ref {
+	r := Ref{Line: pos.Line, Col: pos.Col}
+	if pos.File != t.File {
+		r.File = pos.File
+	}
+	return r
+}
+
+func (t *Table) decls(unit string, list []ast.Decl, spell func(string) string) {
+	add := func(e *Entry, sym ast.Symbol) {
+		e.Unit, e.Assigned, e.Read = unit, []Ref{}, []Ref{}
+		t.Entries = append(t.Entries, e)
+		t.byKey[key(sym)] = e
+	}
+	for _, d := range list {
+		switch d := d.(type) {
+		case *ast.Const:
+			typ := d.X.Type()
+			if typ == "$NUMBER" {
+				typ = "$INT"
+			}
+			e := &Entry{Name: d.Name, Kind: "constant", Type: spell(typ), Declared: t.ref(d.NamePos)}
+			if d.Sym.Val != em.EMPTY {
+				e.Value = d.Sym.Val
+			}
+			add(e, d.Sym)
+		case *ast.Var:
+			for _, id := range d.Names {
+				if id.Sym.Typ == "" {
+					continue
+				}
+				add(&Entry{Name: id.Name, Kind: "variable", Type: spell(d.Type), Offset: id.Sym.Loc, Declared: t.ref(id.Pos)}, id.Sym)
+			}
+		}
+	}
+}
+
+func (t *Table) use(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.Ident:
+		if e := t.byKey[key(n.Sym)]; e != nil {
+			e.Read = append(e.Read, t.ref(n.Pos))
+		}
+	case *ast.Assign:
+		if e := t.byKey[key(n.Sym)]; e != nil {
+			e.Assigned = append(e.Assigned, t.ref(n.Pos))
+		}
+	case *ast.Input:
+		for _, id := range n.Names {
+			if e := t.byKey[key(id.Sym)]; e != nil {
+				e.Assigned = append(e.Assigned, t.ref(id.Pos))
+			}
+		}
+		return false
+	case *ast.Var:
+		return false // the names are declared, not read
+	}
+	return true
+}
+
+// as an indented JSON object, ending with a newline
+func (t *Table) JSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(t)
+	return buf.Bytes(), err
+}
+
+// as a table, a name of a unit is qualified by it, places are lines
+func (t *Table) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tKIND\tTYPE\tOFFSET\tVALUE\tDECLARED\tASSIGNED\tREAD\n")
+	for _, e := range t.Entries {
+		name := e.Name
+		if e.Unit != "" {
+			name = e.Unit + "." + name
+		}
+		offset, value := "-", "-"
+		if e.Offset > 0 {
+			offset = fmt.Sprint(e.Offset)
+		}
+		if e.Value != "" {
+			value = e.Value
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", name, e.Kind, e.Type, offset, value,
+			lines([]Ref{e.Declared}), lines(e.Assigned), lines(e.Read))
+	}
+	return tw.Flush()
+}
+
+// the lines of refs, each once, a line of another file with its name
+func lines(refs []Ref) string {
+	list := []string{}
+	seen := map[Ref]bool{}
+	for _, r := range refs {
+		r.Col = 0
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		if r.File != "" {
+			list = append(list, fmt.Sprintf("%v:%v", filepath.Base(r.File), r.Line))
+		} else {
+			list = append(list, fmt.Sprint(r.Line))
+		}
+	}
+	if len(list) == 0 {
+		return "-"
+	}
+	return strings.Join(list, " ")
+}
@@ -0,0 +1,68 @@
+package xref_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"dap/parser"
+	"dap/xref"
+)
+
+var update = flag.Bool("update", false, "write the .txt and .json files of testdata/xref")
+
+/* the cross reference of each program of testdata/xref, as its .txt
+   prints it and its .json has it, the test runs in testdata/xref, the
+   table names the file of the program as it is given
+*/
+func TestTables(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join("..", "testdata", "xref")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+	files, err := filepath.Glob("*.dap")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no programs in testdata/xref: %v", err)
+	}
+	for _, f := range files {
+		compiler := parser.Compiler{Log: ioutil.Discard}
+		prog, err := compiler.CompileFile(f)
+		if err != nil {
+			t.Errorf("%v: %v", f, err)
+			continue
+		}
+		table := xref.New(prog.Tree, prog.Spelling)
+		var text bytes.Buffer
+		if err := table.WriteText(&text); err != nil {
+			t.Fatal(err)
+		}
+		data, err := table.JSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ext, got := range map[string][]byte{".txt": text.Bytes(), ".json": data} {
+			golden := strings.TrimSuffix(f, ".dap") + ext
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Errorf("%v: %v", f, err)
+				continue
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%v: the cross reference differs from %v\nwant:\n%s\ngot:\n%s", f, golden, want, got)
+			}
+		}
+	}
+}